@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+)
+
+const initAdminCommand = "init-admin"
+
+func isInitAdminCommand() bool {
+	return len(os.Args) > 1 && os.Args[1] == initAdminCommand
+}
+
+// initAdmin Create the first admin account, it refuses to run once an active admin exists.
+// The password is taken from INIT_ADMIN_PASSWORD or generated and printed once.
+func initAdmin(args []string) error {
+	flags := flag.NewFlagSet(initAdminCommand, flag.ContinueOnError)
+	name := flags.String("name", "Administrator", "display name of the admin")
+	username := flags.String("username", "admin", "username of the admin")
+	email := flags.String("email", "", "email of the admin (required)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	count, err := userService.CountActiveAdmins()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("an active admin already exists, manage users through /api/users")
+	}
+
+	password := os.Getenv("INIT_ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		length := utils.GeneratedPasswordLength
+		if appConfig.PasswordMinLength > length {
+			length = appConfig.PasswordMinLength
+		}
+		if password, err = utils.GeneratePassword(length); err != nil {
+			return err
+		}
+	}
+
+	admin, err := authService.SignUpUser(&models.SignUpInput{
+		Name:       *name,
+		Username:   *username,
+		Password:   password,
+		Email:      *email,
+		Role:       utils.AdminRole,
+		Verified:   true,
+		IsActive:   true,
+		AuthMethod: utils.BasicAuth,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Admin %s created with id %s\n", admin.Username, admin.ID.Hex())
+	if generated {
+		fmt.Printf("Generated password: %s\nChange it after the first login.\n", password)
+	}
+
+	return nil
+}
@@ -8,11 +8,15 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/thuongnn/clst-mgt-api/config"
 	"github.com/thuongnn/clst-mgt-api/controllers"
+	"github.com/thuongnn/clst-mgt-api/middleware"
 	"github.com/thuongnn/clst-mgt-api/routes"
 	"github.com/thuongnn/clst-mgt-api/services"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -33,10 +37,26 @@ var (
 	UserRouteController routes.UserRouteController
 
 	authCollection      *mongo.Collection
+	userCacheService    services.UserCacheService
 	authService         services.AuthService
+	rateLimitService    services.RateLimitService
+	tokenService        services.TokenService
+	apiTokenCollection  *mongo.Collection
+	apiTokenService     services.APITokenService
+	loginStateService   services.LoginStateService
+	mfaService          services.MFAService
+	throttleService     services.LoginThrottleService
+	attemptCollection   *mongo.Collection
+	attemptService      services.LoginAttemptService
 	AuthController      controllers.AuthController
 	AuthRouteController routes.AuthRouteController
 
+	// 👇 Create the Signing Keys Variables
+	signingKeyCollection     *mongo.Collection
+	signingKeyService        services.SigningKeyService
+	WellKnownController      controllers.WellKnownController
+	WellKnownRouteController routes.WellKnownRouteController
+
 	// 👇 Create the Nodes Variables
 	nodeService         services.NodeService
 	NodeController      controllers.NodeController
@@ -49,6 +69,13 @@ var (
 	ruleCollection      *mongo.Collection
 	RuleRouteController routes.RuleRouteController
 
+	// 👇 Create the Projects Variables
+	projectService         services.ProjectService
+	policyService          services.PolicyService
+	ProjectController      controllers.ProjectController
+	projectCollection      *mongo.Collection
+	ProjectRouteController routes.ProjectRouteController
+
 	// 👇 Create the History Scan Variables
 	historyScanService         services.HistoryScanService
 	HistoryScanController      controllers.HistoryScanController
@@ -65,6 +92,12 @@ var (
 	AuthMethodController   controllers.AuthMethodController
 	authMethodCollection   *mongo.Collection
 	SettingRouteController routes.SettingRouteController
+	SecretController       controllers.SecretController
+
+	// 👇 Create the Credentials Variables
+	credentialService    services.CredentialService
+	CredentialController controllers.CredentialController
+	credentialCollection *mongo.Collection
 )
 
 func init() {
@@ -78,7 +111,7 @@ func init() {
 
 	// Connect to MongoDB
 	mongoConnection := options.Client().ApplyURI(appConfig.DBUri)
-	mongoClient, err := mongo.Connect(ctx, mongoConnection)
+	mongoClient, err = mongo.Connect(ctx, mongoConnection)
 	if err != nil {
 		panic(err)
 	}
@@ -100,37 +133,54 @@ func init() {
 
 	fmt.Println("Redis successfully connected...")
 
-	// Connect to K8s cluster
-	kubeConfig, err := utils.GetKubeConfig(appConfig.Environment != config.DefaultEnvironment)
-	if err != nil {
-		panic(err)
-	}
-
-	k8sClient, err = kubernetes.NewForConfig(kubeConfig)
-	if err != nil {
-		panic(err)
+	// Connect to K8s cluster, bootstrapping an admin only needs the databases
+	if !isInitAdminCommand() {
+		kubeConfig, err := utils.GetKubeConfig(appConfig.Environment != config.DefaultEnvironment)
+		if err != nil {
+			panic(err)
+		}
+
+		k8sClient, err = kubernetes.NewForConfig(kubeConfig)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := utils.K8SHealth(k8sClient, ctx); err != nil {
+			panic(err)
+		}
+		fmt.Println("Kubernetes API successfully connected...")
 	}
 
-	if err := utils.K8SHealth(k8sClient, ctx); err != nil {
-		panic(err)
-	}
-	fmt.Println("Kubernetes API successfully connected...")
-
 	// 👇 Auth Method
 	authMethodCollection = mongoClient.Database(appConfig.DBName).Collection("auth_method")
 	authMethodService = services.NewAuthMethodService(authMethodCollection, ctx)
 	AuthMethodController = controllers.NewAuthMethodController(authMethodService)
-	SettingRouteController = routes.NewSettingControllerRoute(AuthMethodController)
 
 	// 👇 Auth
 	authCollection = mongoClient.Database(appConfig.DBName).Collection("users")
-	userService = services.NewUserServiceImpl(authCollection, ctx)
-	authService = services.NewAuthService(authCollection, ctx)
-	AuthController = controllers.NewAuthController(authMethodService, authService, userService, ctx, authCollection)
+	userCacheService = services.NewUserCacheService(redisClient, ctx)
+	userService = services.NewUserServiceImpl(authCollection, userCacheService, ctx)
+	projectCollection = mongoClient.Database(appConfig.DBName).Collection("projects")
+	projectService = services.NewProjectService(projectCollection, ctx)
+	authService = services.NewAuthService(authCollection, userCacheService, projectService, ctx)
+	rateLimitService = services.NewRateLimitService(redisClient, ctx)
+	signingKeyCollection = mongoClient.Database(appConfig.DBName).Collection("signing_keys")
+	signingKeyService = services.NewSigningKeyService(signingKeyCollection, ctx)
+	tokenService = services.NewTokenService(redisClient, signingKeyService, ctx)
+	apiTokenCollection = mongoClient.Database(appConfig.DBName).Collection("api_tokens")
+	apiTokenService = services.NewAPITokenService(apiTokenCollection, ctx)
+	loginStateService = services.NewLoginStateService(redisClient, ctx)
+	mfaService = services.NewMFAService(redisClient, ctx)
+	throttleService = services.NewLoginThrottleService(redisClient, ctx)
+	attemptCollection = mongoClient.Database(appConfig.DBName).Collection("login_attempts")
+	attemptService = services.NewLoginAttemptService(attemptCollection, ctx)
+	AuthController = controllers.NewAuthController(authMethodService, authService, userService, rateLimitService, tokenService, loginStateService, mfaService, throttleService, attemptService, ctx, authCollection)
 	AuthRouteController = routes.NewAuthRouteController(AuthController)
+	WellKnownController = controllers.NewWellKnownController(signingKeyService)
+	WellKnownRouteController = routes.NewWellKnownControllerRoute(WellKnownController)
 
 	// 👇 Users
-	UserController = controllers.NewUserController(userService)
+	UserController = controllers.NewUserController(userService, authService, tokenService, apiTokenService, throttleService, attemptService)
 	UserRouteController = routes.NewRouteUserController(UserController)
 
 	// 👇 Nodes
@@ -139,22 +189,37 @@ func init() {
 	NodeController = controllers.NewNodeController(nodeService)
 	NodeRouteController = routes.NewNodeControllerRoute(NodeController)
 
+	// 👇 Rules
+	ruleCollection = mongoClient.Database(appConfig.DBName).Collection("rules")
+	ruleService = services.NewRuleService(ruleCollection, ctx)
+
+	// 👇 Projects
+	policyService = services.NewPolicyService(projectService)
+	ProjectController = controllers.NewProjectController(projectService, ruleService, userService, policyService)
+	ProjectRouteController = routes.NewProjectControllerRoute(ProjectController, policyService)
+
 	// 👇 History Scan
 	historyScanCollection = mongoClient.Database(appConfig.DBName).Collection("history_scan")
 	historyScanService = services.NewHistoryScanService(historyScanCollection, ctx)
-	HistoryScanController = controllers.NewHistoryScanController(historyScanService)
+	HistoryScanController = controllers.NewHistoryScanController(historyScanService, ruleService, policyService)
 	HistoryScanRouteController = routes.NewHistoryScanControllerRoute(HistoryScanController)
 
-	// 👇 Rules
-	ruleCollection = mongoClient.Database(appConfig.DBName).Collection("rules")
-	ruleService = services.NewRuleService(ruleCollection, ctx)
-	RuleController = controllers.NewRuleController(ruleService, historyScanService)
+	RuleController = controllers.NewRuleController(ruleService, historyScanService, projectService, policyService)
 	RuleRouteController = routes.NewRuleControllerRoute(RuleController)
 
+	// 👇 Credentials
+	credentialCollection = mongoClient.Database(appConfig.DBName).Collection("credentials")
+	credentialService = services.NewCredentialService(credentialCollection, ctx)
+	CredentialController = controllers.NewCredentialController(credentialService, ruleService)
+
+	// 👇 Settings
+	SecretController = controllers.NewSecretController(authMethodService, credentialService, authService, signingKeyService)
+	SettingRouteController = routes.NewSettingControllerRoute(AuthMethodController, CredentialController, SecretController)
+
 	// 👇 Triggers
 	triggerService = services.NewTriggerService(redisClient, ctx)
-	TriggerController = controllers.NewTriggerController(triggerService)
-	TriggerRouteController = routes.NewTriggerControllerRoute(TriggerController)
+	TriggerController = controllers.NewTriggerController(triggerService, ruleService, policyService)
+	TriggerRouteController = routes.NewTriggerControllerRoute(TriggerController, policyService)
 
 	server = gin.Default()
 }
@@ -163,9 +228,47 @@ func main() {
 	defer mongoClient.Disconnect(ctx)
 	defer redisClient.Close()
 
+	if isInitAdminCommand() {
+		if err := initAdmin(os.Args[2:]); err != nil {
+			log.Fatal("Could not create the admin: ", err)
+		}
+		return
+	}
+
+	if err := migrateProjects(); err != nil {
+		log.Fatal("Could not migrate the rule projects: ", err)
+	}
+
+	go rotateSigningKeys()
+
 	startGinServer()
 }
 
+// rotateSigningKeys Check regularly whether the token signing keys are due for rotation
+func rotateSigningKeys() {
+	ticker := time.NewTicker(utils.SigningKeyRotationCheck)
+	defer ticker.Stop()
+
+	for {
+		if err := signingKeyService.RotateSigningKeys(false); err != nil {
+			log.Printf("could not rotate signing keys: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// trustedProxies Split the comma separated TRUSTED_PROXIES, nil trusts no proxy
+func trustedProxies(rawProxies string) []string {
+	var proxies []string
+	for _, proxy := range strings.Split(rawProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
+
 func startGinServer() {
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowOrigins = []string{appConfig.Origin}
@@ -173,18 +276,27 @@ func startGinServer() {
 
 	server.Use(cors.New(corsConfig))
 
+	// gin trusts every proxy by default, so anyone could pick the IP the rate limits and lockouts see
+	if err := server.SetTrustedProxies(trustedProxies(appConfig.TrustedProxies)); err != nil {
+		log.Fatal("Could not parse the trusted proxies: ", err)
+	}
+
 	router := server.Group("/api")
 	router.GET("/health", func(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, gin.H{"status": "OK"})
 	})
 
-	AuthRouteController.AuthRoute(router, userService)
-	UserRouteController.UserRoute(router, userService)
-	NodeRouteController.NodeRoute(router, userService)
-	RuleRouteController.RuleRoute(router, userService)
-	HistoryScanRouteController.HistoryScanRoute(router, userService)
-	TriggerRouteController.TriggerRoute(router, userService)
-	SettingRouteController.SettingRoute(router, userService)
+	deserializeUser := middleware.DeserializeUser(userService, tokenService, apiTokenService)
+
+	WellKnownRouteController.WellKnownRoute(router)
+	AuthRouteController.AuthRoute(router, deserializeUser)
+	UserRouteController.UserRoute(router, deserializeUser)
+	NodeRouteController.NodeRoute(router, deserializeUser)
+	RuleRouteController.RuleRoute(router, deserializeUser)
+	ProjectRouteController.ProjectRoute(router, deserializeUser)
+	HistoryScanRouteController.HistoryScanRoute(router, deserializeUser)
+	TriggerRouteController.TriggerRoute(router, deserializeUser)
+	SettingRouteController.SettingRoute(router, deserializeUser)
 
 	log.Fatal(server.Run(":" + appConfig.Port))
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrateProjects Create a project for every project name rules were tagged with before projects existed,
+// the owners of its rules become editors. Projects that exist already are left alone, so it runs on every start.
+func migrateProjects() error {
+	owners, err := ruleService.GetProjectOwners()
+	if err != nil {
+		return err
+	}
+
+	members := make(map[string][]primitive.ObjectID, len(owners))
+	for project, emails := range owners {
+		members[project] = []primitive.ObjectID{}
+		for _, email := range emails {
+			if email == "" {
+				continue
+			}
+
+			user, err := userService.FindUserByEmail(email)
+			if err == mongo.ErrNoDocuments {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			members[project] = append(members[project], user.ID)
+		}
+	}
+
+	created, err := projectService.ImportProjects(members)
+	if err != nil {
+		return err
+	}
+
+	if created > 0 {
+		log.Printf("created %d projects from the projects of existing rules", created)
+	}
+
+	return nil
+}
@@ -93,11 +93,25 @@ func main() {
 	historyScanCollection := mongoClient.Database(appConfig.DBName).Collection("history_scan")
 	historyScanService := services.NewHistoryScanService(historyScanCollection, ctx)
 
+	// 👇 Credentials
+	credentialCollection := mongoClient.Database(appConfig.DBName).Collection("credentials")
+	credentialService := services.NewCredentialService(credentialCollection, ctx)
+
 	// fw handlers register
-	fwHandler := handlers.NewFWHandler(ctx, nodeService, ruleService, historyScanService, redisClient, k8sClient)
+	fwHandler := handlers.NewFWHandler(ctx, nodeService, ruleService, historyScanService, credentialService, redisClient, k8sClient)
 	msgHandler.RegisterHandler(models.TriggerAll, fwHandler.HandleScanAllRules)
 	msgHandler.RegisterHandler(models.TriggerByRuleIds, fwHandler.HandleScanByRuleIds)
 
+	// 👇 Mesh responder for node-to-node rules
+	meshResponder, err := handlers.NewMeshResponder(appConfig.MeshResponderPorts)
+	if err != nil {
+		log.Fatal("Could not parse mesh responder ports", err)
+	}
+	// node-to-node rules towards the skipped ports fail, but the other scans still work
+	if err := meshResponder.Start(); err != nil {
+		log.Println(err)
+	}
+
 	// starting to handle message from Redis PubSub
 	startHandleMessage()
 }
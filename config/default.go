@@ -11,6 +11,14 @@ var (
 	DefaultEnvironment = "dev"
 	DefaultNamespace   = "default"
 
+	DefaultPasswordMinLength = 8
+	DefaultPasswordHistory   = 5
+	DefaultPasswordHashCost  = 10
+
+	DefaultTokenIssuer              = "clst-mgt-api"
+	DefaultTokenAudience            = "clst-mgt"
+	DefaultSigningKeyRotationPeriod = 30 * 24 * time.Hour
+
 	once          sync.Once
 	onceMu        sync.Mutex
 	appConfig     *Config
@@ -29,6 +37,10 @@ type Config struct {
 	Domain        string `mapstructure:"DOMAIN"`
 	Namespace     string `mapstructure:"NAMESPACE"`
 
+	TrustedProxies string `mapstructure:"TRUSTED_PROXIES"`
+
+	MeshResponderPorts string `mapstructure:"MESH_RESPONDER_PORTS"`
+
 	AccessTokenPrivateKey  string        `mapstructure:"ACCESS_TOKEN_PRIVATE_KEY"`
 	AccessTokenPublicKey   string        `mapstructure:"ACCESS_TOKEN_PUBLIC_KEY"`
 	RefreshTokenPrivateKey string        `mapstructure:"REFRESH_TOKEN_PRIVATE_KEY"`
@@ -38,8 +50,26 @@ type Config struct {
 	AccessTokenMaxAge      int           `mapstructure:"ACCESS_TOKEN_MAXAGE"`
 	RefreshTokenMaxAge     int           `mapstructure:"REFRESH_TOKEN_MAXAGE"`
 
+	TokenIssuer              string        `mapstructure:"TOKEN_ISSUER"`
+	TokenAudience            string        `mapstructure:"TOKEN_AUDIENCE"`
+	SigningKeyRotationPeriod time.Duration `mapstructure:"SIGNING_KEY_ROTATION_PERIOD"`
+
+	VerificationCodeExpiresIn time.Duration `mapstructure:"VERIFICATION_CODE_EXPIRED_IN"`
+
 	Origin string `mapstructure:"CLIENT_ORIGIN"`
 
+	SecretEncryptionKey       string `mapstructure:"SECRET_ENCRYPTION_KEY"`
+	SecretEncryptionKeys      string `mapstructure:"SECRET_ENCRYPTION_KEYS"`
+	SecretEncryptionActiveKey string `mapstructure:"SECRET_ENCRYPTION_ACTIVE_KEY"`
+
+	PasswordMinLength     int  `mapstructure:"PASSWORD_MIN_LENGTH"`
+	PasswordRequireUpper  bool `mapstructure:"PASSWORD_REQUIRE_UPPER"`
+	PasswordRequireLower  bool `mapstructure:"PASSWORD_REQUIRE_LOWER"`
+	PasswordRequireDigit  bool `mapstructure:"PASSWORD_REQUIRE_DIGIT"`
+	PasswordRequireSymbol bool `mapstructure:"PASSWORD_REQUIRE_SYMBOL"`
+	PasswordHistory       int  `mapstructure:"PASSWORD_HISTORY"`
+	PasswordHashCost      int  `mapstructure:"PASSWORD_HASH_COST"`
+
 	EmailFrom string `mapstructure:"EMAIL_FROM"`
 	SMTPHost  string `mapstructure:"SMTP_HOST"`
 	SMTPPass  string `mapstructure:"SMTP_PASS"`
@@ -55,6 +85,14 @@ func LoadConfig(path string) (*Config, error) {
 
 		viper.SetDefault("Environment", DefaultEnvironment)
 		viper.SetDefault("Namespace", DefaultNamespace)
+		viper.SetDefault("TOKEN_ISSUER", DefaultTokenIssuer)
+		viper.SetDefault("TOKEN_AUDIENCE", DefaultTokenAudience)
+		viper.SetDefault("SIGNING_KEY_ROTATION_PERIOD", DefaultSigningKeyRotationPeriod)
+		viper.SetDefault("SECRET_ENCRYPTION_KEYS", "")
+		viper.SetDefault("SECRET_ENCRYPTION_ACTIVE_KEY", "")
+		viper.SetDefault("PASSWORD_MIN_LENGTH", DefaultPasswordMinLength)
+		viper.SetDefault("PASSWORD_HISTORY", DefaultPasswordHistory)
+		viper.SetDefault("PASSWORD_HASH_COST", DefaultPasswordHashCost)
 
 		viper.AutomaticEnv()
 
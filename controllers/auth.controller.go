@@ -11,20 +11,28 @@ import (
 	"github.com/thuongnn/clst-mgt-api/utils"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/oauth2"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type AuthController struct {
 	authMethodService services.AuthMethodService
 	authService       services.AuthService
 	userService       services.UserService
+	rateLimitService  services.RateLimitService
+	tokenService      services.TokenService
+	loginStateService services.LoginStateService
+	mfaService        services.MFAService
+	throttleService   services.LoginThrottleService
+	attemptService    services.LoginAttemptService
 	ctx               context.Context
 	collection        *mongo.Collection
 }
 
-func NewAuthController(authMethodService services.AuthMethodService, authService services.AuthService, userService services.UserService, ctx context.Context, collection *mongo.Collection) AuthController {
-	return AuthController{authMethodService, authService, userService, ctx, collection}
+func NewAuthController(authMethodService services.AuthMethodService, authService services.AuthService, userService services.UserService, rateLimitService services.RateLimitService, tokenService services.TokenService, loginStateService services.LoginStateService, mfaService services.MFAService, throttleService services.LoginThrottleService, attemptService services.LoginAttemptService, ctx context.Context, collection *mongo.Collection) AuthController {
+	return AuthController{authMethodService, authService, userService, rateLimitService, tokenService, loginStateService, mfaService, throttleService, attemptService, ctx, collection}
 }
 
 func (ac *AuthController) SignUpUser(ctx *gin.Context) {
@@ -35,8 +43,30 @@ func (ac *AuthController) SignUpUser(ctx *gin.Context) {
 		return
 	}
 
+	basicAuthConfig, ok := ac.requireBasicAuth(ctx)
+	if !ok {
+		return
+	}
+
+	switch basicAuthConfig.RegistrationPolicy {
+	case utils.RegistrationDisabled:
+		ctx.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Registration is disabled, please contact admin for an account"})
+		return
+	case utils.RegistrationRestricted:
+		if !utils.EmailDomainAllowed(user.Email, basicAuthConfig.AllowedEmailDomains) {
+			ctx.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Registration is not allowed for this email domain"})
+			return
+		}
+	}
+
+	// the account state is decided here, never by the request body
 	user.Role = utils.UserRole
 	user.AuthMethod = utils.BasicAuth
+	user.Verified = false
+	user.IsActive = true
+	user.IsServiceAccount = false
+	user.Projects = nil
+	user.PendingApproval = basicAuthConfig.RequireApproval
 	newUser, err := ac.authService.SignUpUser(user)
 
 	if err != nil {
@@ -44,11 +74,198 @@ func (ac *AuthController) SignUpUser(ctx *gin.Context) {
 			ctx.JSON(http.StatusConflict, gin.H{"status": "error", "message": err.Error()})
 			return
 		}
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
 		return
 	}
 
-	ctx.JSON(http.StatusCreated, gin.H{"status": "success", "message": newUser})
+	if err := ac.sendVerificationEmail(newUser); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": "There was an error sending email"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"status": "success", "message": "We sent an email with a verification code to " + newUser.Email})
+}
+
+func (ac *AuthController) VerifyEmail(ctx *gin.Context) {
+	if _, ok := ac.requireBasicAuth(ctx); !ok {
+		return
+	}
+
+	verificationCode := ctx.Param("verificationCode")
+
+	if err := ac.authService.VerifyEmail(verificationCode); err != nil {
+		if strings.Contains(err.Error(), "invalid or expired") {
+			ctx.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Invalid verification code or user doesn't exists"})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "message": "Email verified successfully"})
+}
+
+func (ac *AuthController) ResendVerificationEmail(ctx *gin.Context) {
+	if _, ok := ac.requireBasicAuth(ctx); !ok {
+		return
+	}
+
+	var payload *models.ResendVerificationInput
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	email := strings.ToLower(payload.Email)
+	for _, key := range []string{"verify_email:email:" + email, "verify_email:ip:" + ctx.ClientIP()} {
+		allowed, retryAfter, err := ac.rateLimitService.Allow(key, utils.VerificationResendLimit, utils.VerificationResendWindow)
+		if err != nil {
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+			return
+		}
+
+		if !allowed {
+			ctx.Header("Retry-After", fmt.Sprint(int(retryAfter.Seconds())))
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"status": "fail", "message": "Too many requests, please try again later"})
+			return
+		}
+	}
+
+	// The response is the same whether the account exists or not, so the endpoint can't be used to enumerate emails
+	message := "If an unverified account exists for that email, a new verification link has been sent"
+
+	user, err := ac.userService.FindUserByEmail(email)
+	if err != nil || user.Verified || user.AuthMethod != utils.BasicAuth {
+		ctx.JSON(http.StatusOK, gin.H{"status": "success", "message": message})
+		return
+	}
+
+	if err := ac.sendVerificationEmail(user); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": "There was an error sending email"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "message": message})
+}
+
+func (ac *AuthController) ForgotPassword(ctx *gin.Context) {
+	if _, ok := ac.requireBasicAuth(ctx); !ok {
+		return
+	}
+
+	var payload *models.ForgotPasswordInput
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	email := strings.ToLower(payload.Email)
+	for _, key := range []string{"forgot_password:email:" + email, "forgot_password:ip:" + ctx.ClientIP()} {
+		allowed, retryAfter, err := ac.rateLimitService.Allow(key, utils.PasswordResetLimit, utils.PasswordResetWindow)
+		if err != nil {
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+			return
+		}
+
+		if !allowed {
+			ctx.Header("Retry-After", fmt.Sprint(int(retryAfter.Seconds())))
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"status": "fail", "message": "Too many requests, please try again later"})
+			return
+		}
+	}
+
+	// Known and unknown emails get the same answer, delivery failures are only logged
+	message := "If an account exists for that email, you will receive a link to reset your password"
+
+	user, err := ac.userService.FindUserByEmail(email)
+	if err != nil || user.AuthMethod != utils.BasicAuth || !user.IsActive {
+		ctx.JSON(http.StatusOK, gin.H{"status": "success", "message": message})
+		return
+	}
+
+	// the token and the email are handled in the background so known emails don't answer slower
+	go func() {
+		if err := ac.sendPasswordResetEmail(user); err != nil {
+			log.Printf("Failed to send password reset email to user %s: %v", user.ID.Hex(), err)
+		}
+	}()
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "message": message})
+}
+
+func (ac *AuthController) ResetPassword(ctx *gin.Context) {
+	if _, ok := ac.requireBasicAuth(ctx); !ok {
+		return
+	}
+
+	resetToken := ctx.Param("resetToken")
+
+	var payload *models.ResetPasswordInput
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	user, err := ac.authService.ResetPassword(resetToken, payload.Password)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid or expired") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "The reset token is invalid or has expired"})
+			return
+		}
+		if strings.Contains(err.Error(), "invalid password") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	if err := ac.tokenService.RevokeUserSessions(user.ID.Hex()); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "message": "Password data updated successfully, please login again"})
+}
+
+func (ac *AuthController) sendPasswordResetEmail(user *models.UserDBResponse) error {
+	resetToken, err := ac.authService.CreatePasswordResetToken(user.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	appConfig, _ := config.LoadConfig(".")
+
+	emailData := utils.EmailData{
+		URL:       strings.TrimSuffix(appConfig.Origin, "/") + "/resetpassword/" + resetToken,
+		FirstName: firstNameOf(user.Name),
+		Subject:   "Your password reset token (valid for 15min)",
+	}
+
+	return utils.SendEmail(user, &emailData, "resetPassword.html")
+}
+
+func (ac *AuthController) sendVerificationEmail(user *models.UserDBResponse) error {
+	code, err := ac.authService.CreateVerificationCode(user.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	appConfig, _ := config.LoadConfig(".")
+
+	emailData := utils.EmailData{
+		URL:       strings.TrimSuffix(appConfig.Origin, "/") + "/verifyemail/" + code,
+		FirstName: firstNameOf(user.Name),
+		Subject:   "Your account verification code",
+	}
+
+	return utils.SendEmail(user, &emailData, "verificationCode.html")
 }
 
 func (ac *AuthController) SignInUser(ctx *gin.Context) {
@@ -59,9 +276,19 @@ func (ac *AuthController) SignInUser(ctx *gin.Context) {
 		return
 	}
 
+	basicAuthConfig, ok := ac.requireBasicAuth(ctx)
+	if !ok {
+		return
+	}
+
+	if ac.loginLockedOut(ctx, credentials.Username, utils.BasicAuth) {
+		return
+	}
+
 	user, err := ac.userService.FindUserByUsername(credentials.Username)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
+			ac.loginFailed(ctx, credentials.Username, nil, utils.BasicAuth, "unknown user")
 			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "Invalid email or password"})
 			return
 		}
@@ -69,6 +296,13 @@ func (ac *AuthController) SignInUser(ctx *gin.Context) {
 		return
 	}
 
+	if err := utils.VerifyPassword(user.Password, credentials.Password); err != nil {
+		ac.loginFailed(ctx, credentials.Username, user, utils.BasicAuth, "invalid password")
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "Invalid email or Password"})
+		return
+	}
+
+	// the account state is only checked once the password is right, so it does not tell which accounts exist
 	if !user.IsActive {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "Account is disabled, please contact admin for support"})
 		return
@@ -79,32 +313,326 @@ func (ac *AuthController) SignInUser(ctx *gin.Context) {
 		return
 	}
 
-	if err := utils.VerifyPassword(user.Password, credentials.Password); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "Invalid email or Password"})
+	if user.IsServiceAccount {
+		ac.loginFailed(ctx, credentials.Username, user, utils.BasicAuth, "service account")
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "Service accounts can't log in interactively, use an API token instead"})
 		return
 	}
 
-	appConfig, _ := config.LoadConfig(".")
+	// the hash cost was changed since the password was stored, upgrade it while the plain password is known
+	if utils.PasswordNeedsRehash(user.Password) {
+		if err := ac.authService.RehashPassword(user, credentials.Password); err != nil {
+			log.Printf("could not rehash the password of %s: %v", user.ID.Hex(), err)
+		}
+	}
+
+	if ac.loginPendingApproval(ctx, user, utils.BasicAuth) {
+		return
+	}
+
+	// the password alone is not enough, hand out a challenge for the second factor
+	if user.MFAEnabled || basicAuthConfig.RequireMFA {
+		mfaToken, err := ac.mfaService.CreateMFAChallenge(&models.MFAChallenge{
+			UserId: user.ID.Hex(),
+			Enroll: !user.MFAEnabled,
+		})
+		if err != nil {
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+			return
+		}
+
+		ac.recordLoginAttempt(ctx, user.Username, user, utils.BasicAuth, false, "mfa required")
+		ctx.JSON(http.StatusOK, gin.H{
+			"status":                  "mfa_required",
+			"mfa_token":               mfaToken,
+			"mfa_enrollment_required": !user.MFAEnabled,
+		})
+		return
+	}
+
+	// the failures are only forgotten once the login is complete, a pending second factor doesn't count
+	ac.loginSucceeded(credentials.Username)
 
 	// Generate Tokens
-	accessToken, err := utils.CreateToken(appConfig.AccessTokenExpiresIn, user.ID, appConfig.AccessTokenPrivateKey)
+	accessToken, refreshToken, err := ac.tokenService.CreateSession(user.ID.Hex(), sessionMetadata(ctx, user.AuthMethod))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ac.recordLoginAttempt(ctx, user.Username, user, utils.BasicAuth, true, "")
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":        "success",
+		"access_token":  accessToken.Token,
+		"refresh_token": refreshToken.Token,
+	})
+}
+
+// loginLockedOut Reject the login while the username or the client IP is locked out
+func (ac *AuthController) loginLockedOut(ctx *gin.Context, username string, authMethod string) bool {
+	lockout, err := ac.throttleService.CheckLogin(username, ctx.ClientIP())
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return true
+	}
+
+	if lockout > 0 {
+		ac.recordLoginAttempt(ctx, username, nil, authMethod, false, "locked out")
+		ctx.Header("Retry-After", fmt.Sprint(int(lockout.Seconds())+1))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"status": "fail", "message": "Too many failed login attempts, please try again later"})
+		return true
+	}
+
+	return false
+}
+
+// loginFailed Count the failure towards the lockout and record it for the admins
+func (ac *AuthController) loginFailed(ctx *gin.Context, username string, user *models.UserDBResponse, authMethod string, reason string) {
+	if _, err := ac.throttleService.RecordFailure(username, ctx.ClientIP()); err != nil {
+		log.Printf("could not count login failure of %s: %v", username, err)
+	}
+
+	ac.recordLoginAttempt(ctx, username, user, authMethod, false, reason)
+}
+
+// loginPendingApproval Reject the login while an admin hasn't approved the account yet
+func (ac *AuthController) loginPendingApproval(ctx *gin.Context, user *models.UserDBResponse, authMethod string) bool {
+	if !user.PendingApproval {
+		return false
+	}
+
+	ac.recordLoginAttempt(ctx, user.Username, user, authMethod, false, "pending approval")
+	ctx.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Your account is waiting for admin approval"})
+	return true
+}
+
+// loginSucceeded Forget the failures of the username once the whole login went through
+func (ac *AuthController) loginSucceeded(username string) {
+	if err := ac.throttleService.RecordSuccess(username); err != nil {
+		log.Printf("could not reset login failures of %s: %v", username, err)
+	}
+}
+
+func (ac *AuthController) recordLoginAttempt(ctx *gin.Context, username string, user *models.UserDBResponse, authMethod string, success bool, reason string) {
+	attempt := &models.LoginAttempt{
+		Username:   username,
+		IPAddress:  ctx.ClientIP(),
+		UserAgent:  ctx.Request.UserAgent(),
+		AuthMethod: authMethod,
+		Success:    success,
+		Reason:     reason,
+	}
+	if user != nil {
+		attempt.UserId = user.ID.Hex()
+	}
+
+	// the login itself must not fail because the audit trail is unavailable
+	if err := ac.attemptService.RecordLoginAttempt(attempt); err != nil {
+		log.Printf("could not record login attempt of %s: %v", username, err)
+	}
+}
+
+// basicAuthConfig Get the config of the active basic auth method. Basic auth stays usable
+// while no auth method is configured at all, so a fresh install can still be set up.
+func (ac *AuthController) basicAuthConfig() (*models.BasicAuthConfig, bool, error) {
+	authMethods, err := ac.authMethodService.GetActiveAuthMethods()
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, method := range authMethods {
+		if method.Type != utils.BasicAuth {
+			continue
+		}
+
+		var basicAuthConfig models.BasicAuthConfig
+		if len(method.Configs) > 0 {
+			if err := json.Unmarshal(method.Configs, &basicAuthConfig); err != nil {
+				return nil, false, fmt.Errorf("Failed to parse Basic Auth config")
+			}
+		}
+		return &basicAuthConfig, true, nil
+	}
+
+	count, err := ac.authMethodService.CountAuthMethods()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &models.BasicAuthConfig{}, count == 0, nil
+}
+
+// requireBasicAuth Reject the request when basic auth is disabled in the settings
+func (ac *AuthController) requireBasicAuth(ctx *gin.Context) (*models.BasicAuthConfig, bool) {
+	basicAuthConfig, active, err := ac.basicAuthConfig()
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return nil, false
+	}
+
+	if !active {
+		ctx.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Basic authentication is disabled"})
+		return nil, false
+	}
+
+	return basicAuthConfig, true
+}
+
+// mfaChallengeUser Get the challenge of the MFA token and its user, counting the attempt
+func (ac *AuthController) mfaChallengeUser(ctx *gin.Context, mfaToken string) (*models.MFAChallenge, *models.UserDBResponse, bool) {
+	attemptsKey := "mfa_challenge:" + utils.HashToken(mfaToken)
+	allowed, _, err := ac.rateLimitService.Allow(attemptsKey, utils.MFAChallengeAttempts, utils.MFAChallengeTTL)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return nil, nil, false
+	}
+
+	// too many wrong codes burn the challenge, the password has to be entered again
+	if !allowed {
+		_ = ac.mfaService.DeleteMFAChallenge(mfaToken)
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"status": "fail", "message": "Too many attempts, please login again"})
+		return nil, nil, false
+	}
+
+	challenge, err := ac.mfaService.GetMFAChallenge(mfaToken)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "Invalid or expired MFA token, please login again"})
+		return nil, nil, false
+	}
+
+	// a fresh token doesn't buy more guesses, the wrong codes are also counted per user
+	locked, err := ac.mfaService.MFALocked(challenge.UserId)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return nil, nil, false
+	}
+	if locked {
+		_ = ac.mfaService.DeleteMFAChallenge(mfaToken)
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"status": "fail", "message": "Too many attempts, please try again later"})
+		return nil, nil, false
+	}
+
+	// the MFA secrets are not cached, the user is read from Mongo
+	user, err := ac.userService.FindUserWithSecretsById(challenge.UserId)
+	if err != nil || !user.IsActive {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "Account is disabled, please contact admin for support"})
+		return nil, nil, false
+	}
+
+	return challenge, user, true
+}
+
+func (ac *AuthController) EnrollMFA(ctx *gin.Context) {
+	if _, ok := ac.requireBasicAuth(ctx); !ok {
+		return
+	}
+
+	var input *models.MFAEnrollInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	challenge, user, ok := ac.mfaChallengeUser(ctx, input.MFAToken)
+	if !ok {
+		return
+	}
+
+	if !challenge.Enroll {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "MFA is already enabled"})
+		return
+	}
+
+	setup, err := ac.authService.SetupMFA(user)
 	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": setup})
+}
+
+func (ac *AuthController) VerifyMFA(ctx *gin.Context) {
+	if _, ok := ac.requireBasicAuth(ctx); !ok {
+		return
+	}
+
+	var input *models.MFAVerifyInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
 
-	refreshToken, err := utils.CreateToken(appConfig.RefreshTokenExpiresIn, user.ID, appConfig.RefreshTokenPrivateKey)
+	challenge, user, ok := ac.mfaChallengeUser(ctx, input.MFAToken)
+	if !ok {
+		return
+	}
+
+	// wrong codes count towards the same lockout as wrong passwords
+	if ac.loginLockedOut(ctx, user.Username, utils.BasicAuth) {
+		return
+	}
+
+	var recoveryCodes []string
+	var err error
+	if challenge.Enroll {
+		recoveryCodes, err = ac.authService.EnableMFA(user, input.Code)
+	} else {
+		err = ac.authService.VerifyMFA(user, input.Code, input.RecoveryCode)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			ac.loginFailed(ctx, user.Username, user, utils.BasicAuth, "invalid MFA code")
+
+			exceeded, failureErr := ac.mfaService.RecordMFAFailure(user.ID.Hex())
+			if failureErr != nil {
+				log.Printf("could not count MFA failure of %s: %v", user.ID.Hex(), failureErr)
+			}
+			if exceeded {
+				ctx.JSON(http.StatusTooManyRequests, gin.H{"status": "fail", "message": "Too many attempts, please try again later"})
+				return
+			}
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	if err := ac.mfaService.DeleteMFAChallenge(input.MFAToken); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	ac.loginSucceeded(user.Username)
+	if err := ac.mfaService.ResetMFAFailures(user.ID.Hex()); err != nil {
+		log.Printf("could not reset MFA failures of %s: %v", user.ID.Hex(), err)
+	}
+
+	// Generate Tokens
+	accessToken, refreshToken, err := ac.tokenService.CreateSession(user.ID.Hex(), sessionMetadata(ctx, user.AuthMethod))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"status":        "success",
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
-	})
+	ac.recordLoginAttempt(ctx, user.Username, user, utils.BasicAuth, true, "")
+	response := gin.H{
+		"status":        "success",
+		"access_token":  accessToken.Token,
+		"refresh_token": refreshToken.Token,
+	}
+	if recoveryCodes != nil {
+		response["recovery_codes"] = recoveryCodes
+	}
+
+	ctx.JSON(http.StatusOK, response)
 }
+
 func (ac *AuthController) RefreshAccessToken(ctx *gin.Context) {
 	var refreshToken string
 
@@ -113,40 +641,49 @@ func (ac *AuthController) RefreshAccessToken(ctx *gin.Context) {
 		refreshToken = strings.TrimPrefix(authorizationHeader, "Bearer ")
 	}
 
-	appConfig, _ := config.LoadConfig(".")
-	sub, err := utils.ValidateToken(refreshToken, appConfig.RefreshTokenPublicKey)
+	tokenDetails, err := ac.tokenService.ValidateRefreshToken(refreshToken)
 	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
 
-	user, err := ac.userService.FindUserById(fmt.Sprint(sub))
+	user, err := ac.userService.FindUserById(tokenDetails.Subject)
 	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": "the user belonging to this token no logger exists"})
 		return
 	}
 
-	accessToken, err := utils.CreateToken(appConfig.AccessTokenExpiresIn, user.ID, appConfig.AccessTokenPrivateKey)
-	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": err.Error()})
+	if tokenDetails.IssuedBefore(user.PasswordChangedAt) {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Password was changed, please login again"})
+		return
+	}
+
+	if !user.IsActive {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Account is disabled, please contact admin for support"})
 		return
 	}
 
-	newRefreshToken, err := utils.CreateToken(appConfig.RefreshTokenExpiresIn, user.ID, appConfig.RefreshTokenPrivateKey)
+	accessToken, newRefreshToken, err := ac.tokenService.RotateSession(tokenDetails, sessionMetadata(ctx, user.AuthMethod))
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"status":        "success",
-		"access_token":  accessToken,
-		"refresh_token": newRefreshToken,
+		"access_token":  accessToken.Token,
+		"refresh_token": newRefreshToken.Token,
 	})
 }
 
 func (ac *AuthController) LogoutUser(ctx *gin.Context) {
-	//currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+	currentToken := ctx.MustGet("currentToken").(*utils.TokenDetails)
+
+	if err := ac.tokenService.RevokeSession(currentToken.SessionId); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
@@ -179,16 +716,42 @@ func (ac *AuthController) GetLoginOptions(ctx *gin.Context) {
 				return
 			}
 
-			oauth2Config, err := utils.ParseOAuth2Config(oauth2Info)
-			if err != nil {
-				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": err.Error()})
+			// the state is only created when the user picks this method, not for every visit of the login page
+			methodData["settings"] = gin.H{
+				"login_url":   "/api/auth/oauth2/" + method.Id.Hex() + "/login",
+				"button_text": oauth2Info.ButtonText,
+			}
+		}
+
+		if method.Type == utils.LDAPAuth {
+			var ldapInfo models.LDAPConfig
+			if err := json.Unmarshal(method.Configs, &ldapInfo); err != nil {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"status":  "fail",
+					"message": "Failed to parse LDAP config",
+				})
 				return
 			}
 
-			authURL := oauth2Config.AuthCodeURL(method.Id.Hex(), oauth2.AccessTypeOffline)
 			methodData["settings"] = gin.H{
-				"auth_url":    authURL,
-				"button_text": oauth2Info.ButtonText,
+				"login_url":   "/api/auth/ldap/" + method.Id.Hex() + "/login",
+				"button_text": ldapInfo.ButtonText,
+			}
+		}
+
+		if method.Type == utils.SAMLAuth {
+			var samlInfo models.SAMLConfig
+			if err := json.Unmarshal(method.Configs, &samlInfo); err != nil {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"status":  "fail",
+					"message": "Failed to parse SAML config",
+				})
+				return
+			}
+
+			methodData["settings"] = gin.H{
+				"login_url":   "/api/auth/saml/" + method.Id.Hex() + "/login",
+				"button_text": samlInfo.ButtonText,
 			}
 		}
 
@@ -202,8 +765,14 @@ func (ac *AuthController) GetLoginOptions(ctx *gin.Context) {
 				return
 			}
 
+			registrationPolicy := basicAuthConfig.RegistrationPolicy
+			if registrationPolicy == "" {
+				registrationPolicy = utils.RegistrationOpen
+			}
+
 			methodData["settings"] = gin.H{
-				"button_text": basicAuthConfig.ButtonText,
+				"button_text":         basicAuthConfig.ButtonText,
+				"registration_policy": registrationPolicy,
 			}
 		}
 
@@ -213,6 +782,79 @@ func (ac *AuthController) GetLoginOptions(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": response})
 }
 
+func (ac *AuthController) oauth2Config(authMethodId string) (*models.OAuth2Config, error) {
+	authMethod, err := ac.authMethodService.GetAuthMethodById(authMethodId)
+	if err != nil || authMethod.Type != utils.Oauth2Auth || !authMethod.IsActive {
+		return nil, fmt.Errorf("OAuth2 auth method not found")
+	}
+
+	var oauth2Info models.OAuth2Config
+	if err := json.Unmarshal(authMethod.Configs, &oauth2Info); err != nil {
+		return nil, fmt.Errorf("Failed to parse OAuth2 config")
+	}
+
+	return &oauth2Info, nil
+}
+
+func (ac *AuthController) OAuth2Login(ctx *gin.Context) {
+	// every call stores a login state, so anonymous clients can't fill Redis with them
+	allowed, retryAfter, err := ac.rateLimitService.Allow("oauth2_login:ip:"+ctx.ClientIP(), utils.OAuth2LoginLimit, utils.OAuth2LoginWindow)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	if !allowed {
+		ctx.Header("Retry-After", fmt.Sprint(int(retryAfter.Seconds())))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"status": "fail", "message": "Too many requests, please try again later"})
+		return
+	}
+
+	authMethodId := ctx.Param("authMethodId")
+	oauth2Info, err := ac.oauth2Config(authMethodId)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	oauth2Config, _, err := utils.ParseOAuth2Config(*oauth2Info)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	// every login attempt gets its own state, nonce and PKCE verifier
+	codeVerifier, codeChallenge, err := utils.GeneratePKCE()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	nonce, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	state, err := ac.loginStateService.CreateLoginState(&models.OAuth2LoginState{
+		AuthMethodId: authMethodId,
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	authURL := oauth2Config.AuthCodeURL(state, oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
 func (ac *AuthController) Oauth2Callback(ctx *gin.Context) {
 	state, code := ctx.Query("state"), ctx.Query("code")
 	if state == "" || code == "" {
@@ -220,8 +862,14 @@ func (ac *AuthController) Oauth2Callback(ctx *gin.Context) {
 		return
 	}
 
-	authMethod, err := ac.authMethodService.GetAuthMethodById(state)
+	loginState, err := ac.loginStateService.ConsumeLoginState(state)
 	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Invalid or expired login state, please try to login again"})
+		return
+	}
+
+	authMethod, err := ac.authMethodService.GetAuthMethodById(loginState.AuthMethodId)
+	if err != nil || authMethod.Type != utils.Oauth2Auth || !authMethod.IsActive {
 		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Failed to get OAuth2 config"})
 		return
 	}
@@ -235,38 +883,65 @@ func (ac *AuthController) Oauth2Callback(ctx *gin.Context) {
 		return
 	}
 
-	oauth2Config, err := utils.ParseOAuth2Config(oauth2Info)
+	oauth2Config, wellKnownConfig, err := utils.ParseOAuth2Config(oauth2Info)
 	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
 
-	token, err := oauth2Config.Exchange(context.Background(), code)
+	token, err := oauth2Config.Exchange(context.Background(), code, oauth2.SetAuthURLParam("code_verifier", loginState.CodeVerifier))
 	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
 
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "Provider did not return an id_token, make sure the openid scope is configured"})
+		return
+	}
+
+	issuer := oauth2Info.IssuerURL
+	if issuer == "" {
+		issuer = wellKnownConfig.Issuer
+	}
+
 	var userClaims models.UserClaims
-	err = utils.DecodeOauth2Token(token.AccessToken, &userClaims)
-	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "fail", "message": err.Error()})
+	if err := utils.VerifyIDToken(rawIDToken, wellKnownConfig.JwksURI, issuer, oauth2Info.ClientID, loginState.Nonce, &userClaims); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
 
-	userRole := utils.UserRole
-	if utils.IsAdmin(userClaims.Groups, oauth2Info.AdminGroups) {
-		userRole = utils.AdminRole
+	// Claims can be left out of the id_token, complete them from the userinfo endpoint
+	if wellKnownConfig.UserInfoURL != "" &&
+		(userClaims.Email == "" || userClaims.PreferredUsername == "" || userClaims.Name == "" || userClaims.Groups == nil) {
+		var userInfoClaims models.UserClaims
+		client := oauth2Config.Client(context.Background(), token)
+		if err := utils.FetchUserInfo(client, wellKnownConfig.UserInfoURL, &userInfoClaims); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"status": "fail", "message": "Failed to get user info: " + err.Error()})
+			return
+		}
+
+		if userInfoClaims.Subject != userClaims.Subject {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "User info does not belong to the id_token subject"})
+			return
+		}
+
+		mergeUserClaims(&userClaims, &userInfoClaims)
+	}
+
+	if userClaims.PreferredUsername == "" {
+		userClaims.PreferredUsername = userClaims.Email
 	}
 
 	userInfo, err := ac.authService.SyncOauth2User(&models.SignUpInput{
-		Name:       userClaims.Name,
-		Role:       userRole,
-		Verified:   userClaims.EmailVerified,
-		Username:   userClaims.PreferredUsername,
-		Email:      userClaims.Email,
-		AuthMethod: utils.Oauth2Auth,
-	})
+		Name:            userClaims.Name,
+		Verified:        userClaims.EmailVerified,
+		Username:        userClaims.PreferredUsername,
+		Email:           userClaims.Email,
+		AuthMethod:      utils.Oauth2Auth,
+		PendingApproval: oauth2Info.RequireApproval,
+	}, userClaims.Raw, utils.OAuth2GroupMappings(oauth2Info))
 	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": "the user no logger exists"})
 		return
@@ -282,18 +957,287 @@ func (ac *AuthController) Oauth2Callback(ctx *gin.Context) {
 		return
 	}
 
+	if ac.loginPendingApproval(ctx, userInfo, userInfo.AuthMethod) {
+		return
+	}
+
 	// Generate Tokens
-	appConfig, _ := config.LoadConfig(".")
-	accessToken, err := utils.CreateToken(appConfig.AccessTokenExpiresIn, userInfo.ID, appConfig.AccessTokenPrivateKey)
-	refreshToken, err := utils.CreateToken(appConfig.RefreshTokenExpiresIn, userInfo.ID, appConfig.RefreshTokenPrivateKey)
+	accessToken, refreshToken, err := ac.tokenService.CreateSession(userInfo.ID.Hex(), sessionMetadata(ctx, userInfo.AuthMethod))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ac.recordLoginAttempt(ctx, userInfo.Username, userInfo, userInfo.AuthMethod, true, "")
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":        "success",
+		"access_token":  accessToken.Token,
+		"refresh_token": refreshToken.Token,
+	})
+}
+
+func (ac *AuthController) LDAPLogin(ctx *gin.Context) {
+	var credentials *models.SignInInput
+
+	if err := ctx.ShouldBindJSON(&credentials); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	authMethod, err := ac.authMethodService.GetAuthMethodById(ctx.Param("authMethodId"))
+	if err != nil || authMethod.Type != utils.LDAPAuth || !authMethod.IsActive {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "LDAP auth method not found"})
+		return
+	}
+
+	var ldapInfo models.LDAPConfig
+	if err := json.Unmarshal(authMethod.Configs, &ldapInfo); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"status":  "fail",
+			"message": "Failed to parse LDAP config",
+		})
+		return
+	}
+
+	if ac.loginLockedOut(ctx, credentials.Username, utils.LDAPAuth) {
+		return
+	}
+
+	ldapUser, err := utils.AuthenticateLDAP(ldapInfo, credentials.Username, credentials.Password)
+	if err != nil {
+		if err == utils.ErrLDAPInvalidCredentials {
+			ac.loginFailed(ctx, credentials.Username, nil, utils.LDAPAuth, "invalid credentials")
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "Invalid username or password"})
+			return
+		}
+		log.Printf("ldap login with auth method %s failed: %v", authMethod.Id.Hex(), err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": "Could not authenticate with the LDAP server"})
+		return
+	}
+
+	if err := ac.throttleService.RecordSuccess(credentials.Username); err != nil {
+		log.Printf("could not reset login failures of %s: %v", credentials.Username, err)
+	}
+
+	if ldapUser.Email == "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "LDAP user has no email address"})
+		return
+	}
+
+	// the directory is the source of truth, a successful bind verifies the user
+	userInfo, err := ac.authService.SyncOauth2User(&models.SignUpInput{
+		Name:            ldapUser.Name,
+		Verified:        true,
+		Username:        ldapUser.Username,
+		Email:           ldapUser.Email,
+		AuthMethod:      utils.LDAPAuth,
+		PendingApproval: ldapInfo.RequireApproval,
+	}, ldapUser.Claims, ldapInfo.GroupMappings)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if !userInfo.IsActive {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "Account is disabled, please contact admin for support"})
+		return
+	}
+
+	if ac.loginPendingApproval(ctx, userInfo, userInfo.AuthMethod) {
+		return
+	}
+
+	// Generate Tokens
+	accessToken, refreshToken, err := ac.tokenService.CreateSession(userInfo.ID.Hex(), sessionMetadata(ctx, userInfo.AuthMethod))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ac.recordLoginAttempt(ctx, userInfo.Username, userInfo, userInfo.AuthMethod, true, "")
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":        "success",
+		"access_token":  accessToken.Token,
+		"refresh_token": refreshToken.Token,
+	})
+}
+
+// samlConfig Get the config of an active SAML auth method
+func (ac *AuthController) samlConfig(authMethodId string) (*models.SAMLConfig, error) {
+	authMethod, err := ac.authMethodService.GetAuthMethodById(authMethodId)
+	if err != nil || authMethod.Type != utils.SAMLAuth || !authMethod.IsActive {
+		return nil, fmt.Errorf("SAML auth method not found")
+	}
+
+	var samlInfo models.SAMLConfig
+	if err := json.Unmarshal(authMethod.Configs, &samlInfo); err != nil {
+		return nil, fmt.Errorf("Failed to parse SAML config")
+	}
+
+	return &samlInfo, nil
+}
+
+func (ac *AuthController) SAMLMetadata(ctx *gin.Context) {
+	samlInfo, err := ac.samlConfig(ctx.Param("authMethodId"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	metadata, err := utils.SAMLMetadata(*samlInfo)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	ctx.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+func (ac *AuthController) SAMLLogin(ctx *gin.Context) {
+	authMethodId := ctx.Param("authMethodId")
+	samlInfo, err := ac.samlConfig(authMethodId)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	requestId, err := utils.NewSAMLRequestId()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	// the relay state comes back with the response and leads to the request id, once
+	relayState, err := ac.loginStateService.CreateLoginState(&models.OAuth2LoginState{
+		AuthMethodId: authMethodId,
+		RequestId:    requestId,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	redirectURL, err := utils.SAMLAuthnRequestURL(*samlInfo, requestId, relayState)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	ctx.Redirect(http.StatusFound, redirectURL)
+}
+
+func (ac *AuthController) SAMLAssertionConsumer(ctx *gin.Context) {
+	authMethodId := ctx.Param("authMethodId")
+	samlResponse, relayState := ctx.PostForm("SAMLResponse"), ctx.PostForm("RelayState")
+	if samlResponse == "" || relayState == "" {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Missing SAMLResponse or RelayState, IdP initiated logins are not supported"})
+		return
+	}
+
+	loginState, err := ac.loginStateService.ConsumeLoginState(relayState)
+	if err != nil || loginState.AuthMethodId != authMethodId || loginState.RequestId == "" {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Invalid or expired login state, please try to login again"})
+		return
+	}
+
+	samlInfo, err := ac.samlConfig(authMethodId)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	samlUser, err := utils.ParseSAMLResponse(*samlInfo, samlResponse, loginState.RequestId, time.Now())
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if samlUser.Email == "" {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "SAML assertion has no email attribute"})
+		return
+	}
+
+	// the IdP vouches for the user with a signed assertion
+	userInfo, err := ac.authService.SyncOauth2User(&models.SignUpInput{
+		Name:            samlUser.Name,
+		Verified:        true,
+		Username:        samlUser.Username,
+		Email:           samlUser.Email,
+		AuthMethod:      utils.SAMLAuth,
+		PendingApproval: samlInfo.RequireApproval,
+	}, samlUser.Claims, samlInfo.GroupMappings)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if !userInfo.IsActive {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "Account is disabled, please contact admin for support"})
+		return
+	}
+
+	if ac.loginPendingApproval(ctx, userInfo, userInfo.AuthMethod) {
+		return
+	}
+
+	// Generate Tokens
+	accessToken, refreshToken, err := ac.tokenService.CreateSession(userInfo.ID.Hex(), sessionMetadata(ctx, userInfo.AuthMethod))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
 
+	ac.recordLoginAttempt(ctx, userInfo.Username, userInfo, userInfo.AuthMethod, true, "")
 	ctx.JSON(http.StatusOK, gin.H{
 		"status":        "success",
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
+		"access_token":  accessToken.Token,
+		"refresh_token": refreshToken.Token,
 	})
 }
+
+// mergeUserClaims Fill the claims missing from the id_token with the userinfo ones
+func mergeUserClaims(claims *models.UserClaims, userInfo *models.UserClaims) {
+	if claims.Email == "" {
+		claims.Email = userInfo.Email
+		claims.EmailVerified = userInfo.EmailVerified
+	}
+	if claims.Name == "" {
+		claims.Name = userInfo.Name
+	}
+	if claims.GivenName == "" {
+		claims.GivenName = userInfo.GivenName
+	}
+	if claims.PreferredUsername == "" {
+		claims.PreferredUsername = userInfo.PreferredUsername
+	}
+	if claims.Nickname == "" {
+		claims.Nickname = userInfo.Nickname
+	}
+	if claims.Groups == nil {
+		claims.Groups = userInfo.Groups
+	}
+
+	if claims.Raw == nil {
+		claims.Raw = map[string]interface{}{}
+	}
+	for name, value := range userInfo.Raw {
+		if _, exists := claims.Raw[name]; !exists {
+			claims.Raw[name] = value
+		}
+	}
+}
+
+func firstNameOf(name string) string {
+	if fields := strings.Fields(name); len(fields) > 0 {
+		return fields[0]
+	}
+	return name
+}
+
+func sessionMetadata(ctx *gin.Context, authMethod string) *models.SessionMetadata {
+	return &models.SessionMetadata{
+		IPAddress:  ctx.ClientIP(),
+		UserAgent:  ctx.Request.UserAgent(),
+		AuthMethod: authMethod,
+	}
+}
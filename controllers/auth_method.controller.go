@@ -4,6 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/thuongnn/clst-mgt-api/models"
 	"github.com/thuongnn/clst-mgt-api/services"
+	"github.com/thuongnn/clst-mgt-api/utils"
 	"net/http"
 	"strings"
 )
@@ -24,20 +25,15 @@ func (sc *AuthMethodController) CreateAuthMethod(ctx *gin.Context) {
 		return
 	}
 
-	// Check the number of AuthMethods in the database
-	count, err := sc.authMethodService.CountAuthMethods()
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	// Maximum limit of 3 AuthMethods
-	if count >= 3 {
-		ctx.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Only create up to 3 authentication methods"})
-		return
-	}
-
 	if err := sc.authMethodService.CreateAuthMethod(authMethod); err != nil {
+		if strings.Contains(err.Error(), "already exist") {
+			ctx.JSON(http.StatusConflict, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
@@ -48,17 +44,21 @@ func (sc *AuthMethodController) CreateAuthMethod(ctx *gin.Context) {
 func (sc *AuthMethodController) UpdateAuthMethod(ctx *gin.Context) {
 	authMethodId := ctx.Param("authMethodId")
 
-	var authMethod *models.AuthMethod
-	if err := ctx.ShouldBindJSON(&authMethod); err != nil {
-		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+	var input *models.UpdateAuthMethod
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
 
-	if err := sc.authMethodService.UpdateAuthMethod(authMethodId, authMethod); err != nil {
+	if err := sc.authMethodService.UpdateAuthMethod(authMethodId, input); err != nil {
 		if strings.Contains(err.Error(), "no document") {
 			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
 			return
 		}
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
@@ -73,6 +73,13 @@ func (sc *AuthMethodController) GetAuthMethods(ctx *gin.Context) {
 		return
 	}
 
+	for _, authMethod := range result {
+		if err := redactAuthMethod(authMethod); err != nil {
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
 		"data":   result,
@@ -92,12 +99,28 @@ func (sc *AuthMethodController) GetAuthMethodById(ctx *gin.Context) {
 		return
 	}
 
+	if err := redactAuthMethod(result); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
 		"data":   result,
 	})
 }
 
+// redactAuthMethod Hide the secrets of the auth method before it is returned
+func redactAuthMethod(authMethod *models.AuthMethod) error {
+	configs, err := utils.RedactAuthMethodSecrets(authMethod.Type, authMethod.Configs)
+	if err != nil {
+		return err
+	}
+
+	authMethod.Configs = configs
+	return nil
+}
+
 func (sc *AuthMethodController) DeleteAuthMethod(ctx *gin.Context) {
 	authMethodId := ctx.Param("authMethodId")
 
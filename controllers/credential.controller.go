@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/services"
+	"net/http"
+	"strings"
+)
+
+type CredentialController struct {
+	credentialService services.CredentialService
+	ruleService       services.RuleService
+}
+
+func NewCredentialController(credentialService services.CredentialService, ruleService services.RuleService) CredentialController {
+	return CredentialController{credentialService, ruleService}
+}
+
+func (cc *CredentialController) CreateCredential(ctx *gin.Context) {
+	var credential *models.Credential
+
+	if err := ctx.ShouldBindJSON(&credential); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+	credential.CreatedBy = currentUser.Email
+
+	if err := cc.credentialService.CreateCredential(credential); err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"status": "success"})
+}
+
+func (cc *CredentialController) UpdateCredential(ctx *gin.Context) {
+	credentialId := ctx.Param("credentialId")
+
+	var credential *models.UpdateCredential
+	if err := ctx.ShouldBindJSON(&credential); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if err := cc.credentialService.UpdateCredential(credentialId, credential); err != nil {
+		if strings.Contains(err.Error(), "no document") {
+			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (cc *CredentialController) GetCredentials(ctx *gin.Context) {
+	result, err := cc.credentialService.GetCredentials()
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": result})
+}
+
+func (cc *CredentialController) GetCredentialById(ctx *gin.Context) {
+	credentialId := ctx.Param("credentialId")
+
+	result, err := cc.credentialService.GetCredentialById(credentialId)
+	if err != nil {
+		if strings.Contains(err.Error(), "no document") {
+			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": result})
+}
+
+func (cc *CredentialController) DeleteCredential(ctx *gin.Context) {
+	credentialId := ctx.Param("credentialId")
+
+	// a credential can't be removed while rules still probe with it
+	count, err := cc.ruleService.CountRulesByCredentialId(credentialId)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if count > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{"status": "fail", "message": fmt.Sprintf("Credential is used by %d rules", count)})
+		return
+	}
+
+	if err := cc.credentialService.DeleteCredential(credentialId); err != nil {
+		if strings.Contains(err.Error(), "no document") {
+			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
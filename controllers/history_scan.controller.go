@@ -2,21 +2,45 @@ package controllers
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/thuongnn/clst-mgt-api/middleware"
+	"github.com/thuongnn/clst-mgt-api/models"
 	"github.com/thuongnn/clst-mgt-api/services"
+	"github.com/thuongnn/clst-mgt-api/utils"
+	"go.mongodb.org/mongo-driver/mongo"
 	"net/http"
 	"strings"
 )
 
 type HistoryScanController struct {
 	historyScanService services.HistoryScanService
+	ruleService        services.RuleService
+	policyService      services.PolicyService
 }
 
-func NewHistoryScanController(historyScanService services.HistoryScanService) HistoryScanController {
-	return HistoryScanController{historyScanService}
+func NewHistoryScanController(historyScanService services.HistoryScanService, ruleService services.RuleService, policyService services.PolicyService) HistoryScanController {
+	return HistoryScanController{historyScanService, ruleService, policyService}
+}
+
+// ruleAllowed Check the current user may read the rule before reading its results
+func (hsc *HistoryScanController) ruleAllowed(ctx *gin.Context, ruleId string) bool {
+	rule, err := hsc.ruleService.GetRuleById(ruleId)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "no document with that Id exists"})
+			return false
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return false
+	}
+
+	return authorize(ctx, hsc.policyService, utils.ActionRead, models.RuleResource(rule))
 }
 
 func (hsc *HistoryScanController) GetHistoryScanByRuleId(ctx *gin.Context) {
 	ruleId := ctx.Param("ruleId")
+	if !hsc.ruleAllowed(ctx, ruleId) {
+		return
+	}
 
 	historyScan, err := hsc.historyScanService.GetHistoryScanByRuleId(ruleId)
 	if err != nil {
@@ -30,3 +54,43 @@ func (hsc *HistoryScanController) GetHistoryScanByRuleId(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, gin.H{"status": "success", "results": len(historyScan), "data": historyScan})
 }
+
+func (hsc *HistoryScanController) GetMeshMatrixByRuleId(ctx *gin.Context) {
+	ruleId := ctx.Param("ruleId")
+	if !hsc.ruleAllowed(ctx, ruleId) {
+		return
+	}
+
+	matrix, err := hsc.historyScanService.GetMeshMatrixByRuleId(ruleId)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": matrix})
+}
+
+func (hsc *HistoryScanController) GetDNSConsistencyReport(ctx *gin.Context) {
+	scope, err := hsc.policyService.Scope(middleware.CurrentSubject(ctx), utils.ActionRead, utils.ResourceRule)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	// users only see the answers collected for the rules they may read
+	var ruleIds []string
+	if scope != nil {
+		if ruleIds, err = hsc.ruleService.GetRuleIdsByScope(scope); err != nil {
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+	}
+
+	reports, err := hsc.historyScanService.GetDNSConsistencyReport(ctx.Query("hostname"), ruleIds)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "results": len(reports), "data": reports})
+}
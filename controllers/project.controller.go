@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thuongnn/clst-mgt-api/middleware"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/services"
+	"github.com/thuongnn/clst-mgt-api/utils"
+)
+
+type ProjectController struct {
+	projectService services.ProjectService
+	ruleService    services.RuleService
+	userService    services.UserService
+	policyService  services.PolicyService
+}
+
+func NewProjectController(projectService services.ProjectService, ruleService services.RuleService, userService services.UserService, policyService services.PolicyService) ProjectController {
+	return ProjectController{projectService, ruleService, userService, policyService}
+}
+
+func (pc *ProjectController) GetProjects(ctx *gin.Context) {
+	scope, err := pc.policyService.Scope(middleware.CurrentSubject(ctx), utils.ActionRead, utils.ResourceProject)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	result, err := pc.projectService.GetProjects("")
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if scope != nil {
+		scoped := []*models.DBProject{}
+		for _, project := range result {
+			if utils.Contains(scope.Projects, project.Name) {
+				scoped = append(scoped, project)
+			}
+		}
+		result = scoped
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "results": len(result), "data": result})
+}
+
+func (pc *ProjectController) GetProject(ctx *gin.Context) {
+	project, ok := pc.findProject(ctx, utils.ActionRead)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": project})
+}
+
+func (pc *ProjectController) CreateProject(ctx *gin.Context) {
+	var input *models.CreateProjectInput
+
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+
+	project, err := pc.projectService.CreateProject(input, currentUser.ID.Hex())
+	if err != nil {
+		if strings.Contains(err.Error(), "already exist") {
+			ctx.JSON(http.StatusConflict, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"status": "success", "data": project})
+}
+
+func (pc *ProjectController) UpdateProject(ctx *gin.Context) {
+	project, ok := pc.findProject(ctx, utils.ActionUpdate)
+	if !ok {
+		return
+	}
+
+	var data *models.UpdateProject
+	if err := ctx.ShouldBindJSON(&data); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if err := pc.projectService.UpdateProject(project.Id.Hex(), data); err != nil {
+		projectErrorResponse(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (pc *ProjectController) DeleteProject(ctx *gin.Context) {
+	project, ok := pc.findProject(ctx, utils.ActionDelete)
+	if !ok {
+		return
+	}
+
+	count, err := pc.ruleService.CountRulesByProject(project.Name)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if count > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{"status": "fail", "message": "project still has rules, move or delete them first"})
+		return
+	}
+
+	if err := pc.projectService.DeleteProject(project.Id.Hex()); err != nil {
+		projectErrorResponse(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (pc *ProjectController) SetProjectMember(ctx *gin.Context) {
+	project, ok := pc.findProject(ctx, utils.ActionManage)
+	if !ok {
+		return
+	}
+
+	var input *models.ProjectMemberInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if _, err := pc.userService.FindUserById(input.UserId); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "no user with that Id exists"})
+		return
+	}
+
+	if err := pc.projectService.SetProjectMember(project.Id.Hex(), input); err != nil {
+		projectErrorResponse(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (pc *ProjectController) RemoveProjectMember(ctx *gin.Context) {
+	project, ok := pc.findProject(ctx, utils.ActionManage)
+	if !ok {
+		return
+	}
+
+	if err := pc.projectService.RemoveProjectMember(project.Id.Hex(), ctx.Param("userId")); err != nil {
+		projectErrorResponse(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// findProject Load the project of the request and check the current user may do the action on it,
+// users who may not even read it get a 404
+func (pc *ProjectController) findProject(ctx *gin.Context, action string) (*models.DBProject, bool) {
+	project, err := pc.projectService.GetProjectById(ctx.Param("projectId"))
+	if err != nil {
+		projectErrorResponse(ctx, err)
+		return nil, false
+	}
+
+	allowed, err := pc.policyService.Can(middleware.CurrentSubject(ctx), utils.ActionRead, models.ProjectResource(project))
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return nil, false
+	}
+
+	if !allowed {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "no document with that Id exists"})
+		return nil, false
+	}
+
+	if action != utils.ActionRead && !authorize(ctx, pc.policyService, action, models.ProjectResource(project)) {
+		return nil, false
+	}
+
+	return project, true
+}
+
+func projectErrorResponse(ctx *gin.Context, err error) {
+	switch {
+	case strings.Contains(err.Error(), "no document"):
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+	case strings.Contains(err.Error(), "invalid"):
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+	case strings.Contains(err.Error(), "in the meantime"):
+		ctx.JSON(http.StatusConflict, gin.H{"status": "fail", "message": err.Error()})
+	default:
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+	}
+}
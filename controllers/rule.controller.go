@@ -2,9 +2,11 @@ package controllers
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/thuongnn/clst-mgt-api/middleware"
 	"github.com/thuongnn/clst-mgt-api/models"
 	"github.com/thuongnn/clst-mgt-api/services"
 	"github.com/thuongnn/clst-mgt-api/utils"
+	"go.mongodb.org/mongo-driver/mongo"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,10 +15,12 @@ import (
 type RuleController struct {
 	ruleService        services.RuleService
 	historyScanService services.HistoryScanService
+	projectService     services.ProjectService
+	policyService      services.PolicyService
 }
 
-func NewRuleController(ruleService services.RuleService, historyScanService services.HistoryScanService) RuleController {
-	return RuleController{ruleService, historyScanService}
+func NewRuleController(ruleService services.RuleService, historyScanService services.HistoryScanService, projectService services.ProjectService, policyService services.PolicyService) RuleController {
+	return RuleController{ruleService, historyScanService, projectService, policyService}
 }
 
 func (rc *RuleController) CreateRule(ctx *gin.Context) {
@@ -30,6 +34,15 @@ func (rc *RuleController) CreateRule(ctx *gin.Context) {
 	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
 	rule.Owner = currentUser.Email
 
+	if len(rule.Projects) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "a rule needs at least one project"})
+		return
+	}
+
+	if !rc.checkProjects(ctx, rule.Projects) {
+		return
+	}
+
 	if err := rc.ruleService.CreateRule(rule); err != nil {
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
 		return
@@ -41,31 +54,22 @@ func (rc *RuleController) CreateRule(ctx *gin.Context) {
 func (rc *RuleController) UpdateRule(ctx *gin.Context) {
 	ruleId := ctx.Param("ruleId")
 
-	// check only user admin has permission to delete all rules
-	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
-	isAdminUser := currentUser.Role == utils.AdminRole
-
-	// check only owner rule has permission to delete this rule
-	delRule, err := rc.ruleService.GetRuleById(ruleId)
-	if err != nil {
+	var rule *models.UpdateRule
+	if err := ctx.ShouldBindJSON(&rule); err != nil {
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
-	isOwnerRule := delRule.Owner != currentUser.Email
 
-	if !isAdminUser || !isOwnerRule {
-		ctx.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": "permission denied"})
+	curRule, ok := rc.findRule(ctx, ruleId)
+	if !ok {
+		return
 	}
 
-	var rule *models.UpdateRule
-	if err := ctx.ShouldBindJSON(&rule); err != nil {
-		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+	if !authorize(ctx, rc.policyService, utils.ActionUpdate, models.RuleResource(curRule)) {
 		return
 	}
 
-	curRule, err := rc.ruleService.GetRuleById(ruleId)
-	if err != nil {
-		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+	if len(rule.Projects) > 0 && !rc.checkProjects(ctx, rule.Projects) {
 		return
 	}
 
@@ -78,11 +82,16 @@ func (rc *RuleController) UpdateRule(ctx *gin.Context) {
 		return
 	}
 
-	// Only if these fields (IsThroughProxy, Roles, DestinationAddresses, DestinationPorts) is required to clean up history scanned
-	if rule.IsThroughProxy != curRule.IsThroughProxy ||
+	// Only if these fields (Type, IsThroughProxy, Roles, DestinationRoles, DestinationAddresses, DestinationPorts, DestinationServices, ServiceTargetMode, DNSServers) is required to clean up history scanned
+	if (rule.Type != "" && rule.Type != curRule.Type) ||
+		(rule.ServiceTargetMode != "" && rule.ServiceTargetMode != curRule.ServiceTargetMode) ||
+		rule.IsThroughProxy != curRule.IsThroughProxy ||
 		!utils.AreArraysEqual(rule.Roles, curRule.Roles) ||
+		!utils.AreArraysEqual(rule.DestinationRoles, curRule.DestinationRoles) ||
 		!utils.AreArraysEqual(rule.DestinationAddresses, curRule.DestinationAddresses) ||
-		!utils.AreArraysEqual(rule.DestinationPorts, curRule.DestinationPorts) {
+		!utils.AreArraysEqual(rule.DestinationPorts, curRule.DestinationPorts) ||
+		!utils.AreArraysEqual(rule.DestinationServices, curRule.DestinationServices) ||
+		!utils.AreArraysEqual(rule.DNSServers, curRule.DNSServers) {
 		if err := rc.historyScanService.CleanUpHistoryScanByRuleId(ruleId); err != nil {
 			ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
 			return
@@ -92,16 +101,6 @@ func (rc *RuleController) UpdateRule(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
-func (rc *RuleController) GetProjects(ctx *gin.Context) {
-	result, err := rc.ruleService.GetProjects()
-	if err != nil {
-		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
-		return
-	}
-
-	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": result})
-}
-
 func (rc *RuleController) GetRules(ctx *gin.Context) {
 	var currentPage = ctx.DefaultQuery("current_page", "1")
 	var pageSize = ctx.DefaultQuery("page_size", "10")
@@ -118,6 +117,12 @@ func (rc *RuleController) GetRules(ctx *gin.Context) {
 		return
 	}
 
+	scope, err := rc.policyService.Scope(middleware.CurrentSubject(ctx), utils.ActionRead, utils.ResourceRule)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
 	result, err := rc.ruleService.GetRules(&models.RuleSearchParams{
 		CurrentPage:               intCurrentPage,
 		PageSize:                  intPageSize,
@@ -125,6 +130,7 @@ func (rc *RuleController) GetRules(ctx *gin.Context) {
 		DestinationAddressKeyword: ctx.Query("destination_address_keyword"),
 		CRKeyword:                 ctx.Query("cr_keyword"),
 		ProjectKeyword:            ctx.Query("project_keyword"),
+		Scope:                     scope,
 	})
 
 	if err != nil {
@@ -143,20 +149,13 @@ func (rc *RuleController) GetRules(ctx *gin.Context) {
 func (rc *RuleController) DeleteRule(ctx *gin.Context) {
 	ruleId := ctx.Param("ruleId")
 
-	// check only user admin has permission to delete all rules
-	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
-	isAdminUser := currentUser.Role == utils.AdminRole
-
-	// check only owner rule has permission to delete this rule
-	delRule, err := rc.ruleService.GetRuleById(ruleId)
-	if err != nil {
-		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+	delRule, ok := rc.findRule(ctx, ruleId)
+	if !ok {
 		return
 	}
-	isOwnerRule := delRule.Owner != currentUser.Email
 
-	if !isAdminUser || !isOwnerRule {
-		ctx.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": "permission denied"})
+	if !authorize(ctx, rc.policyService, utils.ActionDelete, models.RuleResource(delRule)) {
+		return
 	}
 
 	if err := rc.ruleService.DeleteRule(ruleId); err != nil {
@@ -175,3 +174,65 @@ func (rc *RuleController) DeleteRule(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
 }
+
+// findRule Load the rule, it answers 404 when the rule does not exist or the user may not read it
+func (rc *RuleController) findRule(ctx *gin.Context, ruleId string) (*models.DBRule, bool) {
+	rule, err := rc.ruleService.GetRuleById(ruleId)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "no document with that Id exists"})
+			return nil, false
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return nil, false
+	}
+
+	allowed, err := rc.policyService.Can(middleware.CurrentSubject(ctx), utils.ActionRead, models.RuleResource(rule))
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return nil, false
+	}
+
+	if !allowed {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "no document with that Id exists"})
+		return nil, false
+	}
+
+	return rule, true
+}
+
+// checkProjects Check the projects a rule is created in or moved to exist and the current user may create rules in them
+func (rc *RuleController) checkProjects(ctx *gin.Context, projects []string) bool {
+	if !authorize(ctx, rc.policyService, utils.ActionCreate, &models.PolicyResource{Kind: utils.ResourceRule, Projects: projects}) {
+		return false
+	}
+
+	missing, err := rc.projectService.FindMissingProjects(projects)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return false
+	}
+
+	if len(missing) > 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "invalid projects, these do not exist: " + strings.Join(missing, ", ")})
+		return false
+	}
+
+	return true
+}
+
+// authorize Ask the policy whether the current user may do the action on the resource, it answers 403 when not
+func authorize(ctx *gin.Context, policyService services.PolicyService, action string, resource *models.PolicyResource) bool {
+	allowed, err := policyService.Can(middleware.CurrentSubject(ctx), action, resource)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return false
+	}
+
+	if !allowed {
+		ctx.JSON(http.StatusForbidden, gin.H{"status": "fail", "message": "permission denied"})
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thuongnn/clst-mgt-api/services"
+)
+
+type SecretController struct {
+	authMethodService services.AuthMethodService
+	credentialService services.CredentialService
+	authService       services.AuthService
+	signingKeyService services.SigningKeyService
+}
+
+func NewSecretController(authMethodService services.AuthMethodService, credentialService services.CredentialService, authService services.AuthService, signingKeyService services.SigningKeyService) SecretController {
+	return SecretController{authMethodService, credentialService, authService, signingKeyService}
+}
+
+// RotateSecrets re-encrypts every stored secret with the active key, the old key can be
+// removed from SECRET_ENCRYPTION_KEYS once it succeeded
+func (sc *SecretController) RotateSecrets(ctx *gin.Context) {
+	authMethods, err := sc.authMethodService.RotateSecrets()
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	credentials, err := sc.credentialService.RotateSecrets()
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	mfaSecrets, err := sc.authService.RotateMFASecrets()
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": gin.H{
+		"auth_methods": authMethods,
+		"credentials":  credentials,
+		"mfa_secrets":  mfaSecrets,
+	}})
+}
+
+// RotateSigningKeys starts a new token signing key right away, e.g. when a key may have leaked.
+// Tokens signed with the old keys stay valid until they expire.
+func (sc *SecretController) RotateSigningKeys(ctx *gin.Context) {
+	if err := sc.signingKeyService.RotateSigningKeys(true); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
@@ -2,16 +2,21 @@ package controllers
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/thuongnn/clst-mgt-api/models"
 	"github.com/thuongnn/clst-mgt-api/services"
+	"github.com/thuongnn/clst-mgt-api/utils"
+	"go.mongodb.org/mongo-driver/mongo"
 	"net/http"
 )
 
 type TriggerController struct {
 	triggerService services.TriggerService
+	ruleService    services.RuleService
+	policyService  services.PolicyService
 }
 
-func NewTriggerController(triggerService services.TriggerService) TriggerController {
-	return TriggerController{triggerService}
+func NewTriggerController(triggerService services.TriggerService, ruleService services.RuleService, policyService services.PolicyService) TriggerController {
+	return TriggerController{triggerService, ruleService, policyService}
 }
 
 func (tc *TriggerController) TriggerAll(ctx *gin.Context) {
@@ -34,6 +39,22 @@ func (tc *TriggerController) TriggerByRuleIds(ctx *gin.Context) {
 		return
 	}
 
+	for _, ruleId := range parseData.RuleIds {
+		rule, err := tc.ruleService.GetRuleById(ruleId)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "no document with that Id exists: " + ruleId})
+				return
+			}
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+
+		if !authorize(ctx, tc.policyService, utils.ActionTrigger, models.RuleResource(rule)) {
+			return
+		}
+	}
+
 	if err := tc.triggerService.TriggerByRuleIds(parseData.RuleIds); err != nil {
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
 		return
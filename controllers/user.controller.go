@@ -8,14 +8,20 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/thuongnn/clst-mgt-api/models"
 	"github.com/thuongnn/clst-mgt-api/services"
+	"github.com/thuongnn/clst-mgt-api/utils"
 )
 
 type UserController struct {
-	userService services.UserService
+	userService     services.UserService
+	authService     services.AuthService
+	tokenService    services.TokenService
+	apiTokenService services.APITokenService
+	throttleService services.LoginThrottleService
+	attemptService  services.LoginAttemptService
 }
 
-func NewUserController(userService services.UserService) UserController {
-	return UserController{userService}
+func NewUserController(userService services.UserService, authService services.AuthService, tokenService services.TokenService, apiTokenService services.APITokenService, throttleService services.LoginThrottleService, attemptService services.LoginAttemptService) UserController {
+	return UserController{userService, authService, tokenService, apiTokenService, throttleService, attemptService}
 }
 
 func (uc *UserController) GetMe(ctx *gin.Context) {
@@ -40,13 +46,18 @@ func (uc *UserController) FindUsers(ctx *gin.Context) {
 		return
 	}
 
-	result, err := uc.userService.FindUsers(&models.UserSearchParams{
+	params := &models.UserSearchParams{
 		CurrentPage:     intCurrentPage,
 		PageSize:        intPageSize,
 		NameKeyword:     ctx.Query("name_keyword"),
 		UsernameKeyword: ctx.Query("username_keyword"),
 		EmailKeyword:    ctx.Query("email_keyword"),
-	})
+	}
+	if pendingApproval, err := strconv.ParseBool(ctx.Query("pending_approval")); err == nil {
+		params.PendingApproval = &pendingApproval
+	}
+
+	result, err := uc.userService.FindUsers(params)
 
 	if err != nil {
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
@@ -75,6 +86,534 @@ func (uc *UserController) UpdateUser(ctx *gin.Context) {
 			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
 			return
 		}
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	// a deactivated user must not keep working with tokens issued before
+	if user.IsActive != nil && !*user.IsActive {
+		if err := uc.tokenService.RevokeUserSessions(userId); err != nil {
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (uc *UserController) GetMySessions(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+	currentToken := ctx.MustGet("currentToken").(*utils.TokenDetails)
+
+	sessions, err := uc.tokenService.GetUserSessions(currentUser.ID.Hex())
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	for _, session := range sessions {
+		session.Current = session.Id == currentToken.SessionId
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": sessions})
+}
+
+func (uc *UserController) DeleteMySession(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+	uc.revokeSession(ctx, currentUser.ID.Hex(), ctx.Param("sessionId"))
+}
+
+func (uc *UserController) GetUserSessions(ctx *gin.Context) {
+	userId := ctx.Param("userId")
+
+	if _, err := uc.userService.FindUserById(userId); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "no user with that Id exists"})
+		return
+	}
+
+	sessions, err := uc.tokenService.GetUserSessions(userId)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": sessions})
+}
+
+func (uc *UserController) DeleteUserSession(ctx *gin.Context) {
+	uc.revokeSession(ctx, ctx.Param("userId"), ctx.Param("sessionId"))
+}
+
+func (uc *UserController) revokeSession(ctx *gin.Context, userId string, sessionId string) {
+	if err := uc.tokenService.RevokeUserSession(userId, sessionId); err != nil {
+		if strings.Contains(err.Error(), "no session") {
+			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (uc *UserController) CreateServiceAccount(ctx *gin.Context) {
+	var input *models.ServiceAccountInput
+
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	serviceAccount, err := uc.authService.CreateServiceAccount(input)
+	if err != nil {
+		if strings.Contains(err.Error(), "already exist") {
+			ctx.JSON(http.StatusConflict, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"status": "success", "data": models.FilteredResponse(serviceAccount)})
+}
+
+func (uc *UserController) GetMyAPITokens(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+	uc.listAPITokens(ctx, currentUser.ID.Hex())
+}
+
+func (uc *UserController) CreateMyAPIToken(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+	uc.createAPIToken(ctx, currentUser.ID.Hex())
+}
+
+func (uc *UserController) DeleteMyAPIToken(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+	uc.deleteAPIToken(ctx, currentUser.ID.Hex(), ctx.Param("tokenId"))
+}
+
+func (uc *UserController) GetUserAPITokens(ctx *gin.Context) {
+	uc.listAPITokens(ctx, ctx.Param("userId"))
+}
+
+func (uc *UserController) CreateUserAPIToken(ctx *gin.Context) {
+	userId := ctx.Param("userId")
+
+	if _, err := uc.userService.FindUserById(userId); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "no user with that Id exists"})
+		return
+	}
+
+	uc.createAPIToken(ctx, userId)
+}
+
+func (uc *UserController) DeleteUserAPIToken(ctx *gin.Context) {
+	uc.deleteAPIToken(ctx, ctx.Param("userId"), ctx.Param("tokenId"))
+}
+
+func (uc *UserController) listAPITokens(ctx *gin.Context, userId string) {
+	tokens, err := uc.apiTokenService.GetAPITokensByUserId(userId)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": tokens})
+}
+
+func (uc *UserController) createAPIToken(ctx *gin.Context, userId string) {
+	var input *models.CreateAPITokenInput
+
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+
+	plainToken, apiToken, err := uc.apiTokenService.CreateAPIToken(userId, input, currentUser.Email)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	// the plain token is only returned once, only its hash is stored
+	ctx.JSON(http.StatusCreated, gin.H{"status": "success", "token": plainToken, "data": apiToken})
+}
+
+func (uc *UserController) deleteAPIToken(ctx *gin.Context, userId string, tokenId string) {
+	if err := uc.apiTokenService.DeleteAPIToken(userId, tokenId); err != nil {
+		if strings.Contains(err.Error(), "no document") {
+			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// ChangeMyPassword changes the password of the current user, every session has to log in again
+func (uc *UserController) ChangeMyPassword(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+
+	var input *models.ChangePasswordInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	// the password hashes are not cached, the user is read from Mongo
+	user, err := uc.userService.FindUserWithSecretsById(currentUser.ID.Hex())
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if err := uc.authService.ChangePassword(user, input.CurrentPassword, input.Password); err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if err := uc.tokenService.RevokeUserSessions(currentUser.ID.Hex()); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "message": "Password changed successfully, please login again"})
+}
+
+func (uc *UserController) SetupMyMFA(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+
+	setup, err := uc.authService.SetupMFA(currentUser)
+	if err != nil {
+		mfaErrorResponse(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "data": setup})
+}
+
+func (uc *UserController) EnableMyMFA(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+
+	var input *models.MFACodeInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	recoveryCodes, err := uc.authService.EnableMFA(currentUser, input.Code)
+	if err != nil {
+		mfaErrorResponse(ctx, err)
+		return
+	}
+
+	// recovery codes are only shown once, only their hashes are stored
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "recovery_codes": recoveryCodes})
+}
+
+func (uc *UserController) RegenerateMyRecoveryCodes(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+
+	var input *models.MFACodeInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if !uc.verifyMyMFACode(ctx, currentUser, input.Code) {
+		return
+	}
+
+	recoveryCodes, err := uc.authService.RegenerateRecoveryCodes(currentUser.ID.Hex())
+	if err != nil {
+		mfaErrorResponse(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "recovery_codes": recoveryCodes})
+}
+
+// verifyMyMFACode Check a code of the current user, the MFA secret is not cached so the user is read from Mongo
+func (uc *UserController) verifyMyMFACode(ctx *gin.Context, currentUser *models.UserDBResponse, code string) bool {
+	user, err := uc.userService.FindUserWithSecretsById(currentUser.ID.Hex())
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return false
+	}
+
+	if err := uc.authService.VerifyMFA(user, code, ""); err != nil {
+		mfaErrorResponse(ctx, err)
+		return false
+	}
+
+	return true
+}
+
+func (uc *UserController) DisableMyMFA(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+
+	var input *models.MFACodeInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if !uc.verifyMyMFACode(ctx, currentUser, input.Code) {
+		return
+	}
+
+	if err := uc.authService.DisableMFA(currentUser.ID.Hex()); err != nil {
+		mfaErrorResponse(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// ResetUserMFA lets an admin remove the MFA of a user who lost the authenticator and the recovery codes
+func (uc *UserController) ResetUserMFA(ctx *gin.Context) {
+	userId := ctx.Param("userId")
+
+	if err := uc.authService.DisableMFA(userId); err != nil {
+		mfaErrorResponse(ctx, err)
+		return
+	}
+
+	if err := uc.tokenService.RevokeUserSessions(userId); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func mfaErrorResponse(ctx *gin.Context, err error) {
+	if strings.Contains(err.Error(), "no document") {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+	if strings.Contains(err.Error(), "invalid") {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+}
+
+func (uc *UserController) UnlockUser(ctx *gin.Context) {
+	user, err := uc.userService.FindUserById(ctx.Param("userId"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "no user with that Id exists"})
+		return
+	}
+
+	if err := uc.throttleService.Unlock(user.Username); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (uc *UserController) GetUserLoginAttempts(ctx *gin.Context) {
+	var currentPage = ctx.DefaultQuery("current_page", "1")
+	var pageSize = ctx.DefaultQuery("page_size", "10")
+
+	intCurrentPage, err := strconv.Atoi(currentPage)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	intPageSize, err := strconv.Atoi(pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	user, err := uc.userService.FindUserById(ctx.Param("userId"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "no user with that Id exists"})
+		return
+	}
+
+	params := &models.LoginAttemptSearchParams{
+		CurrentPage: intCurrentPage,
+		PageSize:    intPageSize,
+		UserId:      user.ID.Hex(),
+		Username:    user.Username,
+	}
+	if success, err := strconv.ParseBool(ctx.Query("success")); err == nil {
+		params.Success = &success
+	}
+
+	result, err := uc.attemptService.GetLoginAttempts(params)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"data":       result.Data,
+		"total":      result.Pagination.TotalCount,
+		"pagination": result.Pagination,
+	})
+}
+
+func (uc *UserController) ApproveUser(ctx *gin.Context) {
+	if err := uc.userService.ApproveUser(ctx.Param("userId")); err != nil {
+		if strings.Contains(err.Error(), "no document") {
+			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (uc *UserController) CreateUser(ctx *gin.Context) {
+	var input *models.CreateUserInput
+
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	role := input.Role
+	if role == "" {
+		role = utils.UserRole
+	}
+
+	// accounts created by an admin don't need email verification nor approval
+	newUser, err := uc.authService.SignUpUser(&models.SignUpInput{
+		Name:       input.Name,
+		Username:   input.Username,
+		Password:   input.Password,
+		Email:      input.Email,
+		Role:       role,
+		Verified:   true,
+		IsActive:   true,
+		AuthMethod: utils.BasicAuth,
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "already exist") {
+			ctx.JSON(http.StatusConflict, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"status": "success", "data": models.FilteredResponse(newUser)})
+}
+
+func (uc *UserController) SetUserPassword(ctx *gin.Context) {
+	userId := ctx.Param("userId")
+
+	var input *models.SetPasswordInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if err := uc.authService.SetPassword(userId, input.Password); err != nil {
+		if strings.Contains(err.Error(), "no document") {
+			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	// the old password may be known to someone else, end every session that used it
+	if err := uc.tokenService.RevokeUserSessions(userId); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (uc *UserController) LogoutUser(ctx *gin.Context) {
+	userId := ctx.Param("userId")
+
+	if _, err := uc.userService.FindUserById(userId); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": "no user with that Id exists"})
+		return
+	}
+
+	if err := uc.tokenService.RevokeUserSessions(userId); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// DeleteUser removes the user, or with ?mode=anonymize keeps an anonymous placeholder
+// for the history that refers to it
+func (uc *UserController) DeleteUser(ctx *gin.Context) {
+	userId := ctx.Param("userId")
+	currentUser := ctx.MustGet("currentUser").(*models.UserDBResponse)
+
+	if currentUser.ID.Hex() == userId {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "You can't delete your own account"})
+		return
+	}
+
+	var err error
+	switch ctx.DefaultQuery("mode", "delete") {
+	case "delete":
+		err = uc.userService.DeleteUserById(userId)
+	case "anonymize":
+		err = uc.userService.AnonymizeUserById(userId)
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "mode must be delete or anonymize"})
+		return
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "no document") {
+			ctx.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		if strings.Contains(err.Error(), "invalid") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	// the credentials of the account must stop working at once
+	if err := uc.apiTokenService.DeleteAPITokensByUserId(userId); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	if err := uc.tokenService.RevokeUserSessions(userId); err != nil {
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
 		return
 	}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thuongnn/clst-mgt-api/services"
+	"github.com/thuongnn/clst-mgt-api/utils"
+)
+
+type WellKnownController struct {
+	signingKeyService services.SigningKeyService
+}
+
+func NewWellKnownController(signingKeyService services.SigningKeyService) WellKnownController {
+	return WellKnownController{signingKeyService}
+}
+
+// GetJWKS publishes the keys access tokens are verified with, in the plain JWKS format other services expect
+func (wc *WellKnownController) GetJWKS(ctx *gin.Context) {
+	jwks, err := wc.signingKeyService.GetJWKS()
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	// new keys are published this long before they sign, so a cached copy is never missing one
+	ctx.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(utils.SigningKeyPublishDelay.Seconds())))
+	ctx.JSON(http.StatusOK, jwks)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/thuongnn/clst-mgt-api/config"
@@ -13,6 +14,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -21,12 +23,13 @@ type FWHandler struct {
 	nodeService        services.NodeService
 	ruleService        services.RuleService
 	historyScanService services.HistoryScanService
+	credentialService  services.CredentialService
 	redisClient        *redis.Client
 	k8sClient          *kubernetes.Clientset
 }
 
-func NewFWHandler(ctx context.Context, nodeService services.NodeService, ruleService services.RuleService, historyScanService services.HistoryScanService, redisClient *redis.Client, k8sClient *kubernetes.Clientset) *FWHandler {
-	return &FWHandler{ctx, nodeService, ruleService, historyScanService, redisClient, k8sClient}
+func NewFWHandler(ctx context.Context, nodeService services.NodeService, ruleService services.RuleService, historyScanService services.HistoryScanService, credentialService services.CredentialService, redisClient *redis.Client, k8sClient *kubernetes.Clientset) *FWHandler {
+	return &FWHandler{ctx, nodeService, ruleService, historyScanService, credentialService, redisClient, k8sClient}
 }
 
 func (fwh FWHandler) HandleScanAllRules(message *models.EventMessage) error {
@@ -48,11 +51,7 @@ func (fwh FWHandler) HandleScanAllRules(message *models.EventMessage) error {
 		}
 
 		log.Printf("Trigger All: Scanning with rule id %s for CR (%s)\n", rule.Id.Hex(), utils.ArrToString(rule.CR))
-		if rule.IsThroughProxy {
-			go fwh.firewallScanThroughProxy(node, rule)
-		} else {
-			go fwh.firewallScan(node, rule)
-		}
+		fwh.scanRule(node, rule)
 	}
 
 	return nil
@@ -82,24 +81,88 @@ func (fwh FWHandler) HandleScanByRuleIds(message *models.EventMessage) error {
 		}
 
 		log.Printf("Trigger by Id: Scanning with rule id %s for CR (%s)\n", rule.Id.Hex(), utils.ArrToString(rule.CR))
-		if rule.IsThroughProxy {
-			go fwh.firewallScanThroughProxy(node, rule)
-		} else {
-			go fwh.firewallScan(node, rule)
-		}
+		fwh.scanRule(node, rule)
 	}
 
 	return nil
 }
 
+func (fwh FWHandler) scanRule(node *models.DBNode, rule *models.DBRule) {
+	switch {
+	case rule.Type == utils.MeshRule:
+		go fwh.meshScan(node, rule)
+	case rule.IsThroughProxy:
+		go fwh.firewallScanThroughProxy(node, rule)
+	default:
+		go fwh.firewallScan(node, rule)
+	}
+
+	if rule.Type != utils.MeshRule && len(rule.DestinationServices) > 0 {
+		go fwh.serviceScan(node, rule)
+	}
+}
+
 func (fwh FWHandler) createHistoryScan(historyScan *models.DBHistoryScan, ruleID string) {
 	if err := fwh.historyScanService.CreateHistoryScan(historyScan); err != nil {
 		log.Printf("Error creating history scan with rule ID: %s\n", ruleID)
 	}
 }
 
+// clientTLSConfig Load the client certificate of a rule, nil when the rule doesn't probe with mTLS
+func (fwh FWHandler) clientTLSConfig(rule *models.DBRule) (*tls.Config, error) {
+	if rule.CredentialId == "" {
+		return nil, nil
+	}
+
+	credential, err := fwh.credentialService.GetDecryptedCredentialById(rule.CredentialId)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot load credential %s: %v", rule.CredentialId, err)
+	}
+
+	return utils.BuildClientTLSConfig(credential)
+}
+
+// probeTLS Run the mTLS handshake on an open connection and record the outcome on the history scan
+func (fwh FWHandler) probeTLS(conn net.Conn, tlsConfig *tls.Config, serverName string, historyScan *models.DBHistoryScan) {
+	config := tlsConfig.Clone()
+	config.ServerName = serverName
+
+	alert, err := utils.ProbeTLS(conn, config)
+	if err != nil {
+		historyScan.Status = utils.StatusErrorScan
+		historyScan.ErrorMessage = fmt.Sprintf("TLS handshake failed: %v", err)
+		historyScan.TLSHandshake = utils.TLSHandshakeFailed
+		historyScan.TLSAlert = alert
+		return
+	}
+
+	historyScan.TLSHandshake = utils.TLSHandshakeSuccess
+}
+
 func (fwh FWHandler) firewallScan(node *models.DBNode, rule *models.DBRule) {
+	tlsConfig, errTLSConfig := fwh.clientTLSConfig(rule)
+	if errTLSConfig != nil {
+		log.Printf("Rule scan with node %s: %v\n", node.Name, errTLSConfig)
+	}
+
 	for _, address := range rule.DestinationAddresses {
+		host := utils.RemoveProtocol(address)
+
+		// Resolve once per address so DNS failures are not mistaken for firewall drops
+		resolvedAddresses := []string{host}
+		var resolvedHostname, dnsServer, errResolve string
+		if net.ParseIP(host) == nil {
+			resolvedHostname = strings.ToLower(host)
+
+			addresses, server, err := utils.ResolveHost(host, rule.DNSServers, utils.TimeoutScan)
+			dnsServer = server
+			resolvedAddresses = addresses
+			if err != nil {
+				log.Printf("Rule scan with node %s failed to resolve %s via %s: %v\n", node.Name, host, server, err)
+				errResolve = fmt.Sprintf("DNS resolution failed: %v", err)
+			}
+		}
+
 		for _, port := range rule.DestinationPorts {
 			historyScan := &models.DBHistoryScan{
 				RuleId:             rule.Id,
@@ -109,9 +172,26 @@ func (fwh FWHandler) firewallScan(node *models.DBNode, rule *models.DBRule) {
 				DestinationAddress: address,
 				DestinationPort:    port,
 				IsThroughProxy:     rule.IsThroughProxy,
+				ResolvedHostname:   resolvedHostname,
+				DNSServer:          dnsServer,
 				Status:             utils.StatusErrorScan,
 				UpdatedAt:          time.Now(),
 			}
+			if resolvedHostname != "" {
+				historyScan.ResolvedAddresses = resolvedAddresses
+			}
+
+			if errResolve != "" {
+				historyScan.ErrorMessage = errResolve
+				fwh.createHistoryScan(historyScan, rule.Id.Hex())
+				continue
+			}
+
+			if errTLSConfig != nil {
+				historyScan.ErrorMessage = errTLSConfig.Error()
+				fwh.createHistoryScan(historyScan, rule.Id.Hex())
+				continue
+			}
 
 			portParser, err := utils.PortParser(port)
 			if err != nil {
@@ -122,10 +202,10 @@ func (fwh FWHandler) firewallScan(node *models.DBNode, rule *models.DBRule) {
 			}
 
 			// build destination host port
-			destinationHostPort := net.JoinHostPort(utils.RemoveProtocol(address), portParser.Number)
+			destinationHostPort := net.JoinHostPort(host, portParser.Number)
 
 			// start to scan
-			conn, err := net.DialTimeout(portParser.Protocol, destinationHostPort, utils.TimeoutScan)
+			conn, err := utils.DialResolved(portParser.Protocol, resolvedAddresses, portParser.Number, utils.TimeoutScan)
 			if err != nil || conn == nil {
 				log.Printf("Rule scan with node %s failed to connect to %s\n", node.Name, destinationHostPort)
 				if err != nil {
@@ -134,6 +214,18 @@ func (fwh FWHandler) firewallScan(node *models.DBNode, rule *models.DBRule) {
 			} else {
 				log.Printf("Rule scan with node %s successfully connected to %s\n", node.Name, destinationHostPort)
 				historyScan.Status = utils.StatusSuccessScan
+
+				// the mTLS probe consumes the connection, so banners are only captured on plain TCP
+				if tlsConfig != nil && portParser.Protocol == "tcp" {
+					serverName := host
+					if rule.TLSServerName != "" {
+						serverName = rule.TLSServerName
+					}
+					fwh.probeTLS(conn, tlsConfig, serverName, historyScan)
+				} else if rule.CaptureBanner && portParser.Protocol == "tcp" {
+					historyScan.Banner = utils.GrabBanner(conn, utils.BannerProtocol(rule.BannerProtocol, portParser.Number), host, rule.BannerMaxBytes)
+				}
+				conn.Close()
 			}
 
 			fwh.createHistoryScan(historyScan, rule.Id.Hex())
@@ -196,3 +288,148 @@ func (fwh FWHandler) firewallScanThroughProxy(node *models.DBNode, rule *models.
 		resp.Body.Close()
 	}
 }
+
+func (fwh FWHandler) meshScan(node *models.DBNode, rule *models.DBRule) {
+	// Without destination roles, the rule is a full mesh between the nodes of its roles
+	destinationRoles := rule.DestinationRoles
+	if len(destinationRoles) == 0 {
+		destinationRoles = rule.Roles
+	}
+
+	peers, err := fwh.nodeService.GetNodesByRoles(destinationRoles)
+	if err != nil {
+		log.Printf("Cannot get destination nodes for mesh rule %s: %v\n", rule.Id.Hex(), err)
+		return
+	}
+
+	for _, peer := range peers {
+		if peer.NodeId == node.NodeId {
+			continue
+		}
+
+		address := utils.NodeProbeAddress(peer.Address)
+		for _, port := range rule.DestinationPorts {
+			historyScan := &models.DBHistoryScan{
+				RuleId:              rule.Id,
+				NodeName:            node.Name,
+				NodeId:              node.NodeId,
+				NodeAddress:         node.Address,
+				DestinationAddress:  address,
+				DestinationPort:     port,
+				DestinationNodeId:   peer.NodeId,
+				DestinationNodeName: peer.Name,
+				Status:              utils.StatusErrorScan,
+				UpdatedAt:           time.Now(),
+			}
+
+			portParser, err := utils.PortParser(port)
+			if err != nil {
+				log.Printf("Cannot parse the port %s: %v\n", port, err)
+				historyScan.ErrorMessage = fmt.Sprintf("Cannot parse the port %s: %v", port, err)
+				fwh.createHistoryScan(historyScan, rule.Id.Hex())
+				continue
+			}
+
+			destinationHostPort := net.JoinHostPort(address, portParser.Number)
+
+			var errProbe error
+			if portParser.Protocol == "udp" {
+				errProbe = utils.ProbeUDP(destinationHostPort, utils.TimeoutScan)
+			} else {
+				errProbe = utils.ProbeTCP(destinationHostPort, utils.TimeoutScan)
+			}
+
+			if errProbe != nil {
+				log.Printf("Mesh scan from node %s failed to reach node %s on %s/%s\n", node.Name, peer.Name, destinationHostPort, portParser.Protocol)
+				historyScan.ErrorMessage = errProbe.Error()
+			} else {
+				log.Printf("Mesh scan from node %s successfully reached node %s on %s/%s\n", node.Name, peer.Name, destinationHostPort, portParser.Protocol)
+				historyScan.Status = utils.StatusSuccessScan
+			}
+
+			fwh.createHistoryScan(historyScan, rule.Id.Hex())
+		}
+	}
+}
+
+func (fwh FWHandler) serviceScan(node *models.DBNode, rule *models.DBRule) {
+	for _, rawService := range rule.DestinationServices {
+		// the targets are pod IPs that come and go, drop the results of the previous scan first
+		if err := fwh.historyScanService.CleanUpServiceTargets(rule.Id.Hex(), node.NodeId, rawService); err != nil {
+			log.Printf("Cannot clean up the previous targets of the service %s: %v\n", rawService, err)
+		}
+
+		historyScan := &models.DBHistoryScan{
+			RuleId:             rule.Id,
+			NodeName:           node.Name,
+			NodeId:             node.NodeId,
+			NodeAddress:        node.Address,
+			DestinationAddress: rawService,
+			DestinationService: rawService,
+			Status:             utils.StatusErrorScan,
+			UpdatedAt:          time.Now(),
+		}
+
+		serviceRef, err := utils.ServiceRefParser(rawService)
+		if err != nil {
+			log.Printf("Cannot parse the service %s: %v\n", rawService, err)
+			historyScan.ErrorMessage = err.Error()
+			fwh.createHistoryScan(historyScan, rule.Id.Hex())
+			continue
+		}
+
+		targets, err := utils.ResolveServiceTargets(fwh.k8sClient, fwh.ctx, serviceRef, rule.ServiceTargetMode)
+		if err == nil && len(targets) == 0 {
+			err = fmt.Errorf("no ready target")
+		}
+		if err != nil {
+			log.Printf("Cannot resolve the service %s: %v\n", rawService, err)
+			historyScan.ErrorMessage = fmt.Sprintf("Cannot resolve the service %s: %v", rawService, err)
+			fwh.createHistoryScan(historyScan, rule.Id.Hex())
+			continue
+		}
+
+		for _, target := range targets {
+			targetScan := *historyScan
+			targetScan.DestinationAddress = target.Address
+			targetScan.DestinationPort = target.Protocol + "/" + target.Port
+			targetScan.TargetKind = target.Kind
+			targetScan.EndpointTargetRef = target.TargetRef
+			targetScan.EndpointNodeName = target.NodeName
+
+			destinationHostPort := net.JoinHostPort(target.Address, target.Port)
+
+			switch target.Protocol {
+			case "tcp":
+				conn, err := net.DialTimeout("tcp", destinationHostPort, utils.TimeoutScan)
+				if err != nil {
+					log.Printf("Service scan with node %s failed to connect to %s (%s %s)\n", node.Name, rawService, target.Kind, destinationHostPort)
+					targetScan.ErrorMessage = err.Error()
+					break
+				}
+
+				log.Printf("Service scan with node %s successfully connected to %s (%s %s)\n", node.Name, rawService, target.Kind, destinationHostPort)
+				targetScan.Status = utils.StatusSuccessScan
+				if rule.CaptureBanner {
+					targetScan.Banner = utils.GrabBanner(conn, utils.BannerProtocol(rule.BannerProtocol, target.Port), serviceRef.Name+"."+serviceRef.Namespace, rule.BannerMaxBytes)
+				}
+				conn.Close()
+			case "udp":
+				// real services don't answer the mesh payload, so silence can't be told apart from a drop
+				status, err := utils.ProbeUDPService(destinationHostPort, utils.TimeoutScan)
+				targetScan.Status = status
+				if err != nil {
+					log.Printf("Service scan with node %s marked %s (%s %s/udp) %s: %v\n", node.Name, rawService, target.Kind, destinationHostPort, status, err)
+					targetScan.ErrorMessage = err.Error()
+				} else {
+					log.Printf("Service scan with node %s found %s (%s %s/udp) %s\n", node.Name, rawService, target.Kind, destinationHostPort, status)
+				}
+			default:
+				targetScan.Status = utils.StatusUnsupportedScan
+				targetScan.ErrorMessage = fmt.Sprintf("Protocol %s is not supported by the service scan", target.Protocol)
+			}
+
+			fwh.createHistoryScan(&targetScan, rule.Id.Hex())
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// MeshResponder listens on the configured ports so that the other workers
+// can verify node-to-node paths that have no real service behind them.
+type MeshResponder struct {
+	ports []*models.Port
+}
+
+func NewMeshResponder(rawPorts string) (*MeshResponder, error) {
+	var ports []*models.Port
+	for _, rawPort := range strings.Split(rawPorts, ",") {
+		if strings.TrimSpace(rawPort) == "" {
+			continue
+		}
+
+		port, err := utils.PortParser(rawPort)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse mesh responder port %s: %v ", rawPort, err)
+		}
+		ports = append(ports, port)
+	}
+
+	return &MeshResponder{ports}, nil
+}
+
+// Start Listen on every configured port. A port that can't be bound is skipped so the
+// others keep answering, the returned error lists the skipped ports.
+func (mr *MeshResponder) Start() error {
+	var failures []string
+	for _, port := range mr.ports {
+		address := ":" + port.Number
+
+		if port.Protocol == "udp" {
+			conn, err := net.ListenPacket("udp", address)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s/%s: %v", port.Number, port.Protocol, err))
+				continue
+			}
+			go mr.serveUDP(conn)
+		} else {
+			listener, err := net.Listen("tcp", address)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s/%s: %v", port.Number, port.Protocol, err))
+				continue
+			}
+			go mr.serveTCP(listener)
+		}
+
+		log.Printf("Mesh responder listening on %s/%s\n", port.Number, port.Protocol)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("mesh responder skipped %s", strings.Join(failures, ", "))
+	}
+
+	return nil
+}
+
+func (mr *MeshResponder) serveTCP(listener net.Listener) {
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			delay = acceptBackoff(delay)
+			log.Printf("Mesh responder failed to accept connection, retrying in %v: %v\n", delay, err)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		// accepting is all the prober needs, the ack only helps people debugging with nc
+		conn.Write([]byte(utils.MeshAckPayload + "\n"))
+		conn.Close()
+	}
+}
+
+func (mr *MeshResponder) serveUDP(conn net.PacketConn) {
+	buffer := make([]byte, 512)
+	var delay time.Duration
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			delay = acceptBackoff(delay)
+			log.Printf("Mesh responder failed to read packet, retrying in %v: %v\n", delay, err)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		if string(buffer[:n]) != utils.MeshProbePayload {
+			continue
+		}
+
+		if _, err := conn.WriteTo([]byte(utils.MeshAckPayload), addr); err != nil {
+			log.Printf("Mesh responder failed to answer %s: %v\n", addr, err)
+		}
+	}
+}
+
+// acceptBackoff Double the wait after each consecutive error, like net/http does for Accept
+func acceptBackoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return 5 * time.Millisecond
+	}
+
+	if delay *= 2; delay > time.Second {
+		delay = time.Second
+	}
+
+	return delay
+}
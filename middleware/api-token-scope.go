@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+)
+
+// apiTokenScopes Routes reachable with an API token and the scope they need,
+// every route missing from this table is denied to API tokens
+var apiTokenScopes = map[string]string{
+	"GET /api/users/me": "",
+
+	"GET /api/projects/":                   utils.ScopeRulesRead,
+	"GET /api/projects/:projectId":         utils.ScopeRulesRead,
+	"GET /api/rules/":                      utils.ScopeRulesRead,
+	"GET /api/history-scan/:ruleId":        utils.ScopeRulesRead,
+	"GET /api/history-scan/:ruleId/matrix": utils.ScopeRulesRead,
+
+	"POST /api/rules/":          utils.ScopeRulesManage,
+	"PATCH /api/rules/:ruleId":  utils.ScopeRulesManage,
+	"DELETE /api/rules/:ruleId": utils.ScopeRulesManage,
+
+	"POST /api/triggers/":    utils.ScopeScansTrigger,
+	"POST /api/triggers/all": utils.ScopeScansTrigger,
+}
+
+func apiTokenAllowed(ctx *gin.Context, apiToken *models.APIToken) bool {
+	scope, exists := apiTokenScopes[ctx.Request.Method+" "+ctx.FullPath()]
+	if !exists {
+		return false
+	}
+
+	return scope == "" || utils.Contains(apiToken.Scopes, scope)
+}
@@ -1,25 +1,23 @@
 package middleware
 
 import (
-	"fmt"
 	"github.com/thuongnn/clst-mgt-api/models"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/thuongnn/clst-mgt-api/config"
 	"github.com/thuongnn/clst-mgt-api/services"
 	"github.com/thuongnn/clst-mgt-api/utils"
 )
 
-func DeserializeUser(userService services.UserService) gin.HandlerFunc {
+func DeserializeUser(userService services.UserService, tokenService services.TokenService, apiTokenService services.APITokenService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var accessToken string
 
 		authorizationHeader := ctx.Request.Header.Get("Authorization")
 		fields := strings.Fields(authorizationHeader)
 
-		if len(fields) != 0 && fields[0] == "Bearer" {
+		if len(fields) == 2 && fields[0] == "Bearer" {
 			accessToken = fields[1]
 		}
 
@@ -28,24 +26,78 @@ func DeserializeUser(userService services.UserService) gin.HandlerFunc {
 			return
 		}
 
-		appConfig, _ := config.LoadConfig(".")
-		sub, err := utils.ValidateToken(accessToken, appConfig.AccessTokenPublicKey)
+		if strings.HasPrefix(accessToken, utils.APITokenPrefix) {
+			deserializeAPITokenUser(ctx, accessToken, userService, apiTokenService)
+			return
+		}
+
+		tokenDetails, err := tokenService.ValidateAccessToken(accessToken)
 		if err != nil {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": err.Error()})
 			return
 		}
 
-		user, err := userService.FindUserById(fmt.Sprint(sub))
+		active, err := tokenService.IsSessionActive(tokenDetails.SessionId)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+			return
+		}
+
+		if !active {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "Your session has expired, please login again"})
+			return
+		}
+
+		user, err := userService.FindUserById(tokenDetails.Subject)
 		if err != nil {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "The user belonging to this token no logger exists"})
 			return
 		}
 
+		if tokenDetails.IssuedBefore(user.PasswordChangedAt) {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "Password was changed, please login again"})
+			return
+		}
+
+		if !user.IsActive {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "Account is disabled, please contact admin for support"})
+			return
+		}
+
 		ctx.Set("currentUser", user)
+		ctx.Set("currentToken", tokenDetails)
 		ctx.Next()
 	}
 }
 
+func deserializeAPITokenUser(ctx *gin.Context, accessToken string, userService services.UserService, apiTokenService services.APITokenService) {
+	apiToken, err := apiTokenService.ValidateAPIToken(accessToken)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	user, err := userService.FindUserById(apiToken.UserId.Hex())
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "The user belonging to this token no logger exists"})
+		return
+	}
+
+	if !user.IsActive {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "message": "Account is disabled, please contact admin for support"})
+		return
+	}
+
+	if !apiTokenAllowed(ctx, apiToken) {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": "API token is not allowed to access this endpoint"})
+		return
+	}
+
+	ctx.Set("currentUser", user)
+	ctx.Set("currentAPIToken", apiToken)
+	ctx.Next()
+}
+
 func AdminOnly() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		currentUser, exists := ctx.MustGet("currentUser").(*models.UserDBResponse)
@@ -55,6 +107,7 @@ func AdminOnly() gin.HandlerFunc {
 		}
 
 		if currentUser.Role != utils.AdminRole {
+			services.LogPermissionDenied(currentUser, ctx.Request.Method, ctx.FullPath(), "admin only")
 			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": "Access denied, admin only"})
 			return
 		}
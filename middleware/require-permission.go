@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/services"
+)
+
+// CurrentSubject The user of the request and the project its API token is bound to, for policy checks
+func CurrentSubject(ctx *gin.Context) *models.PolicySubject {
+	subject := &models.PolicySubject{}
+
+	if user, exists := ctx.Get("currentUser"); exists {
+		subject.User, _ = user.(*models.UserDBResponse)
+	}
+
+	if apiToken, exists := ctx.Get("currentAPIToken"); exists {
+		if token, ok := apiToken.(*models.APIToken); ok {
+			subject.Project = token.Project
+		}
+	}
+
+	return subject
+}
+
+// RequirePermission Let the request through when the current user may do the action on the kind of resource
+// as a whole, checks on single resources are done in the controllers against the same policy
+func RequirePermission(policyService services.PolicyService, action string, kind string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		allowed, err := policyService.Can(CurrentSubject(ctx), action, &models.PolicyResource{Kind: kind})
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"status": "error", "message": err.Error()})
+			return
+		}
+
+		if !allowed {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "fail", "message": "permission denied"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
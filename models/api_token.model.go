@@ -0,0 +1,34 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// APIToken is a long-lived personal access token, only its sha256 hash is stored
+type APIToken struct {
+	Id         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserId     primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Name       string             `json:"name,omitempty" bson:"name,omitempty"`
+	TokenHash  string             `json:"-" bson:"token_hash,omitempty"`
+	Prefix     string             `json:"prefix,omitempty" bson:"prefix,omitempty"`
+	Scopes     []string           `json:"scopes,omitempty" bson:"scopes,omitempty"`
+	Project    string             `json:"project,omitempty" bson:"project,omitempty"`
+	ExpiresAt  *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	CreatedBy  string             `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	CreateAt   time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+}
+
+type CreateAPITokenInput struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,dive,oneof=rules:read scans:trigger rules:manage"`
+	Project       string   `json:"project,omitempty"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty" binding:"omitempty,min=1,max=3650"`
+}
+
+type ServiceAccountInput struct {
+	Name     string `json:"name" binding:"required"`
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"omitempty,email"`
+}
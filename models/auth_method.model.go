@@ -16,6 +16,14 @@ type AuthMethod struct {
 	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 }
 
+// UpdateAuthMethod only the fields that were sent are changed, the type of a method is fixed
+type UpdateAuthMethod struct {
+	Name      string          `json:"name,omitempty" bson:"name,omitempty"`
+	IsActive  *bool           `json:"is_active,omitempty" bson:"is_active,omitempty"`
+	Configs   json.RawMessage `json:"configs,omitempty" bson:"configs,omitempty"`
+	UpdatedAt time.Time       `json:"-" bson:"updated_at"`
+}
+
 type AuthMethodResponse struct {
 	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Name      string             `json:"name,omitempty" bson:"name,omitempty" binding:"required"`
@@ -25,19 +33,37 @@ type AuthMethodResponse struct {
 	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 }
 
+// BasicAuthConfig RegistrationPolicy is open (default), disabled or restricted to AllowedEmailDomains.
+// With RequireApproval new accounts can't log in until an admin approves them.
 type BasicAuthConfig struct {
-	ButtonText string `json:"button_text"`
+	ButtonText          string   `json:"button_text"`
+	RequireMFA          bool     `json:"require_mfa"`
+	RegistrationPolicy  string   `json:"registration_policy"`
+	AllowedEmailDomains []string `json:"allowed_email_domains"`
+	RequireApproval     bool     `json:"require_approval"`
 }
 
 type OAuth2Config struct {
-	ClientID           string   `json:"client_id"`
-	ClientSecret       string   `json:"client_secret"`
-	WellKnownConfigURL string   `json:"well_known_config_url"`
-	IssuerURL          string   `json:"issuer_url"`
-	RedirectURL        string   `json:"redirect_url"`
-	Scopes             []string `json:"scopes"`
-	AdminGroups        []string `json:"admin_groups"`
-	ButtonText         string   `json:"button_text"`
+	ClientID           string         `json:"client_id"`
+	ClientSecret       string         `json:"client_secret"`
+	WellKnownConfigURL string         `json:"well_known_config_url"`
+	IssuerURL          string         `json:"issuer_url"`
+	RedirectURL        string         `json:"redirect_url"`
+	Scopes             []string       `json:"scopes"`
+	AdminGroups        []string       `json:"admin_groups"`
+	GroupMappings      []GroupMapping `json:"group_mappings"`
+	RequireApproval    bool           `json:"require_approval"`
+	ButtonText         string         `json:"button_text"`
+}
+
+// GroupMapping grants a role and/or project memberships to users whose claim contains the value.
+// Claim defaults to "groups". Projects are joined as viewer on login and left again once no mapping
+// matches anymore, a membership an admin changed is kept.
+type GroupMapping struct {
+	Claim    string   `json:"claim,omitempty"`
+	Value    string   `json:"value"`
+	Role     string   `json:"role,omitempty"`
+	Projects []string `json:"projects,omitempty"`
 }
 
 type WellKnownConfig struct {
@@ -45,4 +71,72 @@ type WellKnownConfig struct {
 	AuthURL     string `json:"authorization_endpoint"`
 	TokenURL    string `json:"token_endpoint"`
 	UserInfoURL string `json:"userinfo_endpoint"`
+	JwksURI     string `json:"jwks_uri"`
+}
+
+// OAuth2LoginState is kept in Redis between the authorization redirect and the callback,
+// SAML logins keep the AuthnRequest id in it
+type OAuth2LoginState struct {
+	AuthMethodId string `json:"auth_method_id"`
+	CodeVerifier string `json:"code_verifier,omitempty"`
+	Nonce        string `json:"nonce,omitempty"`
+	RequestId    string `json:"request_id,omitempty"`
+}
+
+// LDAPConfig configures a bind-and-search login against an LDAP directory.
+// UserSearchFilter may use {username}, GroupSearchFilter may use {dn} and {username}.
+type LDAPConfig struct {
+	URL                string         `json:"url"`
+	StartTLS           bool           `json:"start_tls"`
+	InsecureSkipVerify bool           `json:"insecure_skip_verify"`
+	CACertificate      string         `json:"ca_certificate"`
+	BindDN             string         `json:"bind_dn"`
+	BindPassword       string         `json:"bind_password"`
+	UserSearchBase     string         `json:"user_search_base"`
+	UserSearchFilter   string         `json:"user_search_filter"`
+	UsernameAttribute  string         `json:"username_attribute"`
+	EmailAttribute     string         `json:"email_attribute"`
+	NameAttribute      string         `json:"name_attribute"`
+	GroupSearchBase    string         `json:"group_search_base"`
+	GroupSearchFilter  string         `json:"group_search_filter"`
+	GroupNameAttribute string         `json:"group_name_attribute"`
+	GroupMappings      []GroupMapping `json:"group_mappings"`
+	RequireApproval    bool           `json:"require_approval"`
+	ButtonText         string         `json:"button_text"`
+}
+
+// LDAPUser is the directory entry of a user who passed the bind
+type LDAPUser struct {
+	DN       string
+	Username string
+	Email    string
+	Name     string
+	Claims   map[string]interface{}
+}
+
+// SAMLConfig configures the service provider side of a SAML 2.0 login.
+// IdPCertificate is the PEM or base64 certificate the IdP signs assertions with.
+type SAMLConfig struct {
+	IdPEntityID       string         `json:"idp_entity_id"`
+	IdPSSOURL         string         `json:"idp_sso_url"`
+	IdPCertificate    string         `json:"idp_certificate"`
+	SPEntityID        string         `json:"sp_entity_id"`
+	ACSURL            string         `json:"acs_url"`
+	NameIDFormat      string         `json:"name_id_format"`
+	UsernameAttribute string         `json:"username_attribute"`
+	EmailAttribute    string         `json:"email_attribute"`
+	NameAttribute     string         `json:"name_attribute"`
+	GroupsAttribute   string         `json:"groups_attribute"`
+	GroupMappings     []GroupMapping `json:"group_mappings"`
+	RequireApproval   bool           `json:"require_approval"`
+	ButtonText        string         `json:"button_text"`
+}
+
+// SAMLUser is the subject of a verified SAML assertion
+type SAMLUser struct {
+	NameID   string
+	Username string
+	Email    string
+	Name     string
+	Claims   map[string]interface{}
 }
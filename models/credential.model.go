@@ -0,0 +1,43 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Credential is a client certificate used by rules to probe mutual-TLS endpoints.
+// PrivateKey is encrypted at rest and never returned by the API.
+type Credential struct {
+	Id                 primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name               string             `json:"name,omitempty" bson:"name,omitempty" binding:"required"`
+	Description        string             `json:"description,omitempty" bson:"description,omitempty"`
+	Certificate        string             `json:"certificate,omitempty" bson:"certificate,omitempty" binding:"required"`
+	PrivateKey         string             `json:"private_key,omitempty" bson:"private_key,omitempty" binding:"required"`
+	CACertificate      string             `json:"ca_certificate,omitempty" bson:"ca_certificate,omitempty"`
+	InsecureSkipVerify bool               `json:"insecure_skip_verify" bson:"insecure_skip_verify" default:"false"`
+	CreatedBy          string             `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	CreateAt           time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	UpdatedAt          time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+type UpdateCredential struct {
+	Name               string    `json:"name,omitempty" bson:"name,omitempty"`
+	Description        string    `json:"description,omitempty" bson:"description,omitempty"`
+	Certificate        string    `json:"certificate,omitempty" bson:"certificate,omitempty"`
+	PrivateKey         string    `json:"private_key,omitempty" bson:"private_key,omitempty"`
+	CACertificate      string    `json:"ca_certificate,omitempty" bson:"ca_certificate,omitempty"`
+	InsecureSkipVerify *bool     `json:"insecure_skip_verify,omitempty" bson:"insecure_skip_verify,omitempty"`
+	UpdatedAt          time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+type CredentialResponse struct {
+	Id                 primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name               string             `json:"name,omitempty" bson:"name,omitempty"`
+	Description        string             `json:"description,omitempty" bson:"description,omitempty"`
+	Certificate        string             `json:"certificate,omitempty" bson:"certificate,omitempty"`
+	CACertificate      string             `json:"ca_certificate,omitempty" bson:"ca_certificate,omitempty"`
+	InsecureSkipVerify bool               `json:"insecure_skip_verify" bson:"insecure_skip_verify"`
+	CreatedBy          string             `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	CreateAt           time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	UpdatedAt          time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
@@ -6,15 +6,85 @@ import (
 )
 
 type DBHistoryScan struct {
-	Id                 primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	RuleId             primitive.ObjectID `json:"rule_id,omitempty" bson:"rule_id,omitempty"`
-	NodeId             string             `json:"node_id,omitempty" bson:"node_id,omitempty"`
-	NodeName           string             `json:"node_name,omitempty" bson:"node_name,omitempty"`
-	NodeAddress        DBNodeAddress      `json:"node_address,omitempty" bson:"node_address,omitempty"`
-	DestinationAddress string             `json:"destination_address" bson:"destination_address,omitempty"`
-	DestinationPort    string             `json:"destination_port" bson:"destination_port,omitempty"`
-	IsThroughProxy     bool               `json:"is_through_proxy,omitempty" bson:"is_through_proxy,omitempty"`
-	Status             string             `json:"status,omitempty" bson:"status,omitempty"`
-	ErrorMessage       string             `json:"error_message" bson:"error_message,omitempty"`
-	UpdatedAt          time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	Id                  primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	RuleId              primitive.ObjectID `json:"rule_id,omitempty" bson:"rule_id,omitempty"`
+	NodeId              string             `json:"node_id,omitempty" bson:"node_id,omitempty"`
+	NodeName            string             `json:"node_name,omitempty" bson:"node_name,omitempty"`
+	NodeAddress         DBNodeAddress      `json:"node_address,omitempty" bson:"node_address,omitempty"`
+	DestinationAddress  string             `json:"destination_address" bson:"destination_address,omitempty"`
+	DestinationPort     string             `json:"destination_port" bson:"destination_port,omitempty"`
+	DestinationNodeId   string             `json:"destination_node_id,omitempty" bson:"destination_node_id,omitempty"`
+	DestinationNodeName string             `json:"destination_node_name,omitempty" bson:"destination_node_name,omitempty"`
+	DestinationService  string             `json:"destination_service,omitempty" bson:"destination_service,omitempty"`
+	TargetKind          string             `json:"target_kind,omitempty" bson:"target_kind,omitempty"`
+	EndpointTargetRef   string             `json:"endpoint_target_ref,omitempty" bson:"endpoint_target_ref,omitempty"`
+	EndpointNodeName    string             `json:"endpoint_node_name,omitempty" bson:"endpoint_node_name,omitempty"`
+	ResolvedHostname    string             `json:"resolved_hostname,omitempty" bson:"resolved_hostname,omitempty"`
+	DNSServer           string             `json:"dns_server,omitempty" bson:"dns_server,omitempty"`
+	ResolvedAddresses   []string           `json:"resolved_addresses,omitempty" bson:"resolved_addresses,omitempty"`
+	Banner              string             `json:"banner,omitempty" bson:"banner,omitempty"`
+	TLSHandshake        string             `json:"tls_handshake,omitempty" bson:"tls_handshake,omitempty"`
+	TLSAlert            string             `json:"tls_alert,omitempty" bson:"tls_alert,omitempty"`
+	IsThroughProxy      bool               `json:"is_through_proxy,omitempty" bson:"is_through_proxy,omitempty"`
+	Status              string             `json:"status,omitempty" bson:"status,omitempty"`
+	ErrorMessage        string             `json:"error_message" bson:"error_message,omitempty"`
+	UpdatedAt           time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+// MeshMatrix is the source × destination view of a mesh rule's history scan
+type MeshMatrix struct {
+	RuleId       primitive.ObjectID `json:"rule_id"`
+	Sources      []*MeshMatrixNode  `json:"sources"`
+	Destinations []*MeshMatrixNode  `json:"destinations"`
+	Cells        []*MeshMatrixCell  `json:"cells"`
+}
+
+type MeshMatrixNode struct {
+	NodeId   string `json:"node_id"`
+	NodeName string `json:"node_name"`
+}
+
+type MeshMatrixCell struct {
+	SourceNodeId      string            `json:"source_node_id"`
+	DestinationNodeId string            `json:"destination_node_id"`
+	Status            string            `json:"status"`
+	Ports             []*MeshMatrixPort `json:"ports"`
+}
+
+type MeshMatrixPort struct {
+	Port         string    `json:"port"`
+	Status       string    `json:"status"`
+	ErrorMessage string    `json:"error_message"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// DNSConsistencyReport compares what every node resolved for the same hostname. IsConsistent only compares
+// answers between nodes asking the same DNS server and failed lookups are counted apart instead of as an
+// answer of their own. Servers answering differently from each other are reported as a separate
+// split_horizon finding, which is often intended.
+type DNSConsistencyReport struct {
+	Hostname        string                  `json:"hostname"`
+	IsConsistent    bool                    `json:"is_consistent"`
+	DistinctAnswers int                     `json:"distinct_answers"`
+	FailedLookups   int                     `json:"failed_lookups"`
+	Findings        []string                `json:"findings"`
+	DNSServers      []*DNSServerConsistency `json:"dns_servers"`
+	Answers         []*DNSNodeAnswer        `json:"answers"`
+}
+
+// DNSServerConsistency compares the answers the nodes got from one DNS server
+type DNSServerConsistency struct {
+	DNSServer       string `json:"dns_server"`
+	IsConsistent    bool   `json:"is_consistent"`
+	DistinctAnswers int    `json:"distinct_answers"`
+	FailedLookups   int    `json:"failed_lookups"`
+}
+
+type DNSNodeAnswer struct {
+	NodeId            string    `json:"node_id"`
+	NodeName          string    `json:"node_name"`
+	DNSServer         string    `json:"dns_server"`
+	ResolvedAddresses []string  `json:"resolved_addresses"`
+	ErrorMessage      string    `json:"error_message"`
+	UpdatedAt         time.Time `json:"updated_at"`
 }
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type LoginAttempt struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId     string             `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Username   string             `json:"username" bson:"username"`
+	IPAddress  string             `json:"ip_address" bson:"ip_address"`
+	UserAgent  string             `json:"user_agent" bson:"user_agent"`
+	AuthMethod string             `json:"auth_method" bson:"auth_method"`
+	Success    bool               `json:"success" bson:"success"`
+	Reason     string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type LoginAttemptListResponse struct {
+	Data       []*LoginAttempt `json:"data"`
+	Pagination *Pagination     `json:"pagination"`
+}
+
+type LoginAttemptSearchParams struct {
+	CurrentPage int    `json:"current_page"`
+	PageSize    int    `json:"page_size"`
+	UserId      string `json:"user_id"`
+	Username    string `json:"username"`
+	Success     *bool  `json:"success"`
+}
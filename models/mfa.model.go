@@ -0,0 +1,27 @@
+package models
+
+// MFAChallenge is kept in Redis between the password check and the second factor.
+// Enroll is set when MFA is enforced but the user has not set it up yet.
+type MFAChallenge struct {
+	UserId string `json:"user_id"`
+	Enroll bool   `json:"enroll"`
+}
+
+type MFAVerifyInput struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFAEnrollInput struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+type MFACodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFASetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
@@ -0,0 +1,41 @@
+package models
+
+// PolicySubject is who asks for a permission, Project is set when the request uses a project-bound API token
+type PolicySubject struct {
+	User    *UserDBResponse
+	Project string
+}
+
+// PolicyResource is what a permission is asked for. A resource without projects and owner stands for
+// the kind as a whole, e.g. triggering every rule.
+type PolicyResource struct {
+	Kind     string
+	Id       string
+	Owner    string
+	CoOwners []string
+	Projects []string
+}
+
+// PolicyScope limits a listing to the resources of these projects and the rules Owner owns or co-owns
+type PolicyScope struct {
+	Projects []string
+	Owner    string
+}
+
+func RuleResource(rule *DBRule) *PolicyResource {
+	return &PolicyResource{
+		Kind:     "rule",
+		Id:       rule.Id.Hex(),
+		Owner:    rule.Owner,
+		CoOwners: rule.CoOwners,
+		Projects: rule.Projects,
+	}
+}
+
+func ProjectResource(project *DBProject) *PolicyResource {
+	return &PolicyResource{
+		Kind:     "project",
+		Id:       project.Id.Hex(),
+		Projects: []string{project.Name},
+	}
+}
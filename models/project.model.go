@@ -1 +1,41 @@
 package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProjectMember grants a user a role (viewer, editor or owner) in a project.
+// Managed memberships come from the group mappings of SSO logins and follow the IdP groups.
+type ProjectMember struct {
+	UserId  primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Role    string             `json:"role" bson:"role"`
+	AddedAt time.Time          `json:"added_at,omitempty" bson:"added_at,omitempty"`
+	Managed bool               `json:"managed,omitempty" bson:"managed,omitempty"`
+}
+
+// DBProject groups rules, rules reference their projects by name so the name never changes
+type DBProject struct {
+	Id          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Members     []ProjectMember    `json:"members" bson:"members"`
+	CreateAt    time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	UpdatedAt   time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+type CreateProjectInput struct {
+	Name        string `json:"name" binding:"required,max=64"`
+	Description string `json:"description,omitempty" binding:"max=512"`
+}
+
+type UpdateProject struct {
+	Description string    `json:"description" bson:"description" binding:"max=512"`
+	UpdatedAt   time.Time `json:"-" bson:"updated_at"`
+}
+
+type ProjectMemberInput struct {
+	UserId string `json:"user_id" binding:"required"`
+	Role   string `json:"role" binding:"required,oneof=viewer editor owner"`
+}
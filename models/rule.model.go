@@ -8,32 +8,52 @@ import (
 
 type DBRule struct {
 	Id                   primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Type                 string             `json:"type,omitempty" bson:"type,omitempty" binding:"omitempty,oneof=standard mesh"`
 	Status               int                `json:"status,omitempty" bson:"status,omitempty"`
 	Roles                []string           `json:"roles,omitempty" bson:"roles,omitempty"`
+	DestinationRoles     []string           `json:"destination_roles,omitempty" bson:"destination_roles,omitempty"`
 	Projects             []string           `json:"projects,omitempty" bson:"projects,omitempty"`
 	DestinationAddresses []string           `json:"destination_addresses,omitempty" bson:"destination_addresses,omitempty"`
 	DestinationPorts     []string           `json:"destination_ports,omitempty" bson:"destination_ports,omitempty"`
 	DestinationServices  []string           `json:"destination_services,omitempty" bson:"destination_services,omitempty"`
+	ServiceTargetMode    string             `json:"service_target_mode,omitempty" bson:"service_target_mode,omitempty" binding:"omitempty,oneof=cluster_ip endpoints both"`
+	DNSServers           []string           `json:"dns_servers,omitempty" bson:"dns_servers,omitempty"`
 	IsThroughProxy       bool               `json:"is_through_proxy" bson:"is_through_proxy" default:"false"`
+	CaptureBanner        bool               `json:"capture_banner" bson:"capture_banner" default:"false"`
+	BannerProtocol       string             `json:"banner_protocol,omitempty" bson:"banner_protocol,omitempty" binding:"omitempty,oneof=ssh smtp ftp redis http"`
+	BannerMaxBytes       int                `json:"banner_max_bytes,omitempty" bson:"banner_max_bytes,omitempty" binding:"omitempty,min=1,max=1024"`
+	CredentialId         string             `json:"credential_id,omitempty" bson:"credential_id,omitempty"`
+	TLSServerName        string             `json:"tls_server_name,omitempty" bson:"tls_server_name,omitempty"`
 	CR                   []int              `json:"cr,omitempty" bson:"cr,omitempty"`
 	IsActive             bool               `json:"is_active" bson:"is_active" default:"true"`
 	Description          string             `json:"description,omitempty" bson:"description,omitempty"`
 	Owner                string             `json:"owner,omitempty" bson:"owner,omitempty"`
+	CoOwners             []string           `json:"co_owners,omitempty" bson:"co_owners,omitempty" binding:"omitempty,dive,email"`
 	CreateAt             time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	UpdatedAt            time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 }
 
 type UpdateRule struct {
+	Type                 string    `json:"type,omitempty" bson:"type,omitempty" binding:"omitempty,oneof=standard mesh"`
 	Status               int       `json:"status,omitempty" bson:"status,omitempty"`
 	Roles                []string  `json:"roles,omitempty" bson:"roles,omitempty"`
+	DestinationRoles     []string  `json:"destination_roles,omitempty" bson:"destination_roles,omitempty"`
 	Projects             []string  `json:"projects,omitempty" bson:"projects,omitempty"`
 	DestinationAddresses []string  `json:"destination_addresses,omitempty" bson:"destination_addresses,omitempty"`
 	DestinationPorts     []string  `json:"destination_ports,omitempty" bson:"destination_ports,omitempty"`
 	DestinationServices  []string  `json:"destination_services,omitempty" bson:"destination_services,omitempty"`
+	ServiceTargetMode    string    `json:"service_target_mode,omitempty" bson:"service_target_mode,omitempty" binding:"omitempty,oneof=cluster_ip endpoints both"`
+	DNSServers           []string  `json:"dns_servers,omitempty" bson:"dns_servers,omitempty"`
 	IsThroughProxy       bool      `json:"is_through_proxy,omitempty" bson:"is_through_proxy" default:"false"`
+	CaptureBanner        bool      `json:"capture_banner,omitempty" bson:"capture_banner" default:"false"`
+	BannerProtocol       string    `json:"banner_protocol,omitempty" bson:"banner_protocol,omitempty" binding:"omitempty,oneof=ssh smtp ftp redis http"`
+	BannerMaxBytes       int       `json:"banner_max_bytes,omitempty" bson:"banner_max_bytes,omitempty" binding:"omitempty,min=1,max=1024"`
+	CredentialId         string    `json:"credential_id,omitempty" bson:"credential_id,omitempty"`
+	TLSServerName        string    `json:"tls_server_name,omitempty" bson:"tls_server_name,omitempty"`
 	CR                   []int     `json:"cr,omitempty" bson:"cr,omitempty"`
 	IsActive             bool      `json:"is_active,omitempty" bson:"is_active" default:"true"`
 	Description          string    `json:"description,omitempty" bson:"description,omitempty"`
+	CoOwners             []string  `json:"co_owners,omitempty" bson:"co_owners,omitempty" binding:"omitempty,dive,email"`
 	UpdatedAt            time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 }
 
@@ -56,9 +76,29 @@ type RuleSearchParams struct {
 	DestinationAddressKeyword string `json:"destination_address_keyword"`
 	CRKeyword                 string `json:"cr_keyword"`
 	ProjectKeyword            string `json:"project_keyword"`
+
+	// Scope limits the result to the rules the user may read, nil leaves the result unrestricted
+	Scope *PolicyScope `json:"-"`
 }
 
 type Port struct {
 	Number   string
 	Protocol string
 }
+
+// ServiceRef is a destination service written as namespace/name[:port]
+type ServiceRef struct {
+	Namespace string
+	Name      string
+	Port      string
+}
+
+// ServiceTarget is one address a destination service was resolved to
+type ServiceTarget struct {
+	Address   string
+	Port      string
+	Protocol  string
+	Kind      string
+	TargetRef string
+	NodeName  string
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// SessionMetadata describes the client a session was opened from
+type SessionMetadata struct {
+	IPAddress  string `json:"ip_address"`
+	UserAgent  string `json:"user_agent"`
+	AuthMethod string `json:"auth_method"`
+}
+
+type Session struct {
+	Id            string    `json:"id"`
+	UserId        string    `json:"user_id"`
+	IPAddress     string    `json:"ip_address"`
+	UserAgent     string    `json:"user_agent"`
+	AuthMethod    string    `json:"auth_method"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastRefreshAt time.Time `json:"last_refresh_at"`
+	Current       bool      `json:"current"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SigningKey is an RSA key for access or refresh tokens. The newest published key signs,
+// older keys keep verifying until ExpiresAt so a rotation doesn't end the sessions.
+type SigningKey struct {
+	Id         primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Kid        string             `json:"kid" bson:"kid"`
+	Use        string             `json:"use" bson:"use"`
+	PrivateKey string             `json:"-" bson:"private_key"` // encrypted PEM
+	PublicKey  string             `json:"public_key" bson:"public_key"`
+	Legacy     bool               `json:"legacy,omitempty" bson:"legacy,omitempty"` // imported from env
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt  time.Time          `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+}
+
+// JSONWebKey is the public part of a signing key as published in the JWKS
+type JSONWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
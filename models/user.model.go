@@ -10,14 +10,19 @@ import (
 type SignUpInput struct {
 	Name       string    `json:"name" bson:"name" binding:"required"`
 	Username   string    `json:"username" bson:"username" binding:"required"`
-	Password   string    `json:"password" bson:"password" binding:"required,min=8"`
-	Email      string    `json:"email" bson:"email"`
+	Password   string    `json:"password" bson:"password" binding:"required"`
+	Email      string    `json:"email" bson:"email" binding:"required,email"`
 	Role       string    `json:"role" bson:"role"`
 	Verified   bool      `json:"verified" bson:"verified"`
 	IsActive   bool      `json:"is_active" bson:"is_active" default:"true"`
 	AuthMethod string    `json:"auth_method" bson:"auth_method"`
 	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at" bson:"updated_at"`
+
+	IsServiceAccount bool     `json:"is_service_account,omitempty" bson:"is_service_account,omitempty"`
+	Projects         []string `json:"projects,omitempty" bson:"projects,omitempty"`
+	PendingApproval  bool     `json:"pending_approval,omitempty" bson:"pending_approval,omitempty"`
+	PasswordHistory  []string `json:"-" bson:"password_history,omitempty"`
 }
 
 // SignInInput struct
@@ -39,13 +44,122 @@ type UserDBResponse struct {
 	AuthMethod string             `json:"auth_method" bson:"auth_method"`
 	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+
+	PasswordChangedAt time.Time `json:"password_changed_at,omitempty" bson:"password_changed_at,omitempty"`
+	IsServiceAccount  bool      `json:"is_service_account,omitempty" bson:"is_service_account,omitempty"`
+	Projects          []string  `json:"projects,omitempty" bson:"projects,omitempty"`
+	PendingApproval   bool      `json:"pending_approval,omitempty" bson:"pending_approval,omitempty"`
+	PasswordHistory   []string  `json:"-" bson:"password_history,omitempty"`
+	RoleOverride      bool      `json:"role_override,omitempty" bson:"role_override,omitempty"`
+
+	MFAEnabled       bool     `json:"mfa_enabled" bson:"mfa_enabled,omitempty"`
+	MFASecret        string   `json:"-" bson:"mfa_secret,omitempty"`
+	MFAPendingSecret string   `json:"-" bson:"mfa_pending_secret,omitempty"`
+	MFARecoveryCodes []string `json:"-" bson:"mfa_recovery_codes,omitempty"`
+	MFALastCounter   int64    `json:"-" bson:"mfa_last_counter,omitempty"`
+}
+
+// CachedUser is what the user cache keeps of a user, the password hashes and the MFA secrets stay in Mongo
+type CachedUser struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	Name              string             `bson:"name"`
+	Username          string             `bson:"username"`
+	Email             string             `bson:"email"`
+	Role              string             `bson:"role"`
+	Verified          bool               `bson:"verified"`
+	IsActive          bool               `bson:"is_active"`
+	AuthMethod        string             `bson:"auth_method"`
+	CreatedAt         time.Time          `bson:"created_at"`
+	UpdatedAt         time.Time          `bson:"updated_at"`
+	PasswordChangedAt time.Time          `bson:"password_changed_at,omitempty"`
+	IsServiceAccount  bool               `bson:"is_service_account,omitempty"`
+	PendingApproval   bool               `bson:"pending_approval,omitempty"`
+	RoleOverride      bool               `bson:"role_override,omitempty"`
+	MFAEnabled        bool               `bson:"mfa_enabled,omitempty"`
+}
+
+func NewCachedUser(user *UserDBResponse) *CachedUser {
+	return &CachedUser{
+		ID:                user.ID,
+		Name:              user.Name,
+		Username:          user.Username,
+		Email:             user.Email,
+		Role:              user.Role,
+		Verified:          user.Verified,
+		IsActive:          user.IsActive,
+		AuthMethod:        user.AuthMethod,
+		CreatedAt:         user.CreatedAt,
+		UpdatedAt:         user.UpdatedAt,
+		PasswordChangedAt: user.PasswordChangedAt,
+		IsServiceAccount:  user.IsServiceAccount,
+		PendingApproval:   user.PendingApproval,
+		RoleOverride:      user.RoleOverride,
+		MFAEnabled:        user.MFAEnabled,
+	}
+}
+
+// User Get the cached user back as a UserDBResponse, without any of the secrets
+func (c *CachedUser) User() *UserDBResponse {
+	return &UserDBResponse{
+		ID:                c.ID,
+		Name:              c.Name,
+		Username:          c.Username,
+		Email:             c.Email,
+		Role:              c.Role,
+		Verified:          c.Verified,
+		IsActive:          c.IsActive,
+		AuthMethod:        c.AuthMethod,
+		CreatedAt:         c.CreatedAt,
+		UpdatedAt:         c.UpdatedAt,
+		PasswordChangedAt: c.PasswordChangedAt,
+		IsServiceAccount:  c.IsServiceAccount,
+		PendingApproval:   c.PendingApproval,
+		RoleOverride:      c.RoleOverride,
+		MFAEnabled:        c.MFAEnabled,
+	}
+}
+
+// CreateUserInput is used by admins, the account is created verified and active
+type CreateUserInput struct {
+	Name     string `json:"name" binding:"required"`
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+	Role     string `json:"role" binding:"omitempty,oneof=admin user"`
 }
 
+type SetPasswordInput struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// ChangePasswordInput the current password is asked again, a stolen session is not enough to take over the account
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	Password        string `json:"password" binding:"required"`
+	PasswordConfirm string `json:"password_confirm" binding:"required,eqfield=Password"`
+}
+
+type ResendVerificationInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ForgotPasswordInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordInput struct {
+	Password        string `json:"password" binding:"required"`
+	PasswordConfirm string `json:"password_confirm" binding:"required,eqfield=Password"`
+}
+
+// UserUpdate only the fields present in the request are changed. Setting the role keeps the group
+// mappings of SSO logins from changing it again, until RoleOverride is set back to false.
 type UserUpdate struct {
-	Role      string    `json:"role,omitempty" bson:"role,omitempty"`
-	IsActive  bool      `json:"is_active,omitempty" bson:"is_active,omitempty"`
-	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
-	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	Role         string    `json:"role,omitempty" bson:"role,omitempty" binding:"omitempty,oneof=admin user"`
+	IsActive     *bool     `json:"is_active,omitempty" bson:"is_active,omitempty"`
+	RoleOverride *bool     `json:"role_override,omitempty" bson:"role_override,omitempty"`
+	CreatedAt    time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 }
 
 // UserResponse struct
@@ -60,9 +174,16 @@ type UserResponse struct {
 	AuthMethod string             `json:"auth_method,omitempty" bson:"auth_method,omitempty"`
 	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+
+	IsServiceAccount bool     `json:"is_service_account,omitempty" bson:"is_service_account,omitempty"`
+	Projects         []string `json:"projects,omitempty" bson:"projects,omitempty"`
+	MFAEnabled       bool     `json:"mfa_enabled" bson:"mfa_enabled,omitempty"`
+	PendingApproval  bool     `json:"pending_approval,omitempty" bson:"pending_approval,omitempty"`
+	RoleOverride     bool     `json:"role_override,omitempty" bson:"role_override,omitempty"`
 }
 
 type UserClaims struct {
+	Subject           string   `json:"sub"`
 	Email             string   `json:"email"`
 	EmailVerified     bool     `json:"email_verified"`
 	Name              string   `json:"name"`
@@ -70,6 +191,9 @@ type UserClaims struct {
 	PreferredUsername string   `json:"preferred_username"`
 	Nickname          string   `json:"nickname"`
 	Groups            []string `json:"groups"`
+
+	// Raw holds every claim of the user, for group mappings on arbitrary claims
+	Raw map[string]interface{} `json:"-"`
 }
 
 type UserListResponse struct {
@@ -83,6 +207,7 @@ type UserSearchParams struct {
 	NameKeyword     string `json:"name_keyword"`
 	UsernameKeyword string `json:"username_keyword"`
 	EmailKeyword    string `json:"email_keyword"`
+	PendingApproval *bool  `json:"pending_approval"`
 }
 
 func FilteredResponse(user *UserDBResponse) UserResponse {
@@ -97,5 +222,11 @@ func FilteredResponse(user *UserDBResponse) UserResponse {
 		AuthMethod: user.AuthMethod,
 		CreatedAt:  user.CreatedAt,
 		UpdatedAt:  user.UpdatedAt,
+
+		IsServiceAccount: user.IsServiceAccount,
+		Projects:         user.Projects,
+		MFAEnabled:       user.MFAEnabled,
+		PendingApproval:  user.PendingApproval,
+		RoleOverride:     user.RoleOverride,
 	}
 }
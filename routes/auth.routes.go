@@ -3,7 +3,6 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/thuongnn/clst-mgt-api/controllers"
-	"github.com/thuongnn/clst-mgt-api/services"
 )
 
 type AuthRouteController struct {
@@ -14,15 +13,30 @@ func NewAuthRouteController(authController controllers.AuthController) AuthRoute
 	return AuthRouteController{authController}
 }
 
-func (rc *AuthRouteController) AuthRoute(rg *gin.RouterGroup, userService services.UserService) {
+func (rc *AuthRouteController) AuthRoute(rg *gin.RouterGroup, deserializeUser gin.HandlerFunc) {
 	router := rg.Group("/auth")
 
 	router.GET("/", rc.authController.GetLoginOptions)
 	router.POST("/register", rc.authController.SignUpUser)
 	router.POST("/login", rc.authController.SignInUser)
+	router.POST("/mfa/enroll", rc.authController.EnrollMFA)
+	router.POST("/mfa/verify", rc.authController.VerifyMFA)
 	router.GET("/refresh", rc.authController.RefreshAccessToken)
-	router.GET("/logout", rc.authController.LogoutUser)
+	router.GET("/logout", deserializeUser, rc.authController.LogoutUser)
+	router.GET("/verifyemail/:verificationCode", rc.authController.VerifyEmail)
+	router.POST("/verifyemail/resend", rc.authController.ResendVerificationEmail)
+	router.POST("/forgotpassword", rc.authController.ForgotPassword)
+	router.PATCH("/resetpassword/:resetToken", rc.authController.ResetPassword)
 
 	// routes for oauth2
+	router.GET("/oauth2/:authMethodId/login", rc.authController.OAuth2Login)
 	router.GET("/oauth2/callback", rc.authController.Oauth2Callback)
+
+	// routes for ldap
+	router.POST("/ldap/:authMethodId/login", rc.authController.LDAPLogin)
+
+	// routes for saml
+	router.GET("/saml/:authMethodId/metadata", rc.authController.SAMLMetadata)
+	router.GET("/saml/:authMethodId/login", rc.authController.SAMLLogin)
+	router.POST("/saml/:authMethodId/acs", rc.authController.SAMLAssertionConsumer)
 }
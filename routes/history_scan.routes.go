@@ -3,8 +3,6 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/thuongnn/clst-mgt-api/controllers"
-	"github.com/thuongnn/clst-mgt-api/middleware"
-	"github.com/thuongnn/clst-mgt-api/services"
 )
 
 type HistoryScanRouteController struct {
@@ -15,9 +13,11 @@ func NewHistoryScanControllerRoute(historyScanController controllers.HistoryScan
 	return HistoryScanRouteController{historyScanController}
 }
 
-func (r *HistoryScanRouteController) HistoryScanRoute(rg *gin.RouterGroup, userService services.UserService) {
+func (r *HistoryScanRouteController) HistoryScanRoute(rg *gin.RouterGroup, deserializeUser gin.HandlerFunc) {
 	router := rg.Group("/history-scan")
-	router.Use(middleware.DeserializeUser(userService))
+	router.Use(deserializeUser)
 
+	router.GET("/dns-report", r.historyScanController.GetDNSConsistencyReport)
 	router.GET("/:ruleId", r.historyScanController.GetHistoryScanByRuleId)
+	router.GET("/:ruleId/matrix", r.historyScanController.GetMeshMatrixByRuleId)
 }
@@ -4,7 +4,6 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/thuongnn/clst-mgt-api/controllers"
 	"github.com/thuongnn/clst-mgt-api/middleware"
-	"github.com/thuongnn/clst-mgt-api/services"
 )
 
 type NodeRouteController struct {
@@ -15,9 +14,9 @@ func NewNodeControllerRoute(nodeController controllers.NodeController) NodeRoute
 	return NodeRouteController{nodeController}
 }
 
-func (r *NodeRouteController) NodeRoute(rg *gin.RouterGroup, userService services.UserService) {
+func (r *NodeRouteController) NodeRoute(rg *gin.RouterGroup, deserializeUser gin.HandlerFunc) {
 	router := rg.Group("/nodes")
-	router.Use(middleware.DeserializeUser(userService))
+	router.Use(deserializeUser)
 
 	router.GET("/", r.nodeController.GetNodes)
 	router.GET("/sync", middleware.AdminOnly(), r.nodeController.SyncNodes)
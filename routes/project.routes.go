@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thuongnn/clst-mgt-api/controllers"
+	"github.com/thuongnn/clst-mgt-api/middleware"
+	"github.com/thuongnn/clst-mgt-api/services"
+	"github.com/thuongnn/clst-mgt-api/utils"
+)
+
+type ProjectRouteController struct {
+	projectController controllers.ProjectController
+	policyService     services.PolicyService
+}
+
+func NewProjectControllerRoute(projectController controllers.ProjectController, policyService services.PolicyService) ProjectRouteController {
+	return ProjectRouteController{projectController, policyService}
+}
+
+func (p *ProjectRouteController) ProjectRoute(rg *gin.RouterGroup, deserializeUser gin.HandlerFunc) {
+	router := rg.Group("/projects")
+	router.Use(deserializeUser)
+
+	router.GET("/", p.projectController.GetProjects)
+	router.POST("/", middleware.RequirePermission(p.policyService, utils.ActionCreate, utils.ResourceProject), p.projectController.CreateProject)
+	router.GET("/:projectId", p.projectController.GetProject)
+	router.PATCH("/:projectId", p.projectController.UpdateProject)
+	router.DELETE("/:projectId", p.projectController.DeleteProject)
+	router.PUT("/:projectId/members", p.projectController.SetProjectMember)
+	router.DELETE("/:projectId/members/:userId", p.projectController.RemoveProjectMember)
+}
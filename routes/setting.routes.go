@@ -4,20 +4,21 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/thuongnn/clst-mgt-api/controllers"
 	"github.com/thuongnn/clst-mgt-api/middleware"
-	"github.com/thuongnn/clst-mgt-api/services"
 )
 
 type SettingRouteController struct {
 	authMethodController controllers.AuthMethodController
+	credentialController controllers.CredentialController
+	secretController     controllers.SecretController
 }
 
-func NewSettingControllerRoute(authMethodController controllers.AuthMethodController) SettingRouteController {
-	return SettingRouteController{authMethodController}
+func NewSettingControllerRoute(authMethodController controllers.AuthMethodController, credentialController controllers.CredentialController, secretController controllers.SecretController) SettingRouteController {
+	return SettingRouteController{authMethodController, credentialController, secretController}
 }
 
-func (s *SettingRouteController) SettingRoute(rg *gin.RouterGroup, userService services.UserService) {
+func (s *SettingRouteController) SettingRoute(rg *gin.RouterGroup, deserializeUser gin.HandlerFunc) {
 	router := rg.Group("/settings")
-	router.Use(middleware.DeserializeUser(userService))
+	router.Use(deserializeUser)
 	router.Use(middleware.AdminOnly())
 
 	router.GET("/auth/", s.authMethodController.GetAuthMethods)
@@ -25,4 +26,13 @@ func (s *SettingRouteController) SettingRoute(rg *gin.RouterGroup, userService s
 	router.POST("/auth/", s.authMethodController.CreateAuthMethod)
 	router.PATCH("/auth/:authMethodId", s.authMethodController.UpdateAuthMethod)
 	router.DELETE("/auth/:authMethodId", s.authMethodController.DeleteAuthMethod)
+
+	router.GET("/credentials/", s.credentialController.GetCredentials)
+	router.GET("/credentials/:credentialId", s.credentialController.GetCredentialById)
+	router.POST("/credentials/", s.credentialController.CreateCredential)
+	router.PATCH("/credentials/:credentialId", s.credentialController.UpdateCredential)
+	router.DELETE("/credentials/:credentialId", s.credentialController.DeleteCredential)
+
+	router.POST("/secrets/rotate", s.secretController.RotateSecrets)
+	router.POST("/signing-keys/rotate", s.secretController.RotateSigningKeys)
 }
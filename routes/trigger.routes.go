@@ -5,21 +5,23 @@ import (
 	"github.com/thuongnn/clst-mgt-api/controllers"
 	"github.com/thuongnn/clst-mgt-api/middleware"
 	"github.com/thuongnn/clst-mgt-api/services"
+	"github.com/thuongnn/clst-mgt-api/utils"
 )
 
 type TriggerRouteController struct {
 	triggerController controllers.TriggerController
+	policyService     services.PolicyService
 }
 
-func NewTriggerControllerRoute(triggerController controllers.TriggerController) TriggerRouteController {
-	return TriggerRouteController{triggerController}
+func NewTriggerControllerRoute(triggerController controllers.TriggerController, policyService services.PolicyService) TriggerRouteController {
+	return TriggerRouteController{triggerController, policyService}
 }
 
-func (t *TriggerRouteController) TriggerRoute(rg *gin.RouterGroup, userService services.UserService) {
+func (t *TriggerRouteController) TriggerRoute(rg *gin.RouterGroup, deserializeUser gin.HandlerFunc) {
 	router := rg.Group("/triggers")
-	router.Use(middleware.DeserializeUser(userService))
+	router.Use(deserializeUser)
 
-	router.POST("/all", middleware.AdminOnly(), t.triggerController.TriggerAll)
+	router.POST("/all", middleware.RequirePermission(t.policyService, utils.ActionTrigger, utils.ResourceRule), t.triggerController.TriggerAll)
 	router.POST("/", t.triggerController.TriggerByRuleIds)
 
 }
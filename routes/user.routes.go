@@ -4,7 +4,6 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/thuongnn/clst-mgt-api/controllers"
 	"github.com/thuongnn/clst-mgt-api/middleware"
-	"github.com/thuongnn/clst-mgt-api/services"
 )
 
 type UserRouteController struct {
@@ -15,10 +14,34 @@ func NewRouteUserController(userController controllers.UserController) UserRoute
 	return UserRouteController{userController}
 }
 
-func (uc *UserRouteController) UserRoute(rg *gin.RouterGroup, userService services.UserService) {
+func (uc *UserRouteController) UserRoute(rg *gin.RouterGroup, deserializeUser gin.HandlerFunc) {
 	router := rg.Group("users")
-	router.Use(middleware.DeserializeUser(userService))
+	router.Use(deserializeUser)
 	router.GET("/me", uc.userController.GetMe)
+	router.PATCH("/me/password", uc.userController.ChangeMyPassword)
+	router.GET("/me/sessions", uc.userController.GetMySessions)
+	router.DELETE("/me/sessions/:sessionId", uc.userController.DeleteMySession)
+	router.GET("/me/tokens", uc.userController.GetMyAPITokens)
+	router.POST("/me/tokens", uc.userController.CreateMyAPIToken)
+	router.DELETE("/me/tokens/:tokenId", uc.userController.DeleteMyAPIToken)
+	router.POST("/me/mfa/setup", uc.userController.SetupMyMFA)
+	router.POST("/me/mfa/enable", uc.userController.EnableMyMFA)
+	router.POST("/me/mfa/recovery-codes", uc.userController.RegenerateMyRecoveryCodes)
+	router.POST("/me/mfa/disable", uc.userController.DisableMyMFA)
+	router.POST("/service-accounts", middleware.AdminOnly(), uc.userController.CreateServiceAccount)
 	router.GET("/", middleware.AdminOnly(), uc.userController.FindUsers)
+	router.POST("/", middleware.AdminOnly(), uc.userController.CreateUser)
 	router.PATCH("/:userId", middleware.AdminOnly(), uc.userController.UpdateUser)
+	router.DELETE("/:userId", middleware.AdminOnly(), uc.userController.DeleteUser)
+	router.POST("/:userId/password", middleware.AdminOnly(), uc.userController.SetUserPassword)
+	router.POST("/:userId/logout", middleware.AdminOnly(), uc.userController.LogoutUser)
+	router.GET("/:userId/sessions", middleware.AdminOnly(), uc.userController.GetUserSessions)
+	router.DELETE("/:userId/sessions/:sessionId", middleware.AdminOnly(), uc.userController.DeleteUserSession)
+	router.GET("/:userId/tokens", middleware.AdminOnly(), uc.userController.GetUserAPITokens)
+	router.POST("/:userId/tokens", middleware.AdminOnly(), uc.userController.CreateUserAPIToken)
+	router.DELETE("/:userId/tokens/:tokenId", middleware.AdminOnly(), uc.userController.DeleteUserAPIToken)
+	router.DELETE("/:userId/mfa", middleware.AdminOnly(), uc.userController.ResetUserMFA)
+	router.POST("/:userId/unlock", middleware.AdminOnly(), uc.userController.UnlockUser)
+	router.POST("/:userId/approve", middleware.AdminOnly(), uc.userController.ApproveUser)
+	router.GET("/:userId/login-attempts", middleware.AdminOnly(), uc.userController.GetUserLoginAttempts)
 }
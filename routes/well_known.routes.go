@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thuongnn/clst-mgt-api/controllers"
+)
+
+type WellKnownRouteController struct {
+	wellKnownController controllers.WellKnownController
+}
+
+func NewWellKnownControllerRoute(wellKnownController controllers.WellKnownController) WellKnownRouteController {
+	return WellKnownRouteController{wellKnownController}
+}
+
+func (w *WellKnownRouteController) WellKnownRoute(rg *gin.RouterGroup) {
+	router := rg.Group("/.well-known")
+
+	router.GET("/jwks.json", w.wellKnownController.GetJWKS)
+}
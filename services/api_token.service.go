@@ -0,0 +1,11 @@
+package services
+
+import "github.com/thuongnn/clst-mgt-api/models"
+
+type APITokenService interface {
+	GetAPITokensByUserId(userId string) ([]*models.APIToken, error)
+	CreateAPIToken(userId string, input *models.CreateAPITokenInput, createdBy string) (string, *models.APIToken, error)
+	ValidateAPIToken(token string) (*models.APIToken, error)
+	DeleteAPIToken(userId string, tokenId string) error
+	DeleteAPITokensByUserId(userId string) error
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+type APITokenServiceImpl struct {
+	apiTokenCollection *mongo.Collection
+	ctx                context.Context
+}
+
+func (a APITokenServiceImpl) GetAPITokensByUserId(userId string) ([]*models.APIToken, error) {
+	obId, _ := primitive.ObjectIDFromHex(userId)
+
+	opt := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := a.apiTokenCollection.Find(a.ctx, bson.M{"user_id": obId}, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(a.ctx)
+
+	tokens := []*models.APIToken{}
+	for cursor.Next(a.ctx) {
+		token := &models.APIToken{}
+		if errDecode := cursor.Decode(token); errDecode != nil {
+			return nil, errDecode
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (a APITokenServiceImpl) CreateAPIToken(userId string, input *models.CreateAPITokenInput, createdBy string) (string, *models.APIToken, error) {
+	obId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	plainToken := utils.APITokenPrefix + secret
+
+	token := &models.APIToken{
+		UserId:    obId,
+		Name:      input.Name,
+		TokenHash: utils.HashToken(plainToken),
+		Prefix:    plainToken[:len(utils.APITokenPrefix)+6],
+		Scopes:    input.Scopes,
+		Project:   strings.TrimSpace(input.Project),
+		CreatedBy: createdBy,
+		CreateAt:  time.Now(),
+	}
+
+	if input.ExpiresInDays > 0 {
+		expiresAt := token.CreateAt.AddDate(0, 0, input.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	res, err := a.apiTokenCollection.InsertOne(a.ctx, token)
+	if err != nil {
+		return "", nil, err
+	}
+
+	opt := options.Index()
+	opt.SetUnique(true)
+	index := mongo.IndexModel{Keys: bson.M{"token_hash": 1}, Options: opt}
+
+	if _, err := a.apiTokenCollection.Indexes().CreateOne(a.ctx, index); err != nil {
+		return "", nil, errors.New("could not create index for token_hash")
+	}
+
+	token.Id = res.InsertedID.(primitive.ObjectID)
+
+	return plainToken, token, nil
+}
+
+func (a APITokenServiceImpl) ValidateAPIToken(plainToken string) (*models.APIToken, error) {
+	var token *models.APIToken
+
+	now := time.Now()
+	query := bson.M{"token_hash": utils.HashToken(plainToken)}
+	update := bson.M{"$set": bson.M{"last_used_at": now}}
+
+	err := a.apiTokenCollection.FindOneAndUpdate(a.ctx, query, update).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("invalid API token")
+		}
+		return nil, err
+	}
+
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(now) {
+		return nil, errors.New("API token has expired")
+	}
+
+	return token, nil
+}
+
+func (a APITokenServiceImpl) DeleteAPIToken(userId string, tokenId string) error {
+	userObId, _ := primitive.ObjectIDFromHex(userId)
+	tokenObId, _ := primitive.ObjectIDFromHex(tokenId)
+
+	res, err := a.apiTokenCollection.DeleteOne(a.ctx, bson.M{"_id": tokenObId, "user_id": userObId})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return errors.New("no document with that Id exists")
+	}
+
+	return nil
+}
+
+func (a APITokenServiceImpl) DeleteAPITokensByUserId(userId string) error {
+	obId, _ := primitive.ObjectIDFromHex(userId)
+
+	_, err := a.apiTokenCollection.DeleteMany(a.ctx, bson.M{"user_id": obId})
+	return err
+}
+
+func NewAPITokenService(apiTokenCollection *mongo.Collection, ctx context.Context) APITokenService {
+	return &APITokenServiceImpl{apiTokenCollection, ctx}
+}
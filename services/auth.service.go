@@ -5,5 +5,19 @@ import "github.com/thuongnn/clst-mgt-api/models"
 type AuthService interface {
 	SignUpUser(*models.SignUpInput) (*models.UserDBResponse, error)
 	SignInUser(*models.SignInInput) (*models.UserDBResponse, error)
-	SyncOauth2User(*models.SignUpInput) (*models.UserDBResponse, error)
+	SyncOauth2User(user *models.SignUpInput, claims map[string]interface{}, groupMappings []models.GroupMapping) (*models.UserDBResponse, error)
+	CreateServiceAccount(*models.ServiceAccountInput) (*models.UserDBResponse, error)
+	CreateVerificationCode(userId string) (string, error)
+	VerifyEmail(verificationCode string) error
+	CreatePasswordResetToken(userId string) (string, error)
+	ResetPassword(resetToken string, password string) (*models.UserDBResponse, error)
+	SetupMFA(user *models.UserDBResponse) (*models.MFASetupResponse, error)
+	EnableMFA(user *models.UserDBResponse, code string) ([]string, error)
+	VerifyMFA(user *models.UserDBResponse, code string, recoveryCode string) error
+	RegenerateRecoveryCodes(userId string) ([]string, error)
+	DisableMFA(userId string) error
+	SetPassword(userId string, password string) error
+	ChangePassword(user *models.UserDBResponse, currentPassword string, password string) error
+	RehashPassword(user *models.UserDBResponse, password string) error
+	RotateMFASecrets() (int, error)
 }
@@ -3,23 +3,28 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
+	"github.com/thuongnn/clst-mgt-api/config"
 	"github.com/thuongnn/clst-mgt-api/models"
 	"github.com/thuongnn/clst-mgt-api/utils"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type AuthServiceImpl struct {
-	collection *mongo.Collection
-	ctx        context.Context
+	collection     *mongo.Collection
+	userCache      UserCacheService
+	projectService ProjectService
+	ctx            context.Context
 }
 
-func NewAuthService(collection *mongo.Collection, ctx context.Context) AuthService {
-	return &AuthServiceImpl{collection, ctx}
+func NewAuthService(collection *mongo.Collection, userCache UserCacheService, projectService ProjectService, ctx context.Context) AuthService {
+	return &AuthServiceImpl{collection, userCache, projectService, ctx}
 }
 
 func (uc *AuthServiceImpl) SignUpUser(user *models.SignUpInput) (*models.UserDBResponse, error) {
@@ -27,11 +32,23 @@ func (uc *AuthServiceImpl) SignUpUser(user *models.SignUpInput) (*models.UserDBR
 	user.UpdatedAt = user.CreatedAt
 	user.Username = strings.ToLower(user.Username)
 	user.Email = strings.ToLower(user.Email)
-	user.Verified = false
-	user.Role = "anonymous"
+	if user.Role == "" {
+		user.Role = utils.UserRole
+	}
 
-	hashedPassword, _ := utils.HashPassword(user.Password)
+	if err := utils.ValidatePassword(user.Password, user.Username, user.Email); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := utils.HashPassword(user.Password)
+	if err != nil {
+		return nil, err
+	}
 	user.Password = hashedPassword
+	if utils.PasswordHistorySize() > 0 {
+		user.PasswordHistory = []string{hashedPassword}
+	}
+
 	res, err := uc.collection.InsertOne(uc.ctx, &user)
 
 	if err != nil {
@@ -65,11 +82,17 @@ func (uc *AuthServiceImpl) SignInUser(*models.SignInInput) (*models.UserDBRespon
 	return nil, nil
 }
 
-func (uc *AuthServiceImpl) SyncOauth2User(user *models.SignUpInput) (*models.UserDBResponse, error) {
+func (uc *AuthServiceImpl) SyncOauth2User(user *models.SignUpInput, claims map[string]interface{}, groupMappings []models.GroupMapping) (*models.UserDBResponse, error) {
 	user.UpdatedAt = time.Now()
 	user.Username = strings.ToLower(user.Username)
 	user.Email = strings.ToLower(user.Email)
 
+	// Role and projects are granted by the IdP on every login, so removing a group revokes them.
+	// The projects become memberships of the project documents, which is what the policy reads.
+	var projects []string
+	user.Role, projects = utils.ResolveGroupMappings(claims, groupMappings)
+	user.Projects = nil
+
 	filter := bson.M{"$or": []bson.M{{"email": user.Email}, {"username": user.Username}}}
 
 	var existingUser *models.UserDBResponse
@@ -77,6 +100,7 @@ func (uc *AuthServiceImpl) SyncOauth2User(user *models.SignUpInput) (*models.Use
 
 	if err != nil {
 		// If user does not exist → Create new
+		// PendingApproval of the input only applies here, an approved account stays approved
 		if err == mongo.ErrNoDocuments {
 			user.IsActive = true
 			user.CreatedAt = user.UpdatedAt
@@ -95,28 +119,52 @@ func (uc *AuthServiceImpl) SyncOauth2User(user *models.SignUpInput) (*models.Use
 			if err != nil {
 				return nil, err
 			}
+
+			if err := uc.projectService.SyncManagedMemberships(newUser.ID, projects); err != nil {
+				return nil, err
+			}
 			return newUser, nil
 		}
 		return nil, err
 	}
 
+	if existingUser.IsServiceAccount {
+		return nil, errors.New("service accounts can't log in interactively")
+	}
+
+	// an external directory must not take over an account that belongs to another auth method
+	if existingUser.AuthMethod != user.AuthMethod {
+		return nil, errors.New("user with that username or email already exists")
+	}
+
 	// If user already exists, update information
-	update := bson.M{
-		"$set": bson.M{
-			"name":       user.Name,
-			"username":   user.Username,
-			"email":      user.Email,
-			"role":       user.Role,
-			"verified":   user.Verified,
-			"updated_at": user.UpdatedAt,
-		},
+	set := bson.M{
+		"name":       user.Name,
+		"username":   user.Username,
+		"email":      user.Email,
+		"verified":   user.Verified,
+		"updated_at": user.UpdatedAt,
 	}
 
+	// a role an admin gave the user wins over the group mappings
+	if !existingUser.RoleOverride {
+		set["role"] = user.Role
+	}
+	update := bson.M{"$set": set, "$unset": bson.M{"projects": ""}}
+
 	_, err = uc.collection.UpdateOne(uc.ctx, filter, update)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := uc.userCache.InvalidateUser(existingUser.ID.Hex()); err != nil {
+		return nil, err
+	}
+
+	if err := uc.projectService.SyncManagedMemberships(existingUser.ID, projects); err != nil {
+		return nil, err
+	}
+
 	err = uc.collection.FindOne(uc.ctx, filter).Decode(&existingUser)
 	if err != nil {
 		return nil, err
@@ -124,3 +172,514 @@ func (uc *AuthServiceImpl) SyncOauth2User(user *models.SignUpInput) (*models.Use
 
 	return existingUser, nil
 }
+
+func (uc *AuthServiceImpl) CreateServiceAccount(input *models.ServiceAccountInput) (*models.UserDBResponse, error) {
+	now := time.Now()
+
+	// service accounts have no password, they only authenticate with API tokens
+	serviceAccount := &models.SignUpInput{
+		Name:             input.Name,
+		Username:         strings.ToLower(input.Username),
+		Email:            strings.ToLower(input.Email),
+		Role:             utils.UserRole,
+		Verified:         true,
+		IsActive:         true,
+		AuthMethod:       utils.ServiceAccountAuth,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		IsServiceAccount: true,
+	}
+
+	res, err := uc.collection.InsertOne(uc.ctx, serviceAccount)
+	if err != nil {
+		if er, ok := err.(mongo.WriteException); ok && er.WriteErrors[0].Code == 11000 {
+			return nil, errors.New("user with that username already exist")
+		}
+		return nil, err
+	}
+
+	var newUser *models.UserDBResponse
+	if err := uc.collection.FindOne(uc.ctx, bson.M{"_id": res.InsertedID}).Decode(&newUser); err != nil {
+		return nil, err
+	}
+
+	return newUser, nil
+}
+
+func (uc *AuthServiceImpl) CreateVerificationCode(userId string) (string, error) {
+	code, err := utils.GenerateRandomToken(20)
+	if err != nil {
+		return "", err
+	}
+
+	appConfig, _ := config.LoadConfig(".")
+	expiresIn := appConfig.VerificationCodeExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = utils.DefaultVerificationCodeTTL
+	}
+
+	// Only the hash is stored, the plain code only travels in the email
+	obId, _ := primitive.ObjectIDFromHex(userId)
+	query := bson.D{{Key: "_id", Value: obId}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "verification_code", Value: utils.HashToken(code)},
+		{Key: "verification_code_expires_at", Value: time.Now().Add(expiresIn)},
+	}}}
+
+	res, err := uc.collection.UpdateOne(uc.ctx, query, update)
+	if err != nil {
+		return "", err
+	}
+
+	if res.MatchedCount == 0 {
+		return "", errors.New("no document with that Id exists")
+	}
+
+	return code, nil
+}
+
+func (uc *AuthServiceImpl) VerifyEmail(verificationCode string) error {
+	query := bson.D{
+		{Key: "verification_code", Value: utils.HashToken(verificationCode)},
+		{Key: "verification_code_expires_at", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "verified", Value: true}, {Key: "updated_at", Value: time.Now()}}},
+		{Key: "$unset", Value: bson.D{{Key: "verification_code", Value: ""}, {Key: "verification_code_expires_at", Value: ""}}},
+	}
+
+	var user *models.UserDBResponse
+	if err := uc.collection.FindOneAndUpdate(uc.ctx, query, update).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errors.New("invalid or expired verification code")
+		}
+		return err
+	}
+
+	return uc.userCache.InvalidateUser(user.ID.Hex())
+}
+
+func (uc *AuthServiceImpl) CreatePasswordResetToken(userId string) (string, error) {
+	resetToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	obId, _ := primitive.ObjectIDFromHex(userId)
+	query := bson.D{{Key: "_id", Value: obId}, {Key: "auth_method", Value: utils.BasicAuth}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "password_reset_token", Value: utils.HashToken(resetToken)},
+		{Key: "password_reset_at", Value: time.Now().Add(utils.PasswordResetTokenTTL)},
+	}}}
+
+	res, err := uc.collection.UpdateOne(uc.ctx, query, update)
+	if err != nil {
+		return "", err
+	}
+
+	if res.MatchedCount == 0 {
+		return "", errors.New("no document with that Id exists")
+	}
+
+	return resetToken, nil
+}
+
+func (uc *AuthServiceImpl) ResetPassword(resetToken string, password string) (*models.UserDBResponse, error) {
+	query := bson.D{
+		{Key: "password_reset_token", Value: utils.HashToken(resetToken)},
+		{Key: "password_reset_at", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	}
+
+	var user *models.UserDBResponse
+	if err := uc.collection.FindOne(uc.ctx, query).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("invalid or expired reset token")
+		}
+		return nil, err
+	}
+
+	hashedPassword, err := newPasswordHash(user, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := uc.collection.FindOneAndUpdate(uc.ctx, query, passwordUpdate(hashedPassword, time.Now())).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("invalid or expired reset token")
+		}
+		return nil, err
+	}
+
+	if err := uc.userCache.InvalidateUser(user.ID.Hex()); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (uc *AuthServiceImpl) SetupMFA(user *models.UserDBResponse) (*models.MFASetupResponse, error) {
+	if user.AuthMethod != utils.BasicAuth {
+		return nil, errors.New("invalid request: MFA is only available for basic auth accounts")
+	}
+
+	if user.MFAEnabled {
+		return nil, errors.New("invalid request: MFA is already enabled")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedSecret, err := utils.EncryptSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// the secret only becomes active once the user proves the authenticator app has it
+	query := bson.D{{Key: "_id", Value: user.ID}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "mfa_pending_secret", Value: encryptedSecret}}}}
+	if _, err := uc.collection.UpdateOne(uc.ctx, query, update); err != nil {
+		return nil, err
+	}
+
+	if err := uc.userCache.InvalidateUser(user.ID.Hex()); err != nil {
+		return nil, err
+	}
+
+	return &models.MFASetupResponse{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(utils.MFAIssuer, user.Username, secret),
+	}, nil
+}
+
+func (uc *AuthServiceImpl) EnableMFA(user *models.UserDBResponse, code string) ([]string, error) {
+	if user.MFAEnabled {
+		return nil, errors.New("invalid request: MFA is already enabled")
+	}
+
+	var pendingUser *models.UserDBResponse
+	if err := uc.collection.FindOne(uc.ctx, bson.M{"_id": user.ID}).Decode(&pendingUser); err != nil {
+		return nil, err
+	}
+
+	if pendingUser.MFAPendingSecret == "" {
+		return nil, errors.New("invalid request: MFA setup was not started")
+	}
+
+	secret, err := utils.DecryptSecret(pendingUser.MFAPendingSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	counter, ok := utils.ValidateTOTP(secret, code, 0, time.Now())
+	if !ok {
+		return nil, errors.New("invalid MFA code")
+	}
+
+	recoveryCodes, hashedCodes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	query := bson.D{{Key: "_id", Value: user.ID}, {Key: "mfa_pending_secret", Value: pendingUser.MFAPendingSecret}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "mfa_enabled", Value: true},
+			{Key: "mfa_secret", Value: pendingUser.MFAPendingSecret},
+			{Key: "mfa_recovery_codes", Value: hashedCodes},
+			{Key: "mfa_last_counter", Value: counter},
+			{Key: "updated_at", Value: time.Now()},
+		}},
+		{Key: "$unset", Value: bson.D{{Key: "mfa_pending_secret", Value: ""}}},
+	}
+
+	res, err := uc.collection.UpdateOne(uc.ctx, query, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.MatchedCount == 0 {
+		return nil, errors.New("invalid request: MFA setup was restarted")
+	}
+
+	if err := uc.userCache.InvalidateUser(user.ID.Hex()); err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+func (uc *AuthServiceImpl) VerifyMFA(user *models.UserDBResponse, code string, recoveryCode string) error {
+	if !user.MFAEnabled {
+		return errors.New("invalid request: MFA is not enabled")
+	}
+
+	if code != "" {
+		secret, err := utils.DecryptSecret(user.MFASecret)
+		if err != nil {
+			return err
+		}
+
+		counter, ok := utils.ValidateTOTP(secret, code, user.MFALastCounter, time.Now())
+		if !ok {
+			return errors.New("invalid MFA code")
+		}
+
+		// a code is accepted once, the counter only moves forward even with concurrent logins
+		query := bson.D{{Key: "_id", Value: user.ID}, {Key: "mfa_last_counter", Value: bson.D{{Key: "$lt", Value: counter}}}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "mfa_last_counter", Value: counter}}}}
+
+		res, err := uc.collection.UpdateOne(uc.ctx, query, update)
+		if err != nil {
+			return err
+		}
+
+		if res.MatchedCount == 0 {
+			return errors.New("invalid MFA code, it was already used")
+		}
+
+		return uc.userCache.InvalidateUser(user.ID.Hex())
+	}
+
+	if recoveryCode != "" {
+		hashedCode := utils.HashToken(utils.NormalizeRecoveryCode(recoveryCode))
+		query := bson.D{{Key: "_id", Value: user.ID}, {Key: "mfa_recovery_codes", Value: hashedCode}}
+		update := bson.D{{Key: "$pull", Value: bson.D{{Key: "mfa_recovery_codes", Value: hashedCode}}}}
+
+		res, err := uc.collection.UpdateOne(uc.ctx, query, update)
+		if err != nil {
+			return err
+		}
+
+		if res.MatchedCount == 0 {
+			return errors.New("invalid recovery code")
+		}
+
+		return uc.userCache.InvalidateUser(user.ID.Hex())
+	}
+
+	return errors.New("invalid request: code or recovery_code is required")
+}
+
+func (uc *AuthServiceImpl) RegenerateRecoveryCodes(userId string) ([]string, error) {
+	recoveryCodes, hashedCodes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	obId, _ := primitive.ObjectIDFromHex(userId)
+	query := bson.D{{Key: "_id", Value: obId}, {Key: "mfa_enabled", Value: true}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "mfa_recovery_codes", Value: hashedCodes}}}}
+
+	res, err := uc.collection.UpdateOne(uc.ctx, query, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.MatchedCount == 0 {
+		return nil, errors.New("invalid request: MFA is not enabled")
+	}
+
+	if err := uc.userCache.InvalidateUser(userId); err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+func (uc *AuthServiceImpl) DisableMFA(userId string) error {
+	obId, _ := primitive.ObjectIDFromHex(userId)
+	query := bson.D{{Key: "_id", Value: obId}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "mfa_enabled", Value: false}, {Key: "updated_at", Value: time.Now()}}},
+		{Key: "$unset", Value: bson.D{
+			{Key: "mfa_secret", Value: ""},
+			{Key: "mfa_pending_secret", Value: ""},
+			{Key: "mfa_recovery_codes", Value: ""},
+			{Key: "mfa_last_counter", Value: ""},
+		}},
+	}
+
+	res, err := uc.collection.UpdateOne(uc.ctx, query, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return errors.New("no document with that Id exists")
+	}
+
+	return uc.userCache.InvalidateUser(userId)
+}
+
+func (uc *AuthServiceImpl) RotateMFASecrets() (int, error) {
+	filter := bson.M{"$or": []bson.M{{"mfa_secret": bson.M{"$exists": true}}, {"mfa_pending_secret": bson.M{"$exists": true}}}}
+
+	cursor, err := uc.collection.Find(uc.ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(uc.ctx)
+
+	rotated := 0
+	for cursor.Next(uc.ctx) {
+		var user models.UserDBResponse
+		if err := cursor.Decode(&user); err != nil {
+			return rotated, err
+		}
+
+		set := bson.D{}
+		for field, value := range map[string]string{"mfa_secret": user.MFASecret, "mfa_pending_secret": user.MFAPendingSecret} {
+			if value == "" {
+				continue
+			}
+
+			newValue, changed, err := utils.RotateSecret(value)
+			if err != nil {
+				return rotated, fmt.Errorf("could not rotate the MFA secret of user %s: %w", user.ID.Hex(), err)
+			}
+			if changed {
+				set = append(set, bson.E{Key: field, Value: newValue})
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+
+		if _, err := uc.collection.UpdateOne(uc.ctx, bson.M{"_id": user.ID}, bson.D{{Key: "$set", Value: set}}); err != nil {
+			return rotated, err
+		}
+		if err := uc.userCache.InvalidateUser(user.ID.Hex()); err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+
+	return rotated, cursor.Err()
+}
+
+// newRecoveryCodes Generate recovery codes, only their hashes are stored
+func newRecoveryCodes() ([]string, []string, error) {
+	recoveryCodes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashedCodes := make([]string, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashedCodes[i] = utils.HashToken(code)
+	}
+
+	return recoveryCodes, hashedCodes, nil
+}
+
+func (uc *AuthServiceImpl) SetPassword(userId string, password string) error {
+	obId, _ := primitive.ObjectIDFromHex(userId)
+	query := bson.D{{Key: "_id", Value: obId}, {Key: "auth_method", Value: utils.BasicAuth}}
+
+	var user *models.UserDBResponse
+	if err := uc.collection.FindOne(uc.ctx, query).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errors.New("no document with that Id exists for basic auth")
+		}
+		return err
+	}
+
+	hashedPassword, err := newPasswordHash(user, password)
+	if err != nil {
+		return err
+	}
+
+	res, err := uc.collection.UpdateOne(uc.ctx, query, passwordUpdate(hashedPassword, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return errors.New("no document with that Id exists for basic auth")
+	}
+
+	return uc.userCache.InvalidateUser(userId)
+}
+
+func (uc *AuthServiceImpl) ChangePassword(user *models.UserDBResponse, currentPassword string, password string) error {
+	if user.AuthMethod != utils.BasicAuth {
+		return errors.New("invalid request: the password is managed by your identity provider")
+	}
+
+	if err := utils.VerifyPassword(user.Password, currentPassword); err != nil {
+		return errors.New("invalid current password")
+	}
+
+	hashedPassword, err := newPasswordHash(user, password)
+	if err != nil {
+		return err
+	}
+
+	// the stored hash is part of the filter, so a concurrent change is not overwritten
+	query := bson.D{{Key: "_id", Value: user.ID}, {Key: "password", Value: user.Password}}
+	res, err := uc.collection.UpdateOne(uc.ctx, query, passwordUpdate(hashedPassword, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return errors.New("invalid current password")
+	}
+
+	return uc.userCache.InvalidateUser(user.ID.Hex())
+}
+
+func (uc *AuthServiceImpl) RehashPassword(user *models.UserDBResponse, password string) error {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	// same password with the configured cost, so sessions and the history stay untouched
+	query := bson.D{{Key: "_id", Value: user.ID}, {Key: "password", Value: user.Password}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hashedPassword}}}}
+
+	_, err = uc.collection.UpdateOne(uc.ctx, query, update)
+	if err != nil {
+		return err
+	}
+
+	return uc.userCache.InvalidateUser(user.ID.Hex())
+}
+
+// newPasswordHash Check the password against the policy and the recent passwords of the user, then hash it
+func newPasswordHash(user *models.UserDBResponse, password string) (string, error) {
+	if err := utils.ValidatePassword(password, user.Username, user.Email); err != nil {
+		return "", err
+	}
+
+	if utils.PasswordReused(password, user.Password, user.PasswordHistory) {
+		return "", errors.New("invalid password: it was used recently, please choose another one")
+	}
+
+	return utils.HashPassword(password)
+}
+
+// passwordUpdate Store a new password hash and remember it in the history,
+// password_changed_at makes every token issued before the change invalid
+func passwordUpdate(hashedPassword string, now time.Time) bson.D {
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "password", Value: hashedPassword},
+			{Key: "password_changed_at", Value: now},
+			{Key: "updated_at", Value: now},
+		}},
+		{Key: "$unset", Value: bson.D{{Key: "password_reset_token", Value: ""}, {Key: "password_reset_at", Value: ""}}},
+	}
+
+	if size := utils.PasswordHistorySize(); size > 0 {
+		update = append(update, bson.E{Key: "$push", Value: bson.D{{Key: "password_history", Value: bson.D{
+			{Key: "$each", Value: bson.A{hashedPassword}},
+			{Key: "$slice", Value: -size},
+		}}}})
+	}
+
+	return update
+}
@@ -10,6 +10,7 @@ type AuthMethodService interface {
 	GetActiveAuthMethods() ([]*models.AuthMethod, error)
 	CountAuthMethods() (int64, error)
 	CreateAuthMethod(authMethod *models.AuthMethod) error
-	UpdateAuthMethod(id string, authMethod *models.AuthMethod) error
+	UpdateAuthMethod(id string, input *models.UpdateAuthMethod) error
 	DeleteAuthMethod(id string) error
+	RotateSecrets() (int, error)
 }
@@ -2,7 +2,9 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/thuongnn/clst-mgt-api/models"
 	"github.com/thuongnn/clst-mgt-api/utils"
 	"go.mongodb.org/mongo-driver/bson"
@@ -31,6 +33,9 @@ func (a AuthMethodServiceImpl) GetAuthMethods() ([]*models.AuthMethod, error) {
 		if err := cursor.Decode(&authMethod); err != nil {
 			return nil, err
 		}
+		if err := decryptAuthMethod(&authMethod); err != nil {
+			return nil, err
+		}
 		authMethods = append(authMethods, &authMethod)
 	}
 
@@ -55,6 +60,10 @@ func (a AuthMethodServiceImpl) GetAuthMethodById(id string) (*models.AuthMethod,
 		return nil, errDecode
 	}
 
+	if err := decryptAuthMethod(authMethod); err != nil {
+		return nil, err
+	}
+
 	return authMethod, nil
 }
 
@@ -73,6 +82,9 @@ func (a AuthMethodServiceImpl) GetActiveAuthMethods() ([]*models.AuthMethod, err
 		if err := cursor.Decode(&authMethod); err != nil {
 			return nil, err
 		}
+		if err := decryptAuthMethod(&authMethod); err != nil {
+			return nil, err
+		}
 		authMethods = append(authMethods, &authMethod)
 	}
 
@@ -91,25 +103,143 @@ func (a AuthMethodServiceImpl) CountAuthMethods() (int64, error) {
 	return count, nil
 }
 
+// decryptAuthMethod Decrypt the secrets of a stored auth method, they are only encrypted at rest
+func decryptAuthMethod(authMethod *models.AuthMethod) error {
+	configs, err := utils.DecryptAuthMethodSecrets(authMethod.Type, authMethod.Configs)
+	if err != nil {
+		return err
+	}
+
+	authMethod.Configs = configs
+	return nil
+}
+
+// validateAuthMethod Check the configs of the auth method before they are stored
+func validateAuthMethod(authMethod *models.AuthMethod) error {
+	if authMethod.Type == utils.Oauth2Auth && len(authMethod.Configs) > 0 {
+		var oauth2Info models.OAuth2Config
+		if err := json.Unmarshal(authMethod.Configs, &oauth2Info); err != nil {
+			return fmt.Errorf("invalid OAuth2 config: %w", err)
+		}
+
+		if err := utils.ValidateGroupMappings(oauth2Info.GroupMappings); err != nil {
+			return err
+		}
+	}
+
+	if authMethod.Type == utils.BasicAuth && len(authMethod.Configs) > 0 {
+		var basicAuthConfig models.BasicAuthConfig
+		if err := json.Unmarshal(authMethod.Configs, &basicAuthConfig); err != nil {
+			return fmt.Errorf("invalid basic auth config: %w", err)
+		}
+
+		if err := utils.ValidateBasicAuthConfig(basicAuthConfig); err != nil {
+			return err
+		}
+	}
+
+	if authMethod.Type == utils.LDAPAuth && len(authMethod.Configs) > 0 {
+		var ldapInfo models.LDAPConfig
+		if err := json.Unmarshal(authMethod.Configs, &ldapInfo); err != nil {
+			return fmt.Errorf("invalid LDAP config: %w", err)
+		}
+
+		if err := utils.ValidateLDAPConfig(ldapInfo); err != nil {
+			return err
+		}
+	}
+
+	if authMethod.Type == utils.SAMLAuth && len(authMethod.Configs) > 0 {
+		var samlInfo models.SAMLConfig
+		if err := json.Unmarshal(authMethod.Configs, &samlInfo); err != nil {
+			return fmt.Errorf("invalid SAML config: %w", err)
+		}
+
+		if err := utils.ValidateSAMLConfig(samlInfo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (a AuthMethodServiceImpl) CreateAuthMethod(authMethod *models.AuthMethod) error {
+	switch authMethod.Type {
+	case utils.BasicAuth, utils.Oauth2Auth, utils.LDAPAuth, utils.SAMLAuth:
+	default:
+		return fmt.Errorf("invalid auth method type %q", authMethod.Type)
+	}
+
+	// one method per type, so the settings a login uses are never ambiguous
+	count, err := a.authMethodCollection.CountDocuments(a.ctx, bson.M{"type": authMethod.Type})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("auth method of type %s already exist", authMethod.Type)
+	}
+
+	if err := validateAuthMethod(authMethod); err != nil {
+		return err
+	}
+
+	configs, err := utils.EncryptAuthMethodSecrets(authMethod.Type, authMethod.Configs)
+	if err != nil {
+		return err
+	}
+	authMethod.Configs = configs
+
 	authMethod.CreateAt = time.Now()
 	authMethod.UpdatedAt = authMethod.CreateAt
 	authMethod.IsActive = true
 
-	_, err := a.authMethodCollection.InsertOne(a.ctx, authMethod)
+	_, err = a.authMethodCollection.InsertOne(a.ctx, authMethod)
 	return err
 }
 
-func (a AuthMethodServiceImpl) UpdateAuthMethod(id string, authMethod *models.AuthMethod) error {
-	authMethod.UpdatedAt = time.Now()
+func (a AuthMethodServiceImpl) UpdateAuthMethod(id string, input *models.UpdateAuthMethod) error {
+	current, err := a.GetAuthMethodById(id)
+	if err != nil {
+		return err
+	}
+
+	// secrets are never sent back by the API, omitted ones keep their stored value
+	if len(input.Configs) > 0 {
+		configs, err := utils.MergeAuthMethodSecrets(current.Type, input.Configs, current.Configs)
+		if err != nil {
+			return err
+		}
+
+		if err := validateAuthMethod(&models.AuthMethod{Type: current.Type, Configs: configs}); err != nil {
+			return err
+		}
+
+		configs, err = utils.EncryptAuthMethodSecrets(current.Type, configs)
+		if err != nil {
+			return err
+		}
+		input.Configs = configs
+	}
+
+	// nobody could log in anymore without an active method
+	if input.IsActive != nil && !*input.IsActive && current.IsActive {
+		otherActive, err := a.authMethodCollection.CountDocuments(a.ctx, bson.M{"_id": bson.M{"$ne": current.Id}, "is_active": true})
+		if err != nil {
+			return err
+		}
+		if otherActive == 0 {
+			return errors.New("invalid update, the last active authentication method can't be deactivated")
+		}
+	}
+
+	input.UpdatedAt = time.Now()
 
-	doc, err := utils.ToDoc(authMethod)
+	doc, err := utils.ToDoc(input)
 	if err != nil {
 		return err
 	}
 
-	obId, _ := primitive.ObjectIDFromHex(id)
-	updateQuery := bson.D{{Key: "_id", Value: obId}}
+	updateQuery := bson.D{{Key: "_id", Value: current.Id}}
 	updateData := bson.D{{Key: "$set", Value: doc}}
 	res := a.authMethodCollection.FindOneAndUpdate(a.ctx, updateQuery, updateData)
 	if res.Err() != nil {
@@ -119,6 +249,38 @@ func (a AuthMethodServiceImpl) UpdateAuthMethod(id string, authMethod *models.Au
 	return nil
 }
 
+func (a AuthMethodServiceImpl) RotateSecrets() (int, error) {
+	cursor, err := a.authMethodCollection.Find(a.ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(a.ctx)
+
+	rotated := 0
+	for cursor.Next(a.ctx) {
+		var authMethod models.AuthMethod
+		if err := cursor.Decode(&authMethod); err != nil {
+			return rotated, err
+		}
+
+		configs, changed, err := utils.RotateAuthMethodSecrets(authMethod.Type, authMethod.Configs)
+		if err != nil {
+			return rotated, fmt.Errorf("could not rotate the secrets of auth method %s: %w", authMethod.Id.Hex(), err)
+		}
+		if !changed {
+			continue
+		}
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "configs", Value: configs}}}}
+		if _, err := a.authMethodCollection.UpdateOne(a.ctx, bson.M{"_id": authMethod.Id}, update); err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+
+	return rotated, cursor.Err()
+}
+
 func (a AuthMethodServiceImpl) DeleteAuthMethod(id string) error {
 	obId, _ := primitive.ObjectIDFromHex(id)
 	query := bson.M{"_id": obId}
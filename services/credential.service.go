@@ -0,0 +1,15 @@
+package services
+
+import (
+	"github.com/thuongnn/clst-mgt-api/models"
+)
+
+type CredentialService interface {
+	GetCredentials() ([]*models.CredentialResponse, error)
+	GetCredentialById(id string) (*models.CredentialResponse, error)
+	GetDecryptedCredentialById(id string) (*models.Credential, error)
+	CreateCredential(credential *models.Credential) error
+	UpdateCredential(id string, credential *models.UpdateCredential) error
+	DeleteCredential(id string) error
+	RotateSecrets() (int, error)
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+type CredentialServiceImpl struct {
+	credentialCollection *mongo.Collection
+	ctx                  context.Context
+}
+
+func (c CredentialServiceImpl) GetCredentials() ([]*models.CredentialResponse, error) {
+	cursor, err := c.credentialCollection.Find(c.ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c.ctx)
+
+	var credentials []*models.CredentialResponse
+	for cursor.Next(c.ctx) {
+		credential := &models.CredentialResponse{}
+		if errDecode := cursor.Decode(credential); errDecode != nil {
+			return nil, errDecode
+		}
+		credentials = append(credentials, credential)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(credentials) == 0 {
+		return []*models.CredentialResponse{}, nil
+	}
+
+	return credentials, nil
+}
+
+func (c CredentialServiceImpl) GetCredentialById(id string) (*models.CredentialResponse, error) {
+	obId, _ := primitive.ObjectIDFromHex(id)
+
+	res := c.credentialCollection.FindOne(c.ctx, bson.M{"_id": obId})
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	var credential = &models.CredentialResponse{}
+	if errDecode := res.Decode(credential); errDecode != nil {
+		return nil, errDecode
+	}
+
+	return credential, nil
+}
+
+func (c CredentialServiceImpl) GetDecryptedCredentialById(id string) (*models.Credential, error) {
+	obId, _ := primitive.ObjectIDFromHex(id)
+
+	res := c.credentialCollection.FindOne(c.ctx, bson.M{"_id": obId})
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	var credential = &models.Credential{}
+	if errDecode := res.Decode(credential); errDecode != nil {
+		return nil, errDecode
+	}
+
+	privateKey, err := utils.DecryptSecret(credential.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	credential.PrivateKey = privateKey
+
+	return credential, nil
+}
+
+func (c CredentialServiceImpl) CreateCredential(credential *models.Credential) error {
+	// make sure the certificate, its key and the CA can actually be used for a handshake
+	if _, err := utils.BuildClientTLSConfig(credential); err != nil {
+		return err
+	}
+
+	encryptedKey, err := utils.EncryptSecret(credential.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	credential.PrivateKey = encryptedKey
+	credential.CreateAt = time.Now()
+	credential.UpdatedAt = credential.CreateAt
+
+	_, err = c.credentialCollection.InsertOne(c.ctx, credential)
+	return err
+}
+
+func (c CredentialServiceImpl) UpdateCredential(id string, credential *models.UpdateCredential) error {
+	current, err := c.GetDecryptedCredentialById(id)
+	if err != nil {
+		return err
+	}
+
+	// validate the resulting key pair, keeping the stored values for omitted fields
+	merged := *current
+	if credential.Certificate != "" {
+		merged.Certificate = credential.Certificate
+	}
+	if credential.PrivateKey != "" {
+		merged.PrivateKey = credential.PrivateKey
+	}
+	if credential.CACertificate != "" {
+		merged.CACertificate = credential.CACertificate
+	}
+	if credential.InsecureSkipVerify != nil {
+		merged.InsecureSkipVerify = *credential.InsecureSkipVerify
+	}
+	if _, err := utils.BuildClientTLSConfig(&merged); err != nil {
+		return err
+	}
+
+	if credential.PrivateKey != "" {
+		encryptedKey, err := utils.EncryptSecret(credential.PrivateKey)
+		if err != nil {
+			return err
+		}
+		credential.PrivateKey = encryptedKey
+	}
+
+	credential.UpdatedAt = time.Now()
+
+	doc, err := utils.ToDoc(credential)
+	if err != nil {
+		return err
+	}
+
+	obId, _ := primitive.ObjectIDFromHex(id)
+	updateQuery := bson.D{{Key: "_id", Value: obId}}
+	updateData := bson.D{{Key: "$set", Value: doc}}
+	res := c.credentialCollection.FindOneAndUpdate(c.ctx, updateQuery, updateData)
+	if res.Err() != nil {
+		return res.Err()
+	}
+
+	return nil
+}
+
+func (c CredentialServiceImpl) RotateSecrets() (int, error) {
+	cursor, err := c.credentialCollection.Find(c.ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(c.ctx)
+
+	rotated := 0
+	for cursor.Next(c.ctx) {
+		var credential models.Credential
+		if err := cursor.Decode(&credential); err != nil {
+			return rotated, err
+		}
+
+		privateKey, changed, err := utils.RotateSecret(credential.PrivateKey)
+		if err != nil {
+			return rotated, fmt.Errorf("could not rotate the private key of credential %s: %w", credential.Id.Hex(), err)
+		}
+		if !changed {
+			continue
+		}
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "private_key", Value: privateKey}}}}
+		if _, err := c.credentialCollection.UpdateOne(c.ctx, bson.M{"_id": credential.Id}, update); err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+
+	return rotated, cursor.Err()
+}
+
+func (c CredentialServiceImpl) DeleteCredential(id string) error {
+	obId, _ := primitive.ObjectIDFromHex(id)
+	query := bson.M{"_id": obId}
+
+	res, err := c.credentialCollection.DeleteOne(c.ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return errors.New("no document with that Ids exists")
+	}
+
+	return nil
+}
+
+func NewCredentialService(credentialCollection *mongo.Collection, ctx context.Context) CredentialService {
+	return &CredentialServiceImpl{credentialCollection, ctx}
+}
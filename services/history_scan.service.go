@@ -8,4 +8,7 @@ type HistoryScanService interface {
 	CreateHistoryScan(historyScan *models.DBHistoryScan) error
 	GetHistoryScanByRuleId(ruleId string) ([]*models.DBHistoryScan, error)
 	CleanUpHistoryScanByRuleId(ruleId string) error
+	CleanUpServiceTargets(ruleId string, nodeId string, service string) error
+	GetMeshMatrixByRuleId(ruleId string) (*models.MeshMatrix, error)
+	GetDNSConsistencyReport(hostname string, ruleIds []string) ([]*models.DNSConsistencyReport, error)
 }
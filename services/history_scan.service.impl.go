@@ -3,11 +3,14 @@ package services
 import (
 	"context"
 	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
+	"sort"
+	"strings"
 )
 
 type HistoryScanServiceImpl struct {
@@ -28,6 +31,15 @@ func (h HistoryScanServiceImpl) CleanUpHistoryScanByRuleId(ruleId string) error
 	return nil
 }
 
+// CleanUpServiceTargets Delete the results a node recorded for the targets of a destination service
+func (h HistoryScanServiceImpl) CleanUpServiceTargets(ruleId string, nodeId string, service string) error {
+	obId, _ := primitive.ObjectIDFromHex(ruleId)
+	filter := bson.M{"rule_id": obId, "node_id": nodeId, "destination_service": service}
+
+	_, err := h.historyScanCollection.DeleteMany(h.ctx, filter)
+	return err
+}
+
 func (h HistoryScanServiceImpl) CreateHistoryScan(historyScan *models.DBHistoryScan) error {
 	filter := bson.M{
 		"rule_id":             historyScan.RuleId,
@@ -37,12 +49,24 @@ func (h HistoryScanServiceImpl) CreateHistoryScan(historyScan *models.DBHistoryS
 	}
 
 	update := bson.M{"$set": bson.M{
-		"node_address":     historyScan.NodeAddress,
-		"is_through_proxy": historyScan.IsThroughProxy,
-		"node_name":        historyScan.NodeName,
-		"error_message":    historyScan.ErrorMessage,
-		"status":           historyScan.Status,
-		"updated_at":       historyScan.UpdatedAt,
+		"node_address":          historyScan.NodeAddress,
+		"is_through_proxy":      historyScan.IsThroughProxy,
+		"node_name":             historyScan.NodeName,
+		"destination_node_id":   historyScan.DestinationNodeId,
+		"destination_node_name": historyScan.DestinationNodeName,
+		"destination_service":   historyScan.DestinationService,
+		"target_kind":           historyScan.TargetKind,
+		"endpoint_target_ref":   historyScan.EndpointTargetRef,
+		"endpoint_node_name":    historyScan.EndpointNodeName,
+		"resolved_hostname":     historyScan.ResolvedHostname,
+		"dns_server":            historyScan.DNSServer,
+		"resolved_addresses":    historyScan.ResolvedAddresses,
+		"banner":                historyScan.Banner,
+		"tls_handshake":         historyScan.TLSHandshake,
+		"tls_alert":             historyScan.TLSAlert,
+		"error_message":         historyScan.ErrorMessage,
+		"status":                historyScan.Status,
+		"updated_at":            historyScan.UpdatedAt,
 	}}
 
 	upsert := true // create new if not exists
@@ -88,6 +112,212 @@ func (h HistoryScanServiceImpl) GetHistoryScanByRuleId(ruleId string) ([]*models
 	return records, nil
 }
 
+func (h HistoryScanServiceImpl) GetMeshMatrixByRuleId(ruleId string) (*models.MeshMatrix, error) {
+	obId, _ := primitive.ObjectIDFromHex(ruleId)
+	query := bson.M{"rule_id": obId, "destination_node_id": bson.M{"$exists": true}}
+
+	opt := options.FindOptions{}
+	opt.SetSort(bson.D{{Key: "node_name", Value: 1}, {Key: "destination_node_name", Value: 1}, {Key: "destination_port", Value: 1}})
+
+	cursor, err := h.historyScanCollection.Find(h.ctx, query, &opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(h.ctx)
+
+	matrix := &models.MeshMatrix{
+		RuleId:       obId,
+		Sources:      []*models.MeshMatrixNode{},
+		Destinations: []*models.MeshMatrixNode{},
+		Cells:        []*models.MeshMatrixCell{},
+	}
+
+	sources := make(map[string]bool)
+	destinations := make(map[string]bool)
+	cells := make(map[string]*models.MeshMatrixCell)
+
+	for cursor.Next(h.ctx) {
+		record := &models.DBHistoryScan{}
+		if errDecode := cursor.Decode(record); errDecode != nil {
+			return nil, errDecode
+		}
+
+		if !sources[record.NodeId] {
+			sources[record.NodeId] = true
+			matrix.Sources = append(matrix.Sources, &models.MeshMatrixNode{NodeId: record.NodeId, NodeName: record.NodeName})
+		}
+
+		if !destinations[record.DestinationNodeId] {
+			destinations[record.DestinationNodeId] = true
+			matrix.Destinations = append(matrix.Destinations, &models.MeshMatrixNode{NodeId: record.DestinationNodeId, NodeName: record.DestinationNodeName})
+		}
+
+		cellKey := record.NodeId + "/" + record.DestinationNodeId
+		cell, ok := cells[cellKey]
+		if !ok {
+			cell = &models.MeshMatrixCell{
+				SourceNodeId:      record.NodeId,
+				DestinationNodeId: record.DestinationNodeId,
+				Status:            utils.StatusSuccessScan,
+				Ports:             []*models.MeshMatrixPort{},
+			}
+			cells[cellKey] = cell
+			matrix.Cells = append(matrix.Cells, cell)
+		}
+
+		// A single unreachable port marks the whole path as failed
+		if record.Status != utils.StatusSuccessScan {
+			cell.Status = utils.StatusErrorScan
+		}
+
+		cell.Ports = append(cell.Ports, &models.MeshMatrixPort{
+			Port:         record.DestinationPort,
+			Status:       record.Status,
+			ErrorMessage: record.ErrorMessage,
+			UpdatedAt:    record.UpdatedAt,
+		})
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return matrix, nil
+}
+
+func (h HistoryScanServiceImpl) GetDNSConsistencyReport(hostname string, ruleIds []string) ([]*models.DNSConsistencyReport, error) {
+	query := bson.M{"resolved_hostname": bson.M{"$exists": true, "$ne": ""}}
+	if strings.TrimSpace(hostname) != "" {
+		query["resolved_hostname"] = strings.ToLower(strings.TrimSpace(hostname))
+	}
+
+	// nil ruleIds covers every rule, otherwise only the results of these rules are reported
+	if ruleIds != nil {
+		obIds := make([]primitive.ObjectID, 0, len(ruleIds))
+		for _, ruleId := range ruleIds {
+			obId, _ := primitive.ObjectIDFromHex(ruleId)
+			obIds = append(obIds, obId)
+		}
+		query["rule_id"] = bson.M{"$in": obIds}
+	}
+
+	// Newest first, so the first record seen for a node is its latest answer
+	opt := options.FindOptions{}
+	opt.SetSort(bson.D{{Key: "resolved_hostname", Value: 1}, {Key: "updated_at", Value: -1}})
+
+	cursor, err := h.historyScanCollection.Find(h.ctx, query, &opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(h.ctx)
+
+	var reports []*models.DNSConsistencyReport
+	reportByHostname := make(map[string]*models.DNSConsistencyReport)
+	serverByKey := make(map[string]*models.DNSServerConsistency)
+	answersByServer := make(map[*models.DNSServerConsistency]map[string]bool)
+	seenAnswers := make(map[string]bool)
+
+	for cursor.Next(h.ctx) {
+		record := &models.DBHistoryScan{}
+		if errDecode := cursor.Decode(record); errDecode != nil {
+			return nil, errDecode
+		}
+
+		answerKey := record.ResolvedHostname + "/" + record.NodeId + "/" + record.DNSServer
+		if seenAnswers[answerKey] {
+			continue
+		}
+		seenAnswers[answerKey] = true
+
+		report, ok := reportByHostname[record.ResolvedHostname]
+		if !ok {
+			report = &models.DNSConsistencyReport{
+				Hostname: record.ResolvedHostname,
+				Answers:  []*models.DNSNodeAnswer{},
+			}
+			reportByHostname[record.ResolvedHostname] = report
+			reports = append(reports, report)
+		}
+
+		serverKey := record.ResolvedHostname + "/" + record.DNSServer
+		server, ok := serverByKey[serverKey]
+		if !ok {
+			server = &models.DNSServerConsistency{DNSServer: record.DNSServer}
+			serverByKey[serverKey] = server
+			answersByServer[server] = make(map[string]bool)
+			report.DNSServers = append(report.DNSServers, server)
+		}
+
+		resolvedAddresses := append([]string{}, record.ResolvedAddresses...)
+		sort.Strings(resolvedAddresses)
+		if len(resolvedAddresses) == 0 {
+			server.FailedLookups++
+			report.FailedLookups++
+		} else {
+			answersByServer[server][strings.Join(resolvedAddresses, ",")] = true
+		}
+
+		answer := &models.DNSNodeAnswer{
+			NodeId:            record.NodeId,
+			NodeName:          record.NodeName,
+			DNSServer:         record.DNSServer,
+			ResolvedAddresses: resolvedAddresses,
+			UpdatedAt:         record.UpdatedAt,
+		}
+		if len(resolvedAddresses) == 0 {
+			answer.ErrorMessage = record.ErrorMessage
+		}
+		report.Answers = append(report.Answers, answer)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, report := range reports {
+		report.IsConsistent = true
+		report.Findings = []string{}
+		serverAnswerSets := make(map[string]bool)
+		for _, server := range report.DNSServers {
+			server.DistinctAnswers = len(answersByServer[server])
+			server.IsConsistent = server.DistinctAnswers <= 1
+			report.IsConsistent = report.IsConsistent && server.IsConsistent
+			if server.DistinctAnswers > report.DistinctAnswers {
+				report.DistinctAnswers = server.DistinctAnswers
+			}
+
+			// a server where every lookup failed has no answer to compare with the others
+			if server.DistinctAnswers > 0 {
+				serverAnswerSets[answerSetKey(answersByServer[server])] = true
+			}
+		}
+
+		if !report.IsConsistent {
+			report.Findings = append(report.Findings, utils.DNSFindingInconsistent)
+		}
+		if len(serverAnswerSets) > 1 {
+			report.Findings = append(report.Findings, utils.DNSFindingSplitHorizon)
+		}
+	}
+
+	if len(reports) == 0 {
+		return []*models.DNSConsistencyReport{}, nil
+	}
+
+	return reports, nil
+}
+
+// answerSetKey Join the distinct answers of a DNS server in a stable order so two servers can be compared
+func answerSetKey(answers map[string]bool) string {
+	keys := make([]string, 0, len(answers))
+	for answer := range answers {
+		keys = append(keys, answer)
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ";")
+}
+
 func NewHistoryScanService(historyScanCollection *mongo.Collection, ctx context.Context) HistoryScanService {
 	return &HistoryScanServiceImpl{historyScanCollection, ctx}
 }
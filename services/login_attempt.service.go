@@ -0,0 +1,8 @@
+package services
+
+import "github.com/thuongnn/clst-mgt-api/models"
+
+type LoginAttemptService interface {
+	RecordLoginAttempt(attempt *models.LoginAttempt) error
+	GetLoginAttempts(params *models.LoginAttemptSearchParams) (*models.LoginAttemptListResponse, error)
+}
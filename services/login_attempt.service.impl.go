@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/thuongnn/clst-mgt-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LoginAttemptServiceImpl struct {
+	collection *mongo.Collection
+	ctx        context.Context
+}
+
+func (l LoginAttemptServiceImpl) RecordLoginAttempt(attempt *models.LoginAttempt) error {
+	attempt.CreatedAt = time.Now()
+	attempt.Username = normalizeLoginUsername(attempt.Username)
+
+	_, err := l.collection.InsertOne(l.ctx, attempt)
+	return err
+}
+
+func (l LoginAttemptServiceImpl) GetLoginAttempts(params *models.LoginAttemptSearchParams) (*models.LoginAttemptListResponse, error) {
+	page := params.CurrentPage
+	limit := params.PageSize
+
+	// attempts with an unknown password are recorded by username before the user is looked up
+	filter := bson.M{}
+	var userFilter []bson.M
+	if params.UserId != "" {
+		userFilter = append(userFilter, bson.M{"user_id": params.UserId})
+	}
+	if params.Username != "" {
+		userFilter = append(userFilter, bson.M{"username": normalizeLoginUsername(params.Username)})
+	}
+	if len(userFilter) > 0 {
+		filter["$or"] = userFilter
+	}
+	if params.Success != nil {
+		filter["success"] = *params.Success
+	}
+
+	// 👇 Calculate the total number of pages
+	count, err := l.collection.CountDocuments(l.ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// In case there are no documents matching the filter
+	if count == 0 {
+		return &models.LoginAttemptListResponse{
+			Data:       []*models.LoginAttempt{},
+			Pagination: &models.Pagination{},
+		}, nil
+	}
+
+	totalPages := int(math.Ceil(float64(count) / float64(limit)))
+	if page > totalPages {
+		page = totalPages
+	}
+
+	opt := options.FindOptions{}
+	opt.SetLimit(int64(limit))
+	opt.SetSkip(int64((page - 1) * limit))
+	opt.SetSort(bson.M{"created_at": -1})
+
+	cursor, err := l.collection.Find(l.ctx, filter, &opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(l.ctx)
+
+	var attempts []*models.LoginAttempt
+	for cursor.Next(l.ctx) {
+		attempt := &models.LoginAttempt{}
+		if errDecode := cursor.Decode(attempt); errDecode != nil {
+			return nil, errDecode
+		}
+
+		attempts = append(attempts, attempt)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return &models.LoginAttemptListResponse{
+		Data: attempts,
+		Pagination: &models.Pagination{
+			CurrentPage: page,
+			TotalPages:  totalPages,
+			PageSize:    limit,
+			TotalCount:  int(count),
+		},
+	}, nil
+}
+
+func NewLoginAttemptService(collection *mongo.Collection, ctx context.Context) LoginAttemptService {
+	return &LoginAttemptServiceImpl{collection, ctx}
+}
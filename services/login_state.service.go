@@ -0,0 +1,8 @@
+package services
+
+import "github.com/thuongnn/clst-mgt-api/models"
+
+type LoginStateService interface {
+	CreateLoginState(loginState *models.OAuth2LoginState) (string, error)
+	ConsumeLoginState(state string) (*models.OAuth2LoginState, error)
+}
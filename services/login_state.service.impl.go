@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+)
+
+type LoginStateServiceImpl struct {
+	redisClient *redis.Client
+	ctx         context.Context
+}
+
+func loginStateKey(state string) string {
+	return "auth:login_state:" + state
+}
+
+func (l LoginStateServiceImpl) CreateLoginState(loginState *models.OAuth2LoginState) (string, error) {
+	state, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(loginState)
+	if err != nil {
+		return "", err
+	}
+
+	if err := l.redisClient.Set(l.ctx, loginStateKey(state), data, utils.OAuth2LoginStateTTL).Err(); err != nil {
+		return "", err
+	}
+
+	return state, nil
+}
+
+func (l LoginStateServiceImpl) ConsumeLoginState(state string) (*models.OAuth2LoginState, error) {
+	// read and delete at once, a state can only be used for a single callback
+	pipe := l.redisClient.TxPipeline()
+	get := pipe.Get(l.ctx, loginStateKey(state))
+	pipe.Del(l.ctx, loginStateKey(state))
+	if _, err := pipe.Exec(l.ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	data, err := get.Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, errors.New("invalid or expired login state")
+		}
+		return nil, err
+	}
+
+	var loginState *models.OAuth2LoginState
+	if err := json.Unmarshal(data, &loginState); err != nil {
+		return nil, err
+	}
+
+	return loginState, nil
+}
+
+func NewLoginStateService(redisClient *redis.Client, ctx context.Context) LoginStateService {
+	return &LoginStateServiceImpl{redisClient, ctx}
+}
@@ -0,0 +1,12 @@
+package services
+
+import "time"
+
+type LoginThrottleService interface {
+	// CheckLogin Report how long the username or the client IP is still locked out, 0 when it is not
+	CheckLogin(username string, ipAddress string) (time.Duration, error)
+	// RecordFailure Count a failed login and return the lockout it caused, 0 when there is none
+	RecordFailure(username string, ipAddress string) (time.Duration, error)
+	RecordSuccess(username string) error
+	Unlock(username string) error
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/thuongnn/clst-mgt-api/utils"
+)
+
+type LoginThrottleServiceImpl struct {
+	redisClient *redis.Client
+	ctx         context.Context
+}
+
+func loginFailuresKey(kind string, value string) string {
+	return "auth:login_failures:" + kind + ":" + value
+}
+
+func loginLockKey(kind string, value string) string {
+	return "auth:login_lock:" + kind + ":" + value
+}
+
+func normalizeLoginUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// lockoutDuration Double the lockout with every failure past the threshold
+func lockoutDuration(failures int64, threshold int64) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+
+	lockout := utils.LoginLockoutBase
+	for i := threshold; i < failures && lockout < utils.LoginLockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > utils.LoginLockoutMax {
+		lockout = utils.LoginLockoutMax
+	}
+
+	return lockout
+}
+
+func (l LoginThrottleServiceImpl) CheckLogin(username string, ipAddress string) (time.Duration, error) {
+	pipe := l.redisClient.Pipeline()
+	userLock := pipe.PTTL(l.ctx, loginLockKey("user", normalizeLoginUsername(username)))
+	ipLock := pipe.PTTL(l.ctx, loginLockKey("ip", ipAddress))
+	if _, err := pipe.Exec(l.ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	// a missing key has a negative ttl
+	lockout := userLock.Val()
+	if ipLock.Val() > lockout {
+		lockout = ipLock.Val()
+	}
+	if lockout < 0 {
+		return 0, nil
+	}
+
+	return lockout, nil
+}
+
+func (l LoginThrottleServiceImpl) recordFailure(kind string, value string, threshold int64) (time.Duration, error) {
+	failuresKey := loginFailuresKey(kind, value)
+
+	pipe := l.redisClient.TxPipeline()
+	failures := pipe.Incr(l.ctx, failuresKey)
+	pipe.Expire(l.ctx, failuresKey, utils.LoginFailureWindow)
+	if _, err := pipe.Exec(l.ctx); err != nil {
+		return 0, err
+	}
+
+	lockout := lockoutDuration(failures.Val(), threshold)
+	if lockout > 0 {
+		if err := l.redisClient.Set(l.ctx, loginLockKey(kind, value), failures.Val(), lockout).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	return lockout, nil
+}
+
+func (l LoginThrottleServiceImpl) RecordFailure(username string, ipAddress string) (time.Duration, error) {
+	userLockout, err := l.recordFailure("user", normalizeLoginUsername(username), utils.LoginLockoutThreshold)
+	if err != nil {
+		return 0, err
+	}
+
+	ipLockout, err := l.recordFailure("ip", ipAddress, utils.LoginIPLockoutThreshold)
+	if err != nil {
+		return 0, err
+	}
+
+	if ipLockout > userLockout {
+		return ipLockout, nil
+	}
+	return userLockout, nil
+}
+
+func (l LoginThrottleServiceImpl) RecordSuccess(username string) error {
+	return l.redisClient.Del(l.ctx, loginFailuresKey("user", normalizeLoginUsername(username))).Err()
+}
+
+func (l LoginThrottleServiceImpl) Unlock(username string) error {
+	username = normalizeLoginUsername(username)
+	return l.redisClient.Del(l.ctx, loginFailuresKey("user", username), loginLockKey("user", username)).Err()
+}
+
+func NewLoginThrottleService(redisClient *redis.Client, ctx context.Context) LoginThrottleService {
+	return &LoginThrottleServiceImpl{redisClient, ctx}
+}
@@ -0,0 +1,14 @@
+package services
+
+import "github.com/thuongnn/clst-mgt-api/models"
+
+type MFAService interface {
+	CreateMFAChallenge(challenge *models.MFAChallenge) (string, error)
+	GetMFAChallenge(mfaToken string) (*models.MFAChallenge, error)
+	DeleteMFAChallenge(mfaToken string) error
+	// RecordMFAFailure Count a wrong code of the user over all of their challenges,
+	// past the limit every outstanding challenge is ended and true is returned
+	RecordMFAFailure(userId string) (bool, error)
+	MFALocked(userId string) (bool, error)
+	ResetMFAFailures(userId string) error
+}
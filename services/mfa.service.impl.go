@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+)
+
+type MFAServiceImpl struct {
+	redisClient *redis.Client
+	ctx         context.Context
+}
+
+// mfaChallengeKey only the hash of the token is used, like for the other opaque tokens
+func mfaChallengeKey(mfaToken string) string {
+	return "auth:mfa_challenge:" + utils.HashToken(mfaToken)
+}
+
+func mfaUserChallengesKey(userId string) string {
+	return "auth:mfa_user_challenges:" + userId
+}
+
+func mfaFailuresKey(userId string) string {
+	return "auth:mfa_failures:" + userId
+}
+
+func (m MFAServiceImpl) CreateMFAChallenge(challenge *models.MFAChallenge) (string, error) {
+	mfaToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return "", err
+	}
+
+	// the challenges of a user are tracked so they can all be ended at once
+	userChallengesKey := mfaUserChallengesKey(challenge.UserId)
+	_, err = m.redisClient.TxPipelined(m.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(m.ctx, mfaChallengeKey(mfaToken), data, utils.MFAChallengeTTL)
+		pipe.SAdd(m.ctx, userChallengesKey, mfaChallengeKey(mfaToken))
+		pipe.Expire(m.ctx, userChallengesKey, utils.MFAChallengeTTL)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return mfaToken, nil
+}
+
+func (m MFAServiceImpl) GetMFAChallenge(mfaToken string) (*models.MFAChallenge, error) {
+	data, err := m.redisClient.Get(m.ctx, mfaChallengeKey(mfaToken)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, errors.New("invalid or expired MFA token")
+		}
+		return nil, err
+	}
+
+	var challenge *models.MFAChallenge
+	if err := json.Unmarshal(data, &challenge); err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+func (m MFAServiceImpl) DeleteMFAChallenge(mfaToken string) error {
+	return m.redisClient.Del(m.ctx, mfaChallengeKey(mfaToken)).Err()
+}
+
+func (m MFAServiceImpl) RecordMFAFailure(userId string) (bool, error) {
+	failuresKey := mfaFailuresKey(userId)
+
+	failures, err := m.redisClient.Incr(m.ctx, failuresKey).Result()
+	if err != nil {
+		return false, err
+	}
+
+	// the window starts with the first failure
+	if failures == 1 {
+		if err := m.redisClient.Expire(m.ctx, failuresKey, utils.MFAUserFailureWindow).Err(); err != nil {
+			return false, err
+		}
+	}
+
+	if failures < utils.MFAUserAttempts {
+		return false, nil
+	}
+
+	userChallengesKey := mfaUserChallengesKey(userId)
+	challengeKeys, err := m.redisClient.SMembers(m.ctx, userChallengesKey).Result()
+	if err != nil {
+		return true, err
+	}
+
+	return true, m.redisClient.Del(m.ctx, append(challengeKeys, userChallengesKey)...).Err()
+}
+
+func (m MFAServiceImpl) MFALocked(userId string) (bool, error) {
+	failures, err := m.redisClient.Get(m.ctx, mfaFailuresKey(userId)).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return failures >= utils.MFAUserAttempts, nil
+}
+
+func (m MFAServiceImpl) ResetMFAFailures(userId string) error {
+	return m.redisClient.Del(m.ctx, mfaFailuresKey(userId)).Err()
+}
+
+func NewMFAService(redisClient *redis.Client, ctx context.Context) MFAService {
+	return &MFAServiceImpl{redisClient, ctx}
+}
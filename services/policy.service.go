@@ -0,0 +1,10 @@
+package services
+
+import (
+	"github.com/thuongnn/clst-mgt-api/models"
+)
+
+type PolicyService interface {
+	Can(subject *models.PolicySubject, action string, resource *models.PolicyResource) (bool, error)
+	Scope(subject *models.PolicySubject, action string, kind string) (*models.PolicyScope, error)
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+)
+
+// policyProjectRoles The project role an action needs on each kind of resource,
+// actions missing here are reserved to admins
+var policyProjectRoles = map[string]map[string]string{
+	utils.ResourceRule: {
+		utils.ActionRead:    utils.ProjectViewerRole,
+		utils.ActionCreate:  utils.ProjectEditorRole,
+		utils.ActionUpdate:  utils.ProjectEditorRole,
+		utils.ActionDelete:  utils.ProjectEditorRole,
+		utils.ActionTrigger: utils.ProjectEditorRole,
+	},
+	utils.ResourceProject: {
+		utils.ActionRead:   utils.ProjectViewerRole,
+		utils.ActionUpdate: utils.ProjectOwnerRole,
+		utils.ActionDelete: utils.ProjectOwnerRole,
+		utils.ActionManage: utils.ProjectOwnerRole,
+	},
+}
+
+// policyOwnerActions The actions the owner and co-owners of a rule may do whatever their project role
+var policyOwnerActions = map[string]bool{
+	utils.ActionRead:    true,
+	utils.ActionUpdate:  true,
+	utils.ActionDelete:  true,
+	utils.ActionTrigger: true,
+}
+
+type PolicyServiceImpl struct {
+	projectService ProjectService
+}
+
+// Can Decide whether the subject may do the action on the resource. Admins may do everything, the owner and
+// co-owners of a rule may manage it, everyone else needs the project role of the action in the projects of the
+// resource: one of them for reading, all of them otherwise. Project-bound API tokens only act through the
+// project role and only inside their project. Every denial is logged.
+func (p PolicyServiceImpl) Can(subject *models.PolicySubject, action string, resource *models.PolicyResource) (bool, error) {
+	if subject == nil || subject.User == nil {
+		LogPermissionDenied(nil, action, describeResource(resource), "not authenticated")
+		return false, nil
+	}
+	user := subject.User
+
+	if subject.Project != "" && !projectsAllowed(action, resource.Projects, []string{subject.Project}) {
+		LogPermissionDenied(user, action, describeResource(resource), "API token is bound to project "+subject.Project)
+		return false, nil
+	}
+
+	if user.Role == utils.AdminRole {
+		return true, nil
+	}
+
+	if subject.Project == "" && resource.Kind == utils.ResourceRule && policyOwnerActions[action] &&
+		resource.Owner != "" && (resource.Owner == user.Email || utils.Contains(resource.CoOwners, user.Email)) {
+		return true, nil
+	}
+
+	minRole, ok := policyProjectRoles[resource.Kind][action]
+	if !ok {
+		LogPermissionDenied(user, action, describeResource(resource), "admin only")
+		return false, nil
+	}
+
+	projects, err := p.projectsWithRole(user, minRole)
+	if err != nil {
+		return false, err
+	}
+
+	if !projectsAllowed(action, resource.Projects, projects) {
+		LogPermissionDenied(user, action, describeResource(resource), "needs the "+minRole+" role in the projects")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Scope Work out which resources of the kind the subject may do the action on, for filtering listings.
+// A nil scope means every resource.
+func (p PolicyServiceImpl) Scope(subject *models.PolicySubject, action string, kind string) (*models.PolicyScope, error) {
+	var scope *models.PolicyScope
+
+	user := subject.User
+	if user.Role != utils.AdminRole {
+		scope = &models.PolicyScope{Projects: []string{}}
+
+		if minRole, ok := policyProjectRoles[kind][action]; ok {
+			projects, err := p.projectsWithRole(user, minRole)
+			if err != nil {
+				return nil, err
+			}
+			scope.Projects = projects
+		}
+
+		if kind == utils.ResourceRule && policyOwnerActions[action] {
+			scope.Owner = user.Email
+		}
+	}
+
+	if subject.Project == "" {
+		return scope, nil
+	}
+
+	if scope == nil || utils.Contains(scope.Projects, subject.Project) {
+		return &models.PolicyScope{Projects: []string{subject.Project}}, nil
+	}
+
+	return &models.PolicyScope{Projects: []string{}}, nil
+}
+
+func (p PolicyServiceImpl) projectsWithRole(user *models.UserDBResponse, minRole string) ([]string, error) {
+	roles, err := p.projectService.GetProjectRoles(user.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	projects := []string{}
+	for project, role := range roles {
+		if utils.ProjectRoleAtLeast(role, minRole) {
+			projects = append(projects, project)
+		}
+	}
+
+	return projects, nil
+}
+
+// projectsAllowed Reading needs one of the resource projects to be allowed, every other action all of them.
+// A resource without projects is never allowed through projects.
+func projectsAllowed(action string, resourceProjects []string, allowed []string) bool {
+	if len(resourceProjects) == 0 {
+		return false
+	}
+
+	for _, project := range resourceProjects {
+		found := utils.Contains(allowed, project)
+		if action == utils.ActionRead && found {
+			return true
+		}
+		if action != utils.ActionRead && !found {
+			return false
+		}
+	}
+
+	return action != utils.ActionRead
+}
+
+func describeResource(resource *models.PolicyResource) string {
+	if resource.Id == "" {
+		return resource.Kind
+	}
+
+	return resource.Kind + " " + resource.Id
+}
+
+// LogPermissionDenied Write the one log line every authorization denial produces
+func LogPermissionDenied(user *models.UserDBResponse, action string, resource string, reason string) {
+	subject := "anonymous"
+	if user != nil {
+		subject = fmt.Sprintf("%s (%s)", user.ID.Hex(), user.Email)
+	}
+
+	log.Printf("permission denied: user %s cannot %s %s: %s", subject, action, resource, reason)
+}
+
+func NewPolicyService(projectService ProjectService) PolicyService {
+	return &PolicyServiceImpl{projectService}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testProjectService only answers the project roles, keyed by user id
+type testProjectService struct {
+	ProjectService
+	roles map[string]map[string]string
+}
+
+func (s testProjectService) GetProjectRoles(userId string) (map[string]string, error) {
+	return s.roles[userId], nil
+}
+
+func TestPolicyCan(t *testing.T) {
+	newUser := func(email string, role string) *models.UserDBResponse {
+		return &models.UserDBResponse{ID: primitive.NewObjectID(), Email: email, Role: role}
+	}
+
+	admin := newUser("admin@example.org", utils.AdminRole)
+	owner := newUser("owner@example.org", utils.UserRole)
+	coOwner := newUser("co-owner@example.org", utils.UserRole)
+	viewer := newUser("viewer@example.org", utils.UserRole)
+	editorA := newUser("editor-a@example.org", utils.UserRole)
+	editorAB := newUser("editor-ab@example.org", utils.UserRole)
+	projectOwner := newUser("project-owner@example.org", utils.UserRole)
+	outsider := newUser("outsider@example.org", utils.UserRole)
+
+	policy := NewPolicyService(testProjectService{roles: map[string]map[string]string{
+		viewer.ID.Hex():       {"a": utils.ProjectViewerRole, "b": utils.ProjectViewerRole},
+		editorA.ID.Hex():      {"a": utils.ProjectEditorRole, "b": utils.ProjectViewerRole},
+		editorAB.ID.Hex():     {"a": utils.ProjectEditorRole, "b": utils.ProjectEditorRole},
+		projectOwner.ID.Hex(): {"a": utils.ProjectOwnerRole},
+	}})
+
+	rule := &models.PolicyResource{
+		Kind:     utils.ResourceRule,
+		Id:       "rule",
+		Owner:    owner.Email,
+		CoOwners: []string{coOwner.Email},
+		Projects: []string{"a", "b"},
+	}
+	unassignedRule := &models.PolicyResource{Kind: utils.ResourceRule, Id: "unassigned", Owner: owner.Email}
+	project := &models.PolicyResource{Kind: utils.ResourceProject, Id: "a", Projects: []string{"a"}}
+
+	tests := []struct {
+		name     string
+		subject  *models.PolicySubject
+		action   string
+		resource *models.PolicyResource
+		want     bool
+	}{
+		{"anonymous is denied", nil, utils.ActionRead, rule, false},
+
+		{"admin reads the rule", &models.PolicySubject{User: admin}, utils.ActionRead, rule, true},
+		{"admin deletes the rule", &models.PolicySubject{User: admin}, utils.ActionDelete, rule, true},
+		{"admin manages the project", &models.PolicySubject{User: admin}, utils.ActionManage, project, true},
+
+		{"owner updates the rule", &models.PolicySubject{User: owner}, utils.ActionUpdate, rule, true},
+		{"owner triggers the rule", &models.PolicySubject{User: owner}, utils.ActionTrigger, rule, true},
+		{"owner deletes a rule without projects", &models.PolicySubject{User: owner}, utils.ActionDelete, unassignedRule, true},
+		{"owner can't create rules without a project role", &models.PolicySubject{User: owner}, utils.ActionCreate, rule, false},
+		{"co-owner updates the rule", &models.PolicySubject{User: coOwner}, utils.ActionUpdate, rule, true},
+		{"co-owner reads the rule", &models.PolicySubject{User: coOwner}, utils.ActionRead, rule, true},
+		{"co-owner can't read another rule", &models.PolicySubject{User: coOwner}, utils.ActionRead, &models.PolicyResource{Kind: utils.ResourceRule, Owner: owner.Email, Projects: []string{"a"}}, false},
+
+		{"viewer reads the rule", &models.PolicySubject{User: viewer}, utils.ActionRead, rule, true},
+		{"viewer can't update the rule", &models.PolicySubject{User: viewer}, utils.ActionUpdate, rule, false},
+		{"viewer can't trigger the rule", &models.PolicySubject{User: viewer}, utils.ActionTrigger, rule, false},
+		{"viewer can't read a rule without projects", &models.PolicySubject{User: viewer}, utils.ActionRead, unassignedRule, false},
+
+		// reading needs one of the projects, writing needs all of them
+		{"editor of one project reads the rule", &models.PolicySubject{User: editorA}, utils.ActionRead, rule, true},
+		{"editor of one project can't update the rule", &models.PolicySubject{User: editorA}, utils.ActionUpdate, rule, false},
+		{"editor of one project updates a rule of that project", &models.PolicySubject{User: editorA}, utils.ActionUpdate, &models.PolicyResource{Kind: utils.ResourceRule, Projects: []string{"a"}}, true},
+		{"editor of every project updates the rule", &models.PolicySubject{User: editorAB}, utils.ActionUpdate, rule, true},
+		{"editor of every project triggers the rule", &models.PolicySubject{User: editorAB}, utils.ActionTrigger, rule, true},
+		{"editor can't update the project", &models.PolicySubject{User: editorAB}, utils.ActionUpdate, project, false},
+
+		{"project owner reads a rule of one of the projects", &models.PolicySubject{User: projectOwner}, utils.ActionRead, rule, true},
+		{"project owner can't update a rule of another project too", &models.PolicySubject{User: projectOwner}, utils.ActionUpdate, rule, false},
+		{"project owner manages the project", &models.PolicySubject{User: projectOwner}, utils.ActionManage, project, true},
+		{"project owner can't manage another project", &models.PolicySubject{User: projectOwner}, utils.ActionManage, &models.PolicyResource{Kind: utils.ResourceProject, Id: "b", Projects: []string{"b"}}, false},
+
+		{"outsider can't read the rule", &models.PolicySubject{User: outsider}, utils.ActionRead, rule, false},
+		{"unknown action is admin only", &models.PolicySubject{User: editorAB}, "export", rule, false},
+
+		// project-bound API tokens only act through their project
+		{"token of the project reads the rule", &models.PolicySubject{User: viewer, Project: "a"}, utils.ActionRead, rule, true},
+		{"token of one project can't update a rule of two", &models.PolicySubject{User: editorAB, Project: "a"}, utils.ActionUpdate, rule, false},
+		{"admin token is still bound to its project", &models.PolicySubject{User: admin, Project: "c"}, utils.ActionRead, rule, false},
+		{"owner token needs the project role", &models.PolicySubject{User: owner, Project: "a"}, utils.ActionRead, rule, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.Can(tt.subject, tt.action, tt.resource)
+			if err != nil {
+				t.Fatalf("Can() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Can() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectsAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		action    string
+		resource  []string
+		allowed   []string
+		wantAllow bool
+	}{
+		{"read with one project allowed", utils.ActionRead, []string{"a", "b"}, []string{"b"}, true},
+		{"read with no project allowed", utils.ActionRead, []string{"a", "b"}, []string{"c"}, false},
+		{"write with every project allowed", utils.ActionUpdate, []string{"a", "b"}, []string{"a", "b", "c"}, true},
+		{"write with one project missing", utils.ActionUpdate, []string{"a", "b"}, []string{"a"}, false},
+		{"read of a resource without projects", utils.ActionRead, nil, []string{"a"}, false},
+		{"write of a resource without projects", utils.ActionDelete, []string{}, []string{"a"}, false},
+		{"write without any allowed project", utils.ActionTrigger, []string{"a"}, []string{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := projectsAllowed(tt.action, tt.resource, tt.allowed); got != tt.wantAllow {
+				t.Errorf("projectsAllowed() = %v, want %v", got, tt.wantAllow)
+			}
+		})
+	}
+}
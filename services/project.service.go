@@ -0,0 +1,20 @@
+package services
+
+import (
+	"github.com/thuongnn/clst-mgt-api/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ProjectService interface {
+	GetProjects(userId string) ([]*models.DBProject, error)
+	GetProjectById(id string) (*models.DBProject, error)
+	GetProjectRoles(userId string) (map[string]string, error)
+	FindMissingProjects(names []string) ([]string, error)
+	CreateProject(input *models.CreateProjectInput, ownerId string) (*models.DBProject, error)
+	UpdateProject(id string, data *models.UpdateProject) error
+	DeleteProject(id string) error
+	SetProjectMember(id string, input *models.ProjectMemberInput) error
+	RemoveProjectMember(id string, userId string) error
+	ImportProjects(members map[string][]primitive.ObjectID) (int, error)
+	SyncManagedMemberships(userId primitive.ObjectID, projects []string) error
+}
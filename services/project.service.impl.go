@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ProjectServiceImpl struct {
+	projectCollection *mongo.Collection
+	ctx               context.Context
+}
+
+// GetProjects Find the projects the user is a member of, every project when userId is empty
+func (p ProjectServiceImpl) GetProjects(userId string) ([]*models.DBProject, error) {
+	query := bson.M{}
+	if userId != "" {
+		obId, _ := primitive.ObjectIDFromHex(userId)
+		query["members.user_id"] = obId
+	}
+
+	opt := options.Find().SetSort(bson.M{"name": 1})
+	cursor, err := p.projectCollection.Find(p.ctx, query, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(p.ctx)
+
+	projects := []*models.DBProject{}
+	for cursor.Next(p.ctx) {
+		project := &models.DBProject{}
+		if errDecode := cursor.Decode(project); errDecode != nil {
+			return nil, errDecode
+		}
+		projects = append(projects, project)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return projects, nil
+}
+
+func (p ProjectServiceImpl) GetProjectById(id string) (*models.DBProject, error) {
+	obId, _ := primitive.ObjectIDFromHex(id)
+
+	var project *models.DBProject
+	if err := p.projectCollection.FindOne(p.ctx, bson.M{"_id": obId}).Decode(&project); err != nil {
+		return nil, err
+	}
+
+	return project, nil
+}
+
+// GetProjectRoles Map the name of every project the user is a member of to the role held in it
+func (p ProjectServiceImpl) GetProjectRoles(userId string) (map[string]string, error) {
+	projects, err := p.GetProjects(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]string, len(projects))
+	for _, project := range projects {
+		for _, member := range project.Members {
+			if member.UserId.Hex() == userId {
+				roles[project.Name] = member.Role
+			}
+		}
+	}
+
+	return roles, nil
+}
+
+// FindMissingProjects Return the names that do not belong to any project
+func (p ProjectServiceImpl) FindMissingProjects(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	existing, err := p.projectCollection.Distinct(p.ctx, "name", bson.M{"name": bson.M{"$in": names}})
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, name := range names {
+		found := false
+		for _, value := range existing {
+			if value == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing, nil
+}
+
+func (p ProjectServiceImpl) CreateProject(input *models.CreateProjectInput, ownerId string) (*models.DBProject, error) {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, errors.New("invalid project name")
+	}
+
+	if err := p.ensureNameIndex(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	project := &models.DBProject{
+		Name:        name,
+		Description: input.Description,
+		Members:     []models.ProjectMember{},
+		CreateAt:    now,
+		UpdatedAt:   now,
+	}
+
+	if ownerId != "" {
+		obId, err := primitive.ObjectIDFromHex(ownerId)
+		if err != nil {
+			return nil, errors.New("invalid owner id")
+		}
+		project.Members = append(project.Members, models.ProjectMember{UserId: obId, Role: utils.ProjectOwnerRole, AddedAt: now})
+	}
+
+	res, err := p.projectCollection.InsertOne(p.ctx, project)
+	if err != nil {
+		if er, ok := err.(mongo.WriteException); ok && er.WriteErrors[0].Code == 11000 {
+			return nil, errors.New("project with that name already exist")
+		}
+		return nil, err
+	}
+
+	project.Id = res.InsertedID.(primitive.ObjectID)
+
+	return project, nil
+}
+
+func (p ProjectServiceImpl) UpdateProject(id string, data *models.UpdateProject) error {
+	data.UpdatedAt = time.Now()
+
+	obId, _ := primitive.ObjectIDFromHex(id)
+	res, err := p.projectCollection.UpdateOne(p.ctx, bson.M{"_id": obId}, bson.M{"$set": data})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return errors.New("no document with that Id exists")
+	}
+
+	return nil
+}
+
+func (p ProjectServiceImpl) DeleteProject(id string) error {
+	obId, _ := primitive.ObjectIDFromHex(id)
+
+	res, err := p.projectCollection.DeleteOne(p.ctx, bson.M{"_id": obId})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return errors.New("no document with that Id exists")
+	}
+
+	return nil
+}
+
+// SetProjectMember Add the user to the project or change the role it holds
+func (p ProjectServiceImpl) SetProjectMember(id string, input *models.ProjectMemberInput) error {
+	userId, err := primitive.ObjectIDFromHex(input.UserId)
+	if err != nil {
+		return errors.New("invalid user id")
+	}
+
+	project, err := p.GetProjectById(id)
+	if err != nil {
+		return err
+	}
+
+	members := make([]models.ProjectMember, 0, len(project.Members)+1)
+	found := false
+	for _, member := range project.Members {
+		if member.UserId == userId {
+			// an admin takes the membership over from the group mappings
+			member.Role = input.Role
+			member.Managed = false
+			found = true
+		}
+		members = append(members, member)
+	}
+
+	if !found {
+		members = append(members, models.ProjectMember{UserId: userId, Role: input.Role, AddedAt: time.Now()})
+	}
+
+	return p.saveMembers(project, members)
+}
+
+func (p ProjectServiceImpl) RemoveProjectMember(id string, userId string) error {
+	project, err := p.GetProjectById(id)
+	if err != nil {
+		return err
+	}
+
+	members := make([]models.ProjectMember, 0, len(project.Members))
+	for _, member := range project.Members {
+		if member.UserId.Hex() != userId {
+			members = append(members, member)
+		}
+	}
+
+	if len(members) == len(project.Members) {
+		return errors.New("no document with that member exists")
+	}
+
+	return p.saveMembers(project, members)
+}
+
+// saveMembers Replace the members of the project. The last owner cannot be removed or demoted, and
+// the write only applies when nobody changed the project since it was read.
+func (p ProjectServiceImpl) saveMembers(project *models.DBProject, members []models.ProjectMember) error {
+	if hasProjectOwner(project.Members) && !hasProjectOwner(members) {
+		return errors.New("invalid member change: the project needs at least one owner")
+	}
+
+	query := bson.M{"_id": project.Id, "updated_at": project.UpdatedAt}
+	update := bson.M{"$set": bson.M{"members": members, "updated_at": time.Now()}}
+
+	res, err := p.projectCollection.UpdateOne(p.ctx, query, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return errors.New("project was changed in the meantime, please try again")
+	}
+
+	return nil
+}
+
+func hasProjectOwner(members []models.ProjectMember) bool {
+	for _, member := range members {
+		if member.Role == utils.ProjectOwnerRole {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImportProjects Create a project for every name that has none yet, with the given users as editors.
+// Existing projects are left untouched, it returns how many were created.
+func (p ProjectServiceImpl) ImportProjects(members map[string][]primitive.ObjectID) (int, error) {
+	if err := p.ensureNameIndex(); err != nil {
+		return 0, err
+	}
+
+	created := 0
+	now := time.Now()
+	for name, userIds := range members {
+		projectMembers := []models.ProjectMember{}
+		for _, userId := range userIds {
+			projectMembers = append(projectMembers, models.ProjectMember{UserId: userId, Role: utils.ProjectEditorRole, AddedAt: now})
+		}
+
+		update := bson.M{"$setOnInsert": bson.M{
+			"name":        name,
+			"description": "Imported from the projects of existing rules",
+			"members":     projectMembers,
+			"created_at":  now,
+			"updated_at":  now,
+		}}
+
+		res, err := p.projectCollection.UpdateOne(p.ctx, bson.M{"name": name}, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return created, err
+		}
+		created += int(res.UpsertedCount)
+	}
+
+	return created, nil
+}
+
+// SyncManagedMemberships Make the user a viewer of the projects granted by the group mappings and
+// drop the managed memberships that are no longer granted. Memberships an admin set are left alone.
+func (p ProjectServiceImpl) SyncManagedMemberships(userId primitive.ObjectID, projects []string) error {
+	if projects == nil {
+		projects = []string{}
+	}
+	now := time.Now()
+
+	revoke := bson.M{"$pull": bson.M{"members": bson.M{"user_id": userId, "managed": true}}, "$set": bson.M{"updated_at": now}}
+	query := bson.M{"name": bson.M{"$nin": projects}, "members": bson.M{"$elemMatch": bson.M{"user_id": userId, "managed": true}}}
+	if _, err := p.projectCollection.UpdateMany(p.ctx, query, revoke); err != nil {
+		return err
+	}
+
+	member := models.ProjectMember{UserId: userId, Role: utils.ProjectViewerRole, AddedAt: now, Managed: true}
+	grant := bson.M{"$push": bson.M{"members": member}, "$set": bson.M{"updated_at": now}}
+	query = bson.M{"name": bson.M{"$in": projects}, "members.user_id": bson.M{"$ne": userId}}
+	_, err := p.projectCollection.UpdateMany(p.ctx, query, grant)
+	return err
+}
+
+func (p ProjectServiceImpl) ensureNameIndex() error {
+	opt := options.Index()
+	opt.SetUnique(true)
+	index := mongo.IndexModel{Keys: bson.M{"name": 1}, Options: opt}
+
+	if _, err := p.projectCollection.Indexes().CreateOne(p.ctx, index); err != nil {
+		return errors.New("could not create index for name")
+	}
+
+	return nil
+}
+
+func NewProjectService(projectCollection *mongo.Collection, ctx context.Context) ProjectService {
+	return &ProjectServiceImpl{projectCollection, ctx}
+}
@@ -0,0 +1,10 @@
+package services
+
+import "time"
+
+type RateLimitService interface {
+	// Allow Count one hit on key and report whether it is still within limit for the window,
+	// along with how long to wait when it isn't
+	Allow(key string, limit int64, window time.Duration) (bool, time.Duration, error)
+	Reset(key string) error
+}
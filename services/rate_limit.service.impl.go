@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+type RateLimitServiceImpl struct {
+	redisClient *redis.Client
+	ctx         context.Context
+}
+
+func rateLimitKey(key string) string {
+	return "rate_limit:" + key
+}
+
+func (r RateLimitServiceImpl) Allow(key string, limit int64, window time.Duration) (bool, time.Duration, error) {
+	redisKey := rateLimitKey(key)
+
+	count, err := r.redisClient.Incr(r.ctx, redisKey).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	// the window starts with the first hit
+	if count == 1 {
+		if err := r.redisClient.Expire(r.ctx, redisKey, window).Err(); err != nil {
+			return false, 0, err
+		}
+	}
+
+	if count <= limit {
+		return true, 0, nil
+	}
+
+	retryAfter, err := r.redisClient.TTL(r.ctx, redisKey).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	return false, retryAfter, nil
+}
+
+func (r RateLimitServiceImpl) Reset(key string) error {
+	return r.redisClient.Del(r.ctx, rateLimitKey(key)).Err()
+}
+
+func NewRateLimitService(redisClient *redis.Client, ctx context.Context) RateLimitService {
+	return &RateLimitServiceImpl{redisClient, ctx}
+}
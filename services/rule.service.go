@@ -5,11 +5,14 @@ import (
 )
 
 type RuleService interface {
-	GetProjects() ([]string, error)
+	GetProjectOwners() (map[string][]string, error)
 	GetRules(params *models.RuleSearchParams) (*models.RuleListResponse, error)
 	GetRuleById(id string) (*models.DBRule, error)
 	GetRulesByRoles(roles []string) ([]*models.DBRule, error)
 	GetRulesByIdsAndRoles(ids []string, roles []string) ([]*models.DBRule, error)
+	CountRulesByCredentialId(credentialId string) (int64, error)
+	CountRulesByProject(project string) (int64, error)
+	GetRuleIdsByScope(scope *models.PolicyScope) ([]string, error)
 	CreateRule(rule *models.DBRule) error
 	UpdateRule(id string, rule *models.UpdateRule) error
 	DeleteRule(id string) error
@@ -3,7 +3,6 @@ package services
 import (
 	"context"
 	"errors"
-	"fmt"
 	"github.com/thuongnn/clst-mgt-api/models"
 	"github.com/thuongnn/clst-mgt-api/utils"
 	"go.mongodb.org/mongo-driver/bson"
@@ -21,21 +20,16 @@ type RuleServiceImpl struct {
 	ctx            context.Context
 }
 
-func (r RuleServiceImpl) GetProjects() ([]string, error) {
+// GetProjectOwners Map every project name used by rules to the owners of its rules
+func (r RuleServiceImpl) GetProjectOwners() (map[string][]string, error) {
 	pipeline := []bson.M{
 		{
 			"$unwind": "$projects",
 		},
 		{
 			"$group": bson.M{
-				"_id":      nil,
-				"projects": bson.M{"$addToSet": "$projects"},
-			},
-		},
-		{
-			"$project": bson.M{
-				"_id":      0,
-				"projects": 1,
+				"_id":    "$projects",
+				"owners": bson.M{"$addToSet": "$owner"},
 			},
 		},
 	}
@@ -46,31 +40,26 @@ func (r RuleServiceImpl) GetProjects() ([]string, error) {
 	}
 	defer cursor.Close(r.ctx)
 
-	var result bson.M
-	if cursor.Next(r.ctx) {
-		errDecode := cursor.Decode(&result)
-		if errDecode != nil {
-			return []string{}, errDecode
+	owners := map[string][]string{}
+	for cursor.Next(r.ctx) {
+		var result struct {
+			Project string   `bson:"_id"`
+			Owners  []string `bson:"owners"`
+		}
+		if errDecode := cursor.Decode(&result); errDecode != nil {
+			return nil, errDecode
 		}
-	} else {
-		return []string{}, nil
-	}
 
-	projectsRaw, ok := result["projects"].(primitive.A)
-	if !ok {
-		return []string{}, fmt.Errorf("Conversion to []interface{} failed. ")
+		if strings.TrimSpace(result.Project) != "" {
+			owners[result.Project] = result.Owners
+		}
 	}
 
-	var projects []string
-	for _, project := range projectsRaw {
-		if projectString, ok := project.(string); ok {
-			projects = append(projects, projectString)
-		} else {
-			return []string{}, fmt.Errorf("Conversion to string failed for role: ")
-		}
+	if err := cursor.Err(); err != nil {
+		return nil, err
 	}
 
-	return projects, nil
+	return owners, nil
 }
 
 func buildFilter(params *models.RuleSearchParams) bson.M {
@@ -97,9 +86,23 @@ func buildFilter(params *models.RuleSearchParams) bson.M {
 		filter["projects"] = bson.M{"$regex": params.ProjectKeyword, "$options": "i"}
 	}
 
+	if params.Scope != nil {
+		filter["$or"] = scopeFilter(params.Scope)
+	}
+
 	return filter
 }
 
+// scopeFilter Match the rules of the scope projects and the rules the scope owner owns or co-owns
+func scopeFilter(scope *models.PolicyScope) bson.A {
+	conditions := bson.A{bson.M{"projects": bson.M{"$in": scope.Projects}}}
+	if scope.Owner != "" {
+		conditions = append(conditions, bson.M{"owner": scope.Owner}, bson.M{"co_owners": scope.Owner})
+	}
+
+	return conditions
+}
+
 func (r RuleServiceImpl) GetRules(params *models.RuleSearchParams) (*models.RuleListResponse, error) {
 	// Set default values for page and limit
 	page := params.CurrentPage
@@ -251,11 +254,46 @@ func (r RuleServiceImpl) GetRulesByIdsAndRoles(ids []string, roles []string) ([]
 	return rules, nil
 }
 
+func (r RuleServiceImpl) CountRulesByCredentialId(credentialId string) (int64, error) {
+	return r.ruleCollection.CountDocuments(r.ctx, bson.M{"credential_id": credentialId})
+}
+
+func (r RuleServiceImpl) CountRulesByProject(project string) (int64, error) {
+	return r.ruleCollection.CountDocuments(r.ctx, bson.M{"projects": project})
+}
+
+func (r RuleServiceImpl) GetRuleIdsByScope(scope *models.PolicyScope) ([]string, error) {
+	opt := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := r.ruleCollection.Find(r.ctx, bson.M{"$or": scopeFilter(scope)}, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(r.ctx)
+
+	ruleIds := []string{}
+	for cursor.Next(r.ctx) {
+		var rule = &models.DBRule{}
+		if errDecode := cursor.Decode(rule); errDecode != nil {
+			return nil, errDecode
+		}
+		ruleIds = append(ruleIds, rule.Id.Hex())
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return ruleIds, nil
+}
+
 func (r RuleServiceImpl) CreateRule(rule *models.DBRule) error {
 	rule.CreateAt = time.Now()
 	rule.UpdatedAt = rule.CreateAt
 	rule.Status = utils.StatusUnknown
 	rule.IsActive = true
+	if rule.Type == "" {
+		rule.Type = utils.StandardRule
+	}
 
 	_, err := r.ruleCollection.InsertOne(r.ctx, rule)
 	return err
@@ -0,0 +1,15 @@
+package services
+
+import (
+	"crypto/rsa"
+
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+)
+
+type SigningKeyService interface {
+	GetSigner(use string) (*utils.TokenSigner, error)
+	GetVerificationKey(use string, kid string) (*rsa.PublicKey, error)
+	GetJWKS() (*models.JSONWebKeySet, error)
+	RotateSigningKeys(force bool) error
+}
@@ -0,0 +1,309 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/thuongnn/clst-mgt-api/config"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// signingKeySet is the cached state of the keys of one use
+type signingKeySet struct {
+	signer     *utils.TokenSigner
+	kids       []string
+	publicKeys map[string]*rsa.PublicKey
+	newestAt   time.Time
+	fetchedAt  time.Time
+}
+
+type SigningKeyServiceImpl struct {
+	collection *mongo.Collection
+	ctx        context.Context
+
+	mu      sync.Mutex
+	keySets map[string]*signingKeySet
+}
+
+func NewSigningKeyService(collection *mongo.Collection, ctx context.Context) SigningKeyService {
+	return &SigningKeyServiceImpl{collection: collection, ctx: ctx, keySets: map[string]*signingKeySet{}}
+}
+
+func (s *SigningKeyServiceImpl) GetSigner(use string) (*utils.TokenSigner, error) {
+	keySet, err := s.keySet(use, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return keySet.signer, nil
+}
+
+func (s *SigningKeyServiceImpl) GetVerificationKey(use string, kid string) (*rsa.PublicKey, error) {
+	keySet, err := s.keySet(use, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// another instance may have rotated since the keys were cached
+	if _, ok := keySet.publicKeys[kid]; !ok && kid != "" && time.Since(keySet.fetchedAt) > utils.SigningKeyMinRefreshPeriod {
+		if keySet, err = s.keySet(use, true); err != nil {
+			return nil, err
+		}
+	}
+
+	key, ok := keySet.publicKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+func (s *SigningKeyServiceImpl) GetJWKS() (*models.JSONWebKeySet, error) {
+	keySet, err := s.keySet(utils.AccessTokenKeyUse, false)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := &models.JSONWebKeySet{Keys: []models.JSONWebKey{}}
+	for _, kid := range keySet.kids {
+		jwks.Keys = append(jwks.Keys, utils.PublicJSONWebKey(kid, keySet.publicKeys[kid]))
+	}
+
+	return jwks, nil
+}
+
+func (s *SigningKeyServiceImpl) RotateSigningKeys(force bool) error {
+	appConfig, _ := config.LoadConfig(".")
+	period := appConfig.SigningKeyRotationPeriod
+	if period <= 0 {
+		period = config.DefaultSigningKeyRotationPeriod
+	}
+
+	ttls := map[string]time.Duration{
+		utils.AccessTokenKeyUse:  appConfig.AccessTokenExpiresIn,
+		utils.RefreshTokenKeyUse: appConfig.RefreshTokenExpiresIn,
+	}
+
+	for use, ttl := range ttls {
+		keySet, err := s.keySet(use, true)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if force || now.Sub(keySet.newestAt) >= period {
+			key, err := utils.GenerateSigningKey()
+			if err != nil {
+				return err
+			}
+
+			signingKey, err := s.storeSigningKey(use, key, false)
+			if err != nil {
+				return err
+			}
+
+			// the old keys still sign until the new one is published, then verify what they signed.
+			// Only keys older than ours expire, when replicas rotate at once the newest key survives.
+			expiresAt := now.Add(utils.SigningKeyPublishDelay + utils.SigningKeyCacheTTL + ttl)
+			filter := bson.M{
+				"use":        use,
+				"kid":        bson.M{"$ne": signingKey.Kid},
+				"created_at": bson.M{"$lt": signingKey.CreatedAt},
+				"expires_at": bson.M{"$exists": false},
+			}
+			update := bson.M{"$set": bson.M{"expires_at": expiresAt}}
+			if _, err := s.collection.UpdateMany(s.ctx, filter, update); err != nil {
+				return err
+			}
+		}
+
+		if _, err := s.collection.DeleteMany(s.ctx, bson.M{"use": use, "expires_at": bson.M{"$lte": now}}); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		delete(s.keySets, use)
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// keySet Get the cached keys of the use, they are reloaded when stale or when reload is set
+func (s *SigningKeyServiceImpl) keySet(use string, reload bool) (*signingKeySet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if keySet, ok := s.keySets[use]; ok && !reload && time.Since(keySet.fetchedAt) < utils.SigningKeyCacheTTL {
+		return keySet, nil
+	}
+
+	signingKeys, err := s.findSigningKeys(use)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(signingKeys) == 0 {
+		if _, err := s.bootstrapSigningKey(use); err != nil {
+			return nil, err
+		}
+
+		if signingKeys, err = s.findSigningKeys(use); err != nil {
+			return nil, err
+		}
+		if len(signingKeys) == 0 {
+			return nil, fmt.Errorf("no signing key for %s tokens", use)
+		}
+	}
+
+	keySet := &signingKeySet{publicKeys: map[string]*rsa.PublicKey{}, newestAt: signingKeys[0].CreatedAt, fetchedAt: time.Now()}
+
+	// the newest key that had time to be published signs, right after bootstrap that is the only one
+	current := signingKeys[len(signingKeys)-1]
+	for _, signingKey := range signingKeys {
+		if time.Since(signingKey.CreatedAt) >= utils.SigningKeyPublishDelay {
+			current = signingKey
+			break
+		}
+	}
+
+	for _, signingKey := range signingKeys {
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(signingKey.PublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse signing key %s: %w", signingKey.Kid, err)
+		}
+
+		keySet.kids = append(keySet.kids, signingKey.Kid)
+		keySet.publicKeys[signingKey.Kid] = publicKey
+	}
+
+	privateKeyPEM, err := utils.DecryptSecret(current.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt signing key %s: %w", current.Kid, err)
+	}
+
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(privateKeyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signing key %s: %w", current.Kid, err)
+	}
+	keySet.signer = &utils.TokenSigner{Kid: current.Kid, Key: privateKey}
+
+	s.keySets[use] = keySet
+	return keySet, nil
+}
+
+// findSigningKeys Get the keys of the use that still verify, newest first
+func (s *SigningKeyServiceImpl) findSigningKeys(use string) ([]*models.SigningKey, error) {
+	filter := bson.M{"use": use, "$or": []bson.M{
+		{"expires_at": bson.M{"$exists": false}},
+		{"expires_at": bson.M{"$gt": time.Now()}},
+	}}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := s.collection.Find(s.ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(s.ctx)
+
+	var signingKeys []*models.SigningKey
+	if err := cursor.All(s.ctx, &signingKeys); err != nil {
+		return nil, err
+	}
+
+	return signingKeys, nil
+}
+
+// bootstrapSigningKey Create the first key of the use, the key from env is imported
+// so it keeps signing until the first rotation
+func (s *SigningKeyServiceImpl) bootstrapSigningKey(use string) (*models.SigningKey, error) {
+	appConfig, _ := config.LoadConfig(".")
+	encodedKey := appConfig.AccessTokenPrivateKey
+	if use == utils.RefreshTokenKeyUse {
+		encodedKey = appConfig.RefreshTokenPrivateKey
+	}
+
+	if encodedKey != "" {
+		key, err := utils.ParseBase64SigningKey(encodedKey)
+		if err != nil {
+			return nil, err
+		}
+		return s.storeSigningKey(use, key, true)
+	}
+
+	key, err := utils.GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	return s.storeSigningKey(use, key, false)
+}
+
+func (s *SigningKeyServiceImpl) storeSigningKey(use string, key *rsa.PrivateKey, legacy bool) (*models.SigningKey, error) {
+	privateKeyPEM, publicKeyPEM, err := utils.EncodeSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := utils.EncryptSecret(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey := &models.SigningKey{
+		Kid:        utils.SigningKeyId(&key.PublicKey),
+		Use:        use,
+		PrivateKey: encryptedKey,
+		PublicKey:  publicKeyPEM,
+		Legacy:     legacy,
+		CreatedAt:  time.Now(),
+	}
+
+	if err := s.ensureKidIndex(); err != nil {
+		return nil, err
+	}
+
+	_, err = s.collection.InsertOne(s.ctx, signingKey)
+	if er, ok := err.(mongo.WriteException); ok && er.WriteErrors[0].Code == 11000 {
+		// another instance imported the same env key for the same use at the same time
+		var stored *models.SigningKey
+		if findErr := s.collection.FindOne(s.ctx, bson.M{"use": use, "kid": signingKey.Kid}).Decode(&stored); findErr != nil {
+			return nil, err
+		}
+		return stored, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return signingKey, nil
+}
+
+// ensureKidIndex A kid is unique per use only, the same env key may sign access and refresh tokens.
+// The index on kid alone from before is dropped, with it the refresh key of such a setup was lost.
+func (s *SigningKeyServiceImpl) ensureKidIndex() error {
+	if _, err := s.collection.Indexes().DropOne(s.ctx, "kid_1"); err != nil {
+		// the index or the whole collection doesn't exist
+		if er, ok := err.(mongo.CommandError); !ok || (er.Code != 26 && er.Code != 27) {
+			return fmt.Errorf("could not drop the index for kid: %w", err)
+		}
+	}
+
+	opt := options.Index()
+	opt.SetUnique(true)
+	index := mongo.IndexModel{Keys: bson.D{{Key: "use", Value: 1}, {Key: "kid", Value: 1}}, Options: opt}
+	if _, err := s.collection.Indexes().CreateOne(s.ctx, index); err != nil {
+		return errors.New("could not create index for use and kid")
+	}
+
+	return nil
+}
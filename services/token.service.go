@@ -0,0 +1,18 @@
+package services
+
+import (
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+)
+
+type TokenService interface {
+	ValidateAccessToken(token string) (*utils.TokenDetails, error)
+	ValidateRefreshToken(token string) (*utils.TokenDetails, error)
+	CreateSession(userId string, metadata *models.SessionMetadata) (*utils.TokenDetails, *utils.TokenDetails, error)
+	RotateSession(refreshToken *utils.TokenDetails, metadata *models.SessionMetadata) (*utils.TokenDetails, *utils.TokenDetails, error)
+	IsSessionActive(sessionId string) (bool, error)
+	GetUserSessions(userId string) ([]*models.Session, error)
+	RevokeSession(sessionId string) error
+	RevokeUserSession(userId string, sessionId string) error
+	RevokeUserSessions(userId string) error
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/thuongnn/clst-mgt-api/config"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+	"strconv"
+	"time"
+)
+
+// A session is a refresh token family: every rotation replaces the current jti,
+// presenting any older refresh token of the family revokes the whole session.
+var rotateSessionScript = redis.NewScript(`
+local current = redis.call("HGET", KEYS[1], "refresh_jti")
+if not current then
+	return 0
+end
+if current ~= ARGV[1] then
+	redis.call("DEL", KEYS[1])
+	redis.call("SREM", KEYS[2], ARGV[3])
+	return -1
+end
+redis.call("HSET", KEYS[1], "refresh_jti", ARGV[2], "ip_address", ARGV[5], "user_agent", ARGV[6], "last_refresh_at", ARGV[7])
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+return 1
+`)
+
+type TokenServiceImpl struct {
+	redisClient       *redis.Client
+	signingKeyService SigningKeyService
+	ctx               context.Context
+}
+
+func sessionKey(sessionId string) string {
+	return "auth:session:" + sessionId
+}
+
+func userSessionsKey(userId string) string {
+	return "auth:user_sessions:" + userId
+}
+
+func (t TokenServiceImpl) createTokens(userId string, sessionId string) (*utils.TokenDetails, *utils.TokenDetails, error) {
+	appConfig, _ := config.LoadConfig(".")
+
+	accessSigner, err := t.signingKeyService.GetSigner(utils.AccessTokenKeyUse)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshSigner, err := t.signingKeyService.GetSigner(utils.RefreshTokenKeyUse)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accessToken, err := utils.CreateToken(appConfig.AccessTokenExpiresIn, userId, sessionId, accessSigner)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := utils.CreateToken(appConfig.RefreshTokenExpiresIn, userId, sessionId, refreshSigner)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+func (t TokenServiceImpl) ValidateAccessToken(token string) (*utils.TokenDetails, error) {
+	return utils.ValidateToken(token, func(kid string) (*rsa.PublicKey, error) {
+		return t.signingKeyService.GetVerificationKey(utils.AccessTokenKeyUse, kid)
+	})
+}
+
+func (t TokenServiceImpl) ValidateRefreshToken(token string) (*utils.TokenDetails, error) {
+	return utils.ValidateToken(token, func(kid string) (*rsa.PublicKey, error) {
+		return t.signingKeyService.GetVerificationKey(utils.RefreshTokenKeyUse, kid)
+	})
+}
+
+func (t TokenServiceImpl) CreateSession(userId string, metadata *models.SessionMetadata) (*utils.TokenDetails, *utils.TokenDetails, error) {
+	sessionId, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accessToken, refreshToken, err := t.createTokens(userId, sessionId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ttl := time.Until(refreshToken.ExpiresAt)
+	pipe := t.redisClient.TxPipeline()
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	pipe.HSet(t.ctx, sessionKey(sessionId),
+		"user_id", userId,
+		"refresh_jti", refreshToken.TokenId,
+		"ip_address", metadata.IPAddress,
+		"user_agent", metadata.UserAgent,
+		"auth_method", metadata.AuthMethod,
+		"created_at", now,
+		"last_refresh_at", now,
+	)
+	pipe.Expire(t.ctx, sessionKey(sessionId), ttl)
+	pipe.SAdd(t.ctx, userSessionsKey(userId), sessionId)
+	pipe.Expire(t.ctx, userSessionsKey(userId), ttl)
+	if _, err := pipe.Exec(t.ctx); err != nil {
+		return nil, nil, err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+func (t TokenServiceImpl) RotateSession(refreshToken *utils.TokenDetails, metadata *models.SessionMetadata) (*utils.TokenDetails, *utils.TokenDetails, error) {
+	if refreshToken.SessionId == "" || refreshToken.TokenId == "" {
+		return nil, nil, errors.New("refresh token is not bound to a session")
+	}
+
+	accessToken, newRefreshToken, err := t.createTokens(refreshToken.Subject, refreshToken.SessionId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := []string{sessionKey(refreshToken.SessionId), userSessionsKey(refreshToken.Subject)}
+	ttl := time.Until(newRefreshToken.ExpiresAt).Milliseconds()
+	args := []interface{}{
+		refreshToken.TokenId, newRefreshToken.TokenId, refreshToken.SessionId, ttl,
+		metadata.IPAddress, metadata.UserAgent, time.Now().Unix(),
+	}
+	res, err := rotateSessionScript.Run(t.ctx, t.redisClient, keys, args...).Int()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch res {
+	case 0:
+		return nil, nil, errors.New("session has been revoked")
+	case -1:
+		return nil, nil, errors.New("refresh token reuse detected, session has been revoked")
+	}
+
+	t.redisClient.Expire(t.ctx, userSessionsKey(refreshToken.Subject), time.Duration(ttl)*time.Millisecond)
+
+	return accessToken, newRefreshToken, nil
+}
+
+func (t TokenServiceImpl) IsSessionActive(sessionId string) (bool, error) {
+	if sessionId == "" {
+		return false, nil
+	}
+
+	count, err := t.redisClient.Exists(t.ctx, sessionKey(sessionId)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (t TokenServiceImpl) GetUserSessions(userId string) ([]*models.Session, error) {
+	sessionIds, err := t.redisClient.SMembers(t.ctx, userSessionsKey(userId)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []*models.Session{}
+	for _, sessionId := range sessionIds {
+		fields, err := t.redisClient.HGetAll(t.ctx, sessionKey(sessionId)).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		// the session expired on its own, forget about it
+		if len(fields) == 0 {
+			t.redisClient.SRem(t.ctx, userSessionsKey(userId), sessionId)
+			continue
+		}
+
+		sessions = append(sessions, &models.Session{
+			Id:            sessionId,
+			UserId:        fields["user_id"],
+			IPAddress:     fields["ip_address"],
+			UserAgent:     fields["user_agent"],
+			AuthMethod:    fields["auth_method"],
+			CreatedAt:     parseUnixField(fields["created_at"]),
+			LastRefreshAt: parseUnixField(fields["last_refresh_at"]),
+		})
+	}
+
+	return sessions, nil
+}
+
+func parseUnixField(value string) time.Time {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+func (t TokenServiceImpl) RevokeUserSession(userId string, sessionId string) error {
+	owner, err := t.redisClient.HGet(t.ctx, sessionKey(sessionId), "user_id").Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	if owner != userId {
+		return errors.New("no session with that Id exists")
+	}
+
+	return t.RevokeSession(sessionId)
+}
+
+func (t TokenServiceImpl) RevokeSession(sessionId string) error {
+	userId, err := t.redisClient.HGet(t.ctx, sessionKey(sessionId), "user_id").Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil
+		}
+		return err
+	}
+
+	pipe := t.redisClient.TxPipeline()
+	pipe.Del(t.ctx, sessionKey(sessionId))
+	pipe.SRem(t.ctx, userSessionsKey(userId), sessionId)
+	_, err = pipe.Exec(t.ctx)
+	return err
+}
+
+func (t TokenServiceImpl) RevokeUserSessions(userId string) error {
+	sessionIds, err := t.redisClient.SMembers(t.ctx, userSessionsKey(userId)).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{userSessionsKey(userId)}
+	for _, sessionId := range sessionIds {
+		keys = append(keys, sessionKey(sessionId))
+	}
+
+	return t.redisClient.Del(t.ctx, keys...).Err()
+}
+
+func NewTokenService(redisClient *redis.Client, signingKeyService SigningKeyService, ctx context.Context) TokenService {
+	return &TokenServiceImpl{redisClient, signingKeyService, ctx}
+}
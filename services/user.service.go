@@ -5,7 +5,12 @@ import "github.com/thuongnn/clst-mgt-api/models"
 type UserService interface {
 	FindUsers(params *models.UserSearchParams) (*models.UserListResponse, error)
 	FindUserById(id string) (*models.UserDBResponse, error)
+	FindUserWithSecretsById(id string) (*models.UserDBResponse, error)
 	FindUserByUsername(username string) (*models.UserDBResponse, error)
 	FindUserByEmail(email string) (*models.UserDBResponse, error)
 	UpdateUserById(id string, data *models.UserUpdate) error
+	ApproveUser(id string) error
+	CountActiveAdmins() (int64, error)
+	DeleteUserById(id string) error
+	AnonymizeUserById(id string) error
 }
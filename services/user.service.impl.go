@@ -2,11 +2,13 @@ package services
 
 import (
 	"context"
+	"errors"
 	"math"
 	"strings"
 	"time"
 
 	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -15,6 +17,7 @@ import (
 
 type UserServiceImpl struct {
 	userCollection *mongo.Collection
+	userCache      UserCacheService
 	ctx            context.Context
 }
 
@@ -34,8 +37,8 @@ func (us *UserServiceImpl) FindUserByUsername(username string) (*models.UserDBRe
 	return user, nil
 }
 
-func NewUserServiceImpl(collection *mongo.Collection, ctx context.Context) UserService {
-	return &UserServiceImpl{collection, ctx}
+func NewUserServiceImpl(collection *mongo.Collection, userCache UserCacheService, ctx context.Context) UserService {
+	return &UserServiceImpl{collection, userCache, ctx}
 }
 
 func (us UserServiceImpl) FindUsers(params *models.UserSearchParams) (*models.UserListResponse, error) {
@@ -61,6 +64,14 @@ func (us UserServiceImpl) FindUsers(params *models.UserSearchParams) (*models.Us
 		filter["email"] = bson.M{"$regex": params.EmailKeyword, "$options": "i"}
 	}
 
+	if params.PendingApproval != nil {
+		if *params.PendingApproval {
+			filter["pending_approval"] = true
+		} else {
+			filter["pending_approval"] = bson.M{"$ne": true}
+		}
+	}
+
 	// 👇 Calculate the total number of pages
 	count, err := us.userCollection.CountDocuments(us.ctx, filter)
 	if err != nil {
@@ -125,7 +136,16 @@ func (us UserServiceImpl) FindUsers(params *models.UserSearchParams) (*models.Us
 	}, nil
 }
 
+// FindUserById is called for every authenticated request, the user is cached until it changes.
+// The password hashes and MFA secrets are left out, FindUserWithSecretsById reads them.
 func (us *UserServiceImpl) FindUserById(id string) (*models.UserDBResponse, error) {
+	return us.userCache.GetUser(id, func() (*models.UserDBResponse, error) {
+		return us.FindUserWithSecretsById(id)
+	})
+}
+
+// FindUserWithSecretsById Read the whole user from Mongo, for the handlers that check a password or an MFA code
+func (us *UserServiceImpl) FindUserWithSecretsById(id string) (*models.UserDBResponse, error) {
 	oid, _ := primitive.ObjectIDFromHex(id)
 
 	var user *models.UserDBResponse
@@ -160,13 +180,162 @@ func (us *UserServiceImpl) FindUserByEmail(email string) (*models.UserDBResponse
 }
 
 func (us *UserServiceImpl) UpdateUserById(id string, data *models.UserUpdate) error {
+	if data.Role != "" && data.Role != utils.AdminRole && data.Role != utils.UserRole {
+		return errors.New("invalid role, must be admin or user")
+	}
+
 	obId, _ := primitive.ObjectIDFromHex(id)
+
+	// an admin losing the role or being disabled must not leave the instance without admins
+	if (data.Role != "" && data.Role != utils.AdminRole) || (data.IsActive != nil && !*data.IsActive) {
+		if err := us.ensureNotLastAdmin(obId); err != nil {
+			return err
+		}
+	}
+
+	set := bson.D{{Key: "updated_at", Value: time.Now()}}
+	if data.Role != "" {
+		set = append(set, bson.E{Key: "role", Value: data.Role})
+	}
+	if data.RoleOverride != nil {
+		set = append(set, bson.E{Key: "role_override", Value: *data.RoleOverride})
+	} else if data.Role != "" {
+		set = append(set, bson.E{Key: "role_override", Value: true})
+	}
+	if data.IsActive != nil {
+		set = append(set, bson.E{Key: "is_active", Value: *data.IsActive})
+	}
+
 	updateQuery := bson.D{{Key: "_id", Value: obId}}
-	updateData := bson.D{{"$set", bson.D{{"is_active", data.IsActive}, {"updated_at", time.Now()}}}}
+	updateData := bson.D{{Key: "$set", Value: set}}
 	res := us.userCollection.FindOneAndUpdate(us.ctx, updateQuery, updateData)
 	if res.Err() != nil {
 		return res.Err()
 	}
 
+	return us.userCache.InvalidateUser(id)
+}
+
+// ensureNotLastAdmin Fail when the user is the only active admin left
+func (us *UserServiceImpl) ensureNotLastAdmin(obId primitive.ObjectID) error {
+	var user *models.UserDBResponse
+	if err := us.userCollection.FindOne(us.ctx, bson.M{"_id": obId}).Decode(&user); err != nil {
+		return err
+	}
+
+	if user.Role != utils.AdminRole || !user.IsActive {
+		return nil
+	}
+
+	count, err := us.userCollection.CountDocuments(us.ctx, bson.M{
+		"_id":       bson.M{"$ne": obId},
+		"role":      utils.AdminRole,
+		"is_active": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return errors.New("invalid request: at least one active admin is required")
+	}
+
 	return nil
 }
+
+func (us *UserServiceImpl) CountActiveAdmins() (int64, error) {
+	return us.userCollection.CountDocuments(us.ctx, bson.M{"role": utils.AdminRole, "is_active": true})
+}
+
+func (us *UserServiceImpl) DeleteUserById(id string) error {
+	obId, _ := primitive.ObjectIDFromHex(id)
+	if err := us.ensureNotLastAdmin(obId); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errors.New("no document with that Id exists")
+		}
+		return err
+	}
+
+	res, err := us.userCollection.DeleteOne(us.ctx, bson.M{"_id": obId})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return errors.New("no document with that Id exists")
+	}
+
+	return us.userCache.InvalidateUser(id)
+}
+
+// AnonymizeUserById Keep the document for the references to it, but drop everything personal
+// and every credential, the account can't be used anymore
+func (us *UserServiceImpl) AnonymizeUserById(id string) error {
+	obId, _ := primitive.ObjectIDFromHex(id)
+	if err := us.ensureNotLastAdmin(obId); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errors.New("no document with that Id exists")
+		}
+		return err
+	}
+
+	now := time.Now()
+	placeholder := "deleted-" + id
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "name", Value: "Deleted user"},
+			{Key: "username", Value: placeholder},
+			{Key: "email", Value: placeholder + "@invalid"},
+			{Key: "role", Value: utils.UserRole},
+			{Key: "is_active", Value: false},
+			{Key: "verified", Value: false},
+			{Key: "mfa_enabled", Value: false},
+			{Key: "anonymized_at", Value: now},
+			{Key: "updated_at", Value: now},
+		}},
+		{Key: "$unset", Value: bson.D{
+			{Key: "password", Value: ""},
+			{Key: "projects", Value: ""},
+			{Key: "verification_code", Value: ""},
+			{Key: "verification_code_expires_at", Value: ""},
+			{Key: "password_reset_token", Value: ""},
+			{Key: "password_reset_at", Value: ""},
+			{Key: "mfa_secret", Value: ""},
+			{Key: "mfa_pending_secret", Value: ""},
+			{Key: "mfa_recovery_codes", Value: ""},
+			{Key: "mfa_last_counter", Value: ""},
+			{Key: "pending_approval", Value: ""},
+		}},
+	}
+
+	res, err := us.userCollection.UpdateOne(us.ctx, bson.M{"_id": obId}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return errors.New("no document with that Id exists")
+	}
+
+	return us.userCache.InvalidateUser(id)
+}
+
+func (us *UserServiceImpl) ApproveUser(id string) error {
+	obId, _ := primitive.ObjectIDFromHex(id)
+	query := bson.D{{Key: "_id", Value: obId}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+		{Key: "$unset", Value: bson.D{{Key: "pending_approval", Value: ""}}},
+	}
+
+	res, err := us.userCollection.UpdateOne(us.ctx, query, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return errors.New("no document with that Id exists")
+	}
+
+	return us.userCache.InvalidateUser(id)
+}
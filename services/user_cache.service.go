@@ -0,0 +1,8 @@
+package services
+
+import "github.com/thuongnn/clst-mgt-api/models"
+
+type UserCacheService interface {
+	GetUser(userId string, load func() (*models.UserDBResponse, error)) (*models.UserDBResponse, error)
+	InvalidateUser(userId string) error
+}
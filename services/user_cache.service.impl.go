@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/thuongnn/clst-mgt-api/models"
+	"github.com/thuongnn/clst-mgt-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The user is only cached when no invalidation happened while it was loaded,
+// otherwise a slow read could put back the state from before a deactivation.
+var setCachedUserScript = redis.NewScript(`
+local generation = redis.call("GET", KEYS[2]) or "0"
+if generation ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+return 1
+`)
+
+type UserCacheServiceImpl struct {
+	redisClient *redis.Client
+	ctx         context.Context
+}
+
+func cachedUserKey(userId string) string {
+	return "auth:user:" + userId
+}
+
+func userCacheGenerationKey(userId string) string {
+	return "auth:user_cache_generation:" + userId
+}
+
+func (u UserCacheServiceImpl) GetUser(userId string, load func() (*models.UserDBResponse, error)) (*models.UserDBResponse, error) {
+	// only the trimmed user is cached, hit or miss the caller gets no secrets
+	data, err := u.redisClient.Get(u.ctx, cachedUserKey(userId)).Bytes()
+	if err == nil {
+		var cached *models.CachedUser
+		if err := bson.Unmarshal(data, &cached); err == nil {
+			return cached.User(), nil
+		}
+	} else if err != redis.Nil {
+		log.Printf("could not read cached user %s: %v", userId, err)
+	}
+
+	generation, err := u.redisClient.Get(u.ctx, userCacheGenerationKey(userId)).Result()
+	if err == redis.Nil {
+		generation = "0"
+	} else if err != nil {
+		log.Printf("could not read cache generation of user %s: %v", userId, err)
+		generation = ""
+	}
+
+	user, err := load()
+	if err != nil {
+		return user, err
+	}
+
+	cached := models.NewCachedUser(user)
+	if generation == "" {
+		return cached.User(), nil
+	}
+
+	data, err = bson.Marshal(cached)
+	if err != nil {
+		return cached.User(), nil
+	}
+
+	keys := []string{cachedUserKey(userId), userCacheGenerationKey(userId)}
+	if err := setCachedUserScript.Run(u.ctx, u.redisClient, keys, generation, data, utils.UserCacheTTL.Milliseconds()).Err(); err != nil {
+		log.Printf("could not cache user %s: %v", userId, err)
+	}
+
+	return cached.User(), nil
+}
+
+func (u UserCacheServiceImpl) InvalidateUser(userId string) error {
+	pipe := u.redisClient.TxPipeline()
+	pipe.Incr(u.ctx, userCacheGenerationKey(userId))
+	pipe.Expire(u.ctx, userCacheGenerationKey(userId), utils.UserCacheGenerationTTL)
+	pipe.Del(u.ctx, cachedUserKey(userId))
+	_, err := pipe.Exec(u.ctx)
+	return err
+}
+
+func NewUserCacheService(redisClient *redis.Client, ctx context.Context) UserCacheService {
+	return &UserCacheServiceImpl{redisClient, ctx}
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RedactedSecret replaces secrets in API responses, sending it back in a PATCH keeps the stored secret
+const RedactedSecret = "********"
+
+// authMethodSecretFields are the config fields of each auth method type that hold secrets
+var authMethodSecretFields = map[string][]string{
+	Oauth2Auth: {"client_secret"},
+	LDAPAuth:   {"bind_password"},
+}
+
+// transformAuthMethodSecrets Apply transform to every secret field set in the configs
+func transformAuthMethodSecrets(authType string, configs json.RawMessage, transform func(field string, value string) (string, error)) (json.RawMessage, error) {
+	fields := authMethodSecretFields[authType]
+	if len(fields) == 0 || len(configs) == 0 {
+		return configs, nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(configs, &values); err != nil {
+		return nil, fmt.Errorf("invalid auth method configs: %w", err)
+	}
+	if values == nil {
+		return configs, nil
+	}
+
+	for _, field := range fields {
+		value, _ := values[field].(string)
+		newValue, err := transform(field, value)
+		if err != nil {
+			return nil, err
+		}
+
+		if newValue == "" {
+			delete(values, field)
+		} else {
+			values[field] = newValue
+		}
+	}
+
+	return json.Marshal(values)
+}
+
+// EncryptAuthMethodSecrets Encrypt the secret fields before the configs are stored
+func EncryptAuthMethodSecrets(authType string, configs json.RawMessage) (json.RawMessage, error) {
+	return transformAuthMethodSecrets(authType, configs, func(field string, value string) (string, error) {
+		if value == "" {
+			return "", nil
+		}
+		return EncryptSecret(value)
+	})
+}
+
+// DecryptAuthMethodSecrets Decrypt the secret fields of stored configs,
+// values stored before encryption was introduced are returned as they are
+func DecryptAuthMethodSecrets(authType string, configs json.RawMessage) (json.RawMessage, error) {
+	return transformAuthMethodSecrets(authType, configs, func(field string, value string) (string, error) {
+		if !IsEncryptedSecret(value) {
+			return value, nil
+		}
+		return DecryptSecret(value)
+	})
+}
+
+// RotateAuthMethodSecrets Bring the secret fields of stored configs to the active key,
+// it reports whether anything changed
+func RotateAuthMethodSecrets(authType string, configs json.RawMessage) (json.RawMessage, bool, error) {
+	changed := false
+	rotated, err := transformAuthMethodSecrets(authType, configs, func(field string, value string) (string, error) {
+		if value == "" {
+			return "", nil
+		}
+
+		if !IsEncryptedSecret(value) {
+			changed = true
+			return EncryptSecret(value)
+		}
+
+		newValue, rewrapped, err := RotateSecret(value)
+		changed = changed || rewrapped
+		return newValue, err
+	})
+
+	return rotated, changed, err
+}
+
+// RedactAuthMethodSecrets Hide the secret fields of the configs
+func RedactAuthMethodSecrets(authType string, configs json.RawMessage) (json.RawMessage, error) {
+	return transformAuthMethodSecrets(authType, configs, func(field string, value string) (string, error) {
+		if value == "" {
+			return "", nil
+		}
+		return RedactedSecret, nil
+	})
+}
+
+// MergeAuthMethodSecrets Keep the secrets of the current configs for the fields that are
+// omitted or redacted in the new ones
+func MergeAuthMethodSecrets(authType string, configs json.RawMessage, currentConfigs json.RawMessage) (json.RawMessage, error) {
+	current := map[string]interface{}{}
+	if len(currentConfigs) > 0 {
+		if err := json.Unmarshal(currentConfigs, &current); err != nil {
+			return nil, fmt.Errorf("invalid auth method configs: %w", err)
+		}
+	}
+
+	return transformAuthMethodSecrets(authType, configs, func(field string, value string) (string, error) {
+		if value == "" || value == RedactedSecret {
+			currentValue, _ := current[field].(string)
+			return currentValue, nil
+		}
+		return value, nil
+	})
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+type bannerHello struct {
+	serverFirst bool
+	hello       string
+}
+
+// Services that greet first are only sent the hello when they stay silent
+var bannerHellos = map[string]bannerHello{
+	"ssh":   {serverFirst: true, hello: "SSH-2.0-clst-mgt\r\n"},
+	"smtp":  {serverFirst: true, hello: "EHLO clst-mgt\r\n"},
+	"ftp":   {serverFirst: true, hello: "SYST\r\n"},
+	"redis": {serverFirst: false, hello: "PING\r\n"},
+	"http":  {serverFirst: false, hello: "HEAD / HTTP/1.0\r\nHost: %s\r\nUser-Agent: clst-mgt\r\n\r\n"},
+}
+
+var bannerPorts = map[string]string{
+	"21":   "ftp",
+	"22":   "ssh",
+	"25":   "smtp",
+	"587":  "smtp",
+	"80":   "http",
+	"8000": "http",
+	"8080": "http",
+	"6379": "redis",
+}
+
+// BannerProtocol Pick the banner protocol of a rule, falling back to the well-known port
+func BannerProtocol(protocol string, port string) string {
+	if protocol != "" {
+		return protocol
+	}
+
+	return bannerPorts[port]
+}
+
+// GrabBanner Read what the service on conn answers, sending the protocol hello when needed.
+// The result is sanitized and never longer than maxBytes.
+func GrabBanner(conn net.Conn, protocol string, host string, maxBytes int) string {
+	if maxBytes <= 0 {
+		maxBytes = DefaultBannerBytes
+	}
+	if maxBytes > MaxBannerBytes {
+		maxBytes = MaxBannerBytes
+	}
+
+	hello, isKnown := bannerHellos[protocol]
+
+	var banner []byte
+	if !isKnown || hello.serverFirst {
+		banner = readBanner(conn, maxBytes)
+	}
+
+	if isKnown && len(banner) == 0 {
+		payload := hello.hello
+		if strings.Contains(payload, "%s") {
+			payload = fmt.Sprintf(payload, host)
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(TimeoutBanner))
+		if _, err := conn.Write([]byte(payload)); err == nil {
+			banner = readBanner(conn, maxBytes)
+		}
+	}
+
+	return SanitizeBanner(banner, maxBytes)
+}
+
+func readBanner(conn net.Conn, maxBytes int) []byte {
+	buffer := make([]byte, maxBytes)
+	conn.SetReadDeadline(time.Now().Add(TimeoutBanner))
+
+	var total int
+	for total < maxBytes {
+		n, err := conn.Read(buffer[total:])
+		total += n
+		if err != nil {
+			break
+		}
+	}
+
+	return buffer[:total]
+}
+
+// SanitizeBanner Keep printable ASCII only so banners are safe to store and display
+func SanitizeBanner(banner []byte, maxBytes int) string {
+	var builder strings.Builder
+	for _, b := range banner {
+		switch {
+		case b == '\r':
+			continue
+		case b == '\n' || b == '\t' || (b >= 0x20 && b < 0x7f):
+			builder.WriteByte(b)
+		default:
+			builder.WriteByte('.')
+		}
+
+		if builder.Len() >= maxBytes {
+			break
+		}
+	}
+
+	return strings.TrimSpace(builder.String())
+}
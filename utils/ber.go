@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Minimal BER encoding used by the LDAP client, only the subset LDAPv3 needs
+// (single byte tags, definite lengths).
+
+const (
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x30
+	berTagSet         = 0x31
+
+	berClassContext     = 0x80
+	berClassApplication = 0x40
+	berConstructed      = 0x20
+
+	maxBERLength = 16 << 20
+)
+
+type berPacket struct {
+	Tag      byte
+	Value    []byte
+	Children []*berPacket
+}
+
+func (p *berPacket) isConstructed() bool {
+	return p.Tag&berConstructed != 0
+}
+
+func berEncodeLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+
+	var raw []byte
+	for l := length; l > 0; l >>= 8 {
+		raw = append([]byte{byte(l)}, raw...)
+	}
+	return append([]byte{0x80 | byte(len(raw))}, raw...)
+}
+
+func berEncode(tag byte, content []byte) []byte {
+	out := append([]byte{tag}, berEncodeLength(len(content))...)
+	return append(out, content...)
+}
+
+func berConstruct(tag byte, children ...[]byte) []byte {
+	var content []byte
+	for _, child := range children {
+		content = append(content, child...)
+	}
+	return berEncode(tag, content)
+}
+
+func berInteger(tag byte, value int64) []byte {
+	var raw []byte
+	for {
+		raw = append([]byte{byte(value)}, raw...)
+		value >>= 8
+		if (value == 0 && raw[0]&0x80 == 0) || (value == -1 && raw[0]&0x80 != 0) {
+			break
+		}
+	}
+	return berEncode(tag, raw)
+}
+
+func berString(tag byte, value string) []byte {
+	return berEncode(tag, []byte(value))
+}
+
+func berBoolean(tag byte, value bool) []byte {
+	if value {
+		return berEncode(tag, []byte{0xff})
+	}
+	return berEncode(tag, []byte{0x00})
+}
+
+// berRead Read one BER element from the stream and decode the constructed ones recursively
+func berRead(reader *bufio.Reader) (*berPacket, error) {
+	tag, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	first, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	length := int(first)
+	if first&0x80 != 0 {
+		size := int(first & 0x7f)
+		if size == 0 || size > 4 {
+			return nil, fmt.Errorf("ber: unsupported length encoding")
+		}
+		length = 0
+		for i := 0; i < size; i++ {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+
+	if length > maxBERLength {
+		return nil, fmt.Errorf("ber: element of %d bytes is too large", length)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return nil, err
+	}
+
+	return berDecode(tag, content)
+}
+
+func berDecode(tag byte, content []byte) (*berPacket, error) {
+	packet := &berPacket{Tag: tag, Value: content}
+	if !packet.isConstructed() {
+		return packet, nil
+	}
+
+	for offset := 0; offset < len(content); {
+		if offset+2 > len(content) {
+			return nil, fmt.Errorf("ber: truncated element")
+		}
+
+		childTag := content[offset]
+		length := int(content[offset+1])
+		offset += 2
+		if length&0x80 != 0 {
+			size := length & 0x7f
+			if size == 0 || size > 4 || offset+size > len(content) {
+				return nil, fmt.Errorf("ber: unsupported length encoding")
+			}
+			length = 0
+			for _, b := range content[offset : offset+size] {
+				length = length<<8 | int(b)
+			}
+			offset += size
+		}
+
+		if length < 0 || offset+length > len(content) {
+			return nil, fmt.Errorf("ber: truncated element")
+		}
+
+		child, err := berDecode(childTag, content[offset:offset+length])
+		if err != nil {
+			return nil, err
+		}
+		packet.Children = append(packet.Children, child)
+		offset += length
+	}
+
+	return packet, nil
+}
+
+func (p *berPacket) integer() int64 {
+	var value int64
+	for i, b := range p.Value {
+		if i == 0 && b&0x80 != 0 {
+			value = -1
+		}
+		value = value<<8 | int64(b)
+	}
+	return value
+}
@@ -8,14 +8,112 @@ const (
 	StatusPending = 2
 	StatusUnknown = 3
 
-	TimeoutScan = time.Second
+	TimeoutScan   = time.Second
+	TimeoutBanner = 2 * time.Second
+
+	TimeoutTLS      = 3 * time.Second
+	TimeoutTLSAlert = 500 * time.Millisecond
+
+	TLSHandshakeSuccess = "success"
+	TLSHandshakeFailed  = "failed"
+
+	DefaultBannerBytes = 256
+	MaxBannerBytes     = 1024
+
+	DefaultDNSPort  = "53"
+	SystemDNSServer = "system"
+
+	DNSFindingInconsistent = "inconsistent"
+	DNSFindingSplitHorizon = "split_horizon"
 
 	StatusSuccessScan = "success"
 	StatusErrorScan   = "error"
 
-	BasicAuth  = "basic"
-	Oauth2Auth = "oauth2"
+	StatusUnsupportedScan  = "unsupported"
+	StatusOpenFilteredScan = "open|filtered"
+	StatusUnverifiableScan = "unverifiable"
+
+	StandardRule = "standard"
+	MeshRule     = "mesh"
+
+	ServiceTargetClusterIP = "cluster_ip"
+	ServiceTargetEndpoints = "endpoints"
+	ServiceTargetBoth      = "both"
+
+	TargetKindClusterIP = "cluster_ip"
+	TargetKindEndpoint  = "endpoint"
+
+	MeshProbePayload = "clst-mgt-mesh-probe"
+	MeshAckPayload   = "clst-mgt-mesh-ack"
+
+	BasicAuth          = "basic"
+	Oauth2Auth         = "oauth2"
+	LDAPAuth           = "ldap"
+	SAMLAuth           = "saml"
+	ServiceAccountAuth = "service_account"
+
+	RegistrationOpen       = "open"
+	RegistrationDisabled   = "disabled"
+	RegistrationRestricted = "restricted"
 
 	AdminRole = "admin"
 	UserRole  = "user"
+
+	ProjectViewerRole = "viewer"
+	ProjectEditorRole = "editor"
+	ProjectOwnerRole  = "owner"
+
+	ActionRead    = "read"
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionTrigger = "trigger"
+	ActionManage  = "manage"
+
+	ResourceRule    = "rule"
+	ResourceProject = "project"
+
+	DefaultVerificationCodeTTL = 24 * time.Hour
+	VerificationResendLimit    = 3
+	VerificationResendWindow   = time.Hour
+
+	PasswordResetTokenTTL = 15 * time.Minute
+	PasswordResetLimit    = 3
+	PasswordResetWindow   = time.Hour
+
+	MaxPasswordLength       = 72 // bcrypt ignores everything after 72 bytes
+	GeneratedPasswordLength = 20
+
+	APITokenPrefix = "clst_"
+
+	AccessTokenKeyUse          = "access"
+	RefreshTokenKeyUse         = "refresh"
+	SigningKeyBits             = 2048
+	SigningKeyCacheTTL         = time.Minute
+	SigningKeyMinRefreshPeriod = 10 * time.Second
+	SigningKeyPublishDelay     = 5 * time.Minute // a new key is in the JWKS this long before it signs
+	SigningKeyRotationCheck    = time.Hour
+
+	OAuth2LoginStateTTL = 10 * time.Minute
+	OAuth2LoginLimit    = 30
+	OAuth2LoginWindow   = time.Minute
+
+	LoginLockoutThreshold   = 5
+	LoginIPLockoutThreshold = 20
+	LoginLockoutBase        = time.Minute
+	LoginLockoutMax         = time.Hour
+	LoginFailureWindow      = 24 * time.Hour
+
+	UserCacheTTL           = 5 * time.Minute
+	UserCacheGenerationTTL = time.Hour
+
+	MFAIssuer            = "clst-mgt"
+	MFAChallengeTTL      = 5 * time.Minute
+	MFAChallengeAttempts = 5
+	MFAUserAttempts      = 10
+	MFAUserFailureWindow = time.Hour
+
+	ScopeRulesRead    = "rules:read"
+	ScopeScansTrigger = "scans:trigger"
+	ScopeRulesManage  = "rules:manage"
 )
@@ -0,0 +1,308 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/thuongnn/clst-mgt-api/config"
+)
+
+const (
+	secretEnvelopePrefix = "enc:"
+	legacySecretKeyId    = "legacy"
+	secretDataKeySize    = 32
+)
+
+// secretKeyring holds the key encryption keys by id, new secrets are wrapped with the active one
+type secretKeyring struct {
+	activeId string
+	keys     map[string][]byte
+}
+
+// loadSecretKeyring Read the keys from config. SECRET_ENCRYPTION_KEYS is a comma separated list of
+// "id:base64key" and SECRET_ENCRYPTION_ACTIVE_KEY picks the id used for new secrets.
+// SECRET_ENCRYPTION_KEY stays readable under the "legacy" id and is active when no keyring is set.
+func loadSecretKeyring() (*secretKeyring, error) {
+	appConfig, err := config.LoadConfig(".")
+	if err != nil {
+		return nil, err
+	}
+
+	return newSecretKeyring(appConfig.SecretEncryptionKey, appConfig.SecretEncryptionKeys, appConfig.SecretEncryptionActiveKey)
+}
+
+func newSecretKeyring(legacyKey string, keys string, activeId string) (*secretKeyring, error) {
+	keyring := &secretKeyring{keys: map[string][]byte{}}
+
+	if legacyKey != "" {
+		key, err := decodeSecretKey(legacyKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode secret encryption key: %w", err)
+		}
+		keyring.keys[legacySecretKeyId] = key
+		keyring.activeId = legacySecretKeyId
+	}
+
+	for _, entry := range strings.Split(keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, encoded, found := strings.Cut(entry, ":")
+		if !found || id == "" {
+			return nil, errors.New("invalid secret encryption keys: expected id:base64key")
+		}
+
+		key, err := decodeSecretKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode secret encryption key %s: %w", id, err)
+		}
+		keyring.keys[id] = key
+	}
+
+	if activeId != "" {
+		keyring.activeId = activeId
+	}
+
+	if keyring.activeId == "" {
+		return nil, errors.New("no secret encryption key is configured")
+	}
+
+	if _, ok := keyring.keys[keyring.activeId]; !ok {
+		return nil, fmt.Errorf("active secret encryption key %s is not configured", keyring.activeId)
+	}
+
+	return keyring, nil
+}
+
+func decodeSecretKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return nil, errors.New("the key must be 16, 24 or 32 bytes")
+	}
+
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret encryption key: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func sealGCM(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openGCM(key []byte, sealed []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, additionalData)
+}
+
+// wrapDataKey Encrypt a data key with a key encryption key, the key id is bound to the result
+func wrapDataKey(keyring *secretKeyring, keyId string, dataKey []byte) (string, error) {
+	wrapped, err := sealGCM(keyring.keys[keyId], dataKey, []byte(keyId))
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// parseSecretEnvelope Split an envelope into the key id, the wrapped data key and the sealed secret
+func parseSecretEnvelope(ciphertext string) (string, string, string, error) {
+	parts := strings.Split(strings.TrimPrefix(ciphertext, secretEnvelopePrefix), ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", "", "", errors.New("could not decrypt secret: malformed envelope")
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
+
+func unwrapDataKey(keyring *secretKeyring, keyId string, wrappedKey string) ([]byte, error) {
+	key, ok := keyring.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("could not decrypt secret: unknown key %s", keyId)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode secret: %w", err)
+	}
+
+	dataKey, err := openGCM(key, wrapped, []byte(keyId))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt secret: %w", err)
+	}
+
+	return dataKey, nil
+}
+
+// IsEncryptedSecret Report whether the value is an envelope made by EncryptSecret
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretEnvelopePrefix)
+}
+
+// EncryptSecret Encrypt a secret before it is stored in the database. The secret is sealed with a
+// random data key and only the data key is wrapped with the active key, so rotation rewraps it.
+func EncryptSecret(plaintext string) (string, error) {
+	keyring, err := loadSecretKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	return keyring.encrypt(plaintext)
+}
+
+func (keyring *secretKeyring) encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, secretDataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	sealed, err := sealGCM(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := wrapDataKey(keyring, keyring.activeId, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return secretEnvelopePrefix + keyring.activeId + ":" + wrappedKey + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret Decrypt a secret encrypted by EncryptSecret, or by the single key AES-GCM of earlier versions
+func DecryptSecret(ciphertext string) (string, error) {
+	keyring, err := loadSecretKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	return keyring.decrypt(ciphertext)
+}
+
+func (keyring *secretKeyring) decrypt(ciphertext string) (string, error) {
+	if !IsEncryptedSecret(ciphertext) {
+		return decryptLegacySecret(keyring, ciphertext)
+	}
+
+	keyId, wrappedKey, encodedSecret, err := parseSecretEnvelope(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := unwrapDataKey(keyring, keyId, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encodedSecret)
+	if err != nil {
+		return "", fmt.Errorf("could not decode secret: %w", err)
+	}
+
+	plaintext, err := openGCM(dataKey, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt secret: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+func decryptLegacySecret(keyring *secretKeyring, ciphertext string) (string, error) {
+	key, ok := keyring.keys[legacySecretKeyId]
+	if !ok {
+		return "", errors.New("could not decrypt secret: SECRET_ENCRYPTION_KEY is not configured")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("could not decode secret: %w", err)
+	}
+
+	plaintext, err := openGCM(key, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt secret: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// RotateSecret Bring an encrypted secret to the active key, it reports whether the value changed.
+// Envelopes only get their data key rewrapped, legacy values are encrypted again.
+func RotateSecret(ciphertext string) (string, bool, error) {
+	keyring, err := loadSecretKeyring()
+	if err != nil {
+		return "", false, err
+	}
+
+	return keyring.rotate(ciphertext)
+}
+
+func (keyring *secretKeyring) rotate(ciphertext string) (string, bool, error) {
+	if !IsEncryptedSecret(ciphertext) {
+		plaintext, err := decryptLegacySecret(keyring, ciphertext)
+		if err != nil {
+			return "", false, err
+		}
+
+		rotated, err := keyring.encrypt(plaintext)
+		return rotated, err == nil, err
+	}
+
+	keyId, wrappedKey, encodedSecret, err := parseSecretEnvelope(ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+
+	if keyId == keyring.activeId {
+		return ciphertext, false, nil
+	}
+
+	dataKey, err := unwrapDataKey(keyring, keyId, wrappedKey)
+	if err != nil {
+		return "", false, err
+	}
+
+	rewrappedKey, err := wrapDataKey(keyring, keyring.activeId, dataKey)
+	if err != nil {
+		return "", false, err
+	}
+
+	return secretEnvelopePrefix + keyring.activeId + ":" + rewrappedKey + ":" + encodedSecret, true, nil
+}
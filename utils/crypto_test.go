@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func testSecretKey(fill byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, 32))
+}
+
+func mustSecretKeyring(t *testing.T, legacyKey string, keys string, activeId string) *secretKeyring {
+	keyring, err := newSecretKeyring(legacyKey, keys, activeId)
+	if err != nil {
+		t.Fatalf("newSecretKeyring() error = %v", err)
+	}
+	return keyring
+}
+
+// legacySecret Encrypt the way earlier versions did, a single AES-GCM key without envelope
+func legacySecret(t *testing.T, encodedKey string, plaintext string) string {
+	key, err := decodeSecretKey(encodedKey)
+	if err != nil {
+		t.Fatalf("decodeSecretKey() error = %v", err)
+	}
+
+	sealed, err := sealGCM(key, []byte(plaintext), nil)
+	if err != nil {
+		t.Fatalf("sealGCM() error = %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(sealed)
+}
+
+func TestNewSecretKeyring(t *testing.T) {
+	tests := []struct {
+		name       string
+		legacyKey  string
+		keys       string
+		activeId   string
+		wantActive string
+		wantErr    string
+	}{
+		{"legacy key only", testSecretKey(1), "", "", legacySecretKeyId, ""},
+		{"keyring with active key", "", "k1:" + testSecretKey(1) + ", k2:" + testSecretKey(2), "k2", "k2", ""},
+		{"legacy key and keyring", testSecretKey(1), "k2:" + testSecretKey(2), "k2", "k2", ""},
+		{"keyring without active key", "", "k1:" + testSecretKey(1), "", "", "no secret encryption key"},
+		{"unknown active key", "", "k1:" + testSecretKey(1), "k3", "", "k3 is not configured"},
+		{"entry without id", "", testSecretKey(1), "k1", "", "expected id:base64key"},
+		{"short key", "", "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "k1", "", "16, 24 or 32 bytes"},
+		{"nothing configured", "", "", "", "", "no secret encryption key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := newSecretKeyring(tt.legacyKey, tt.keys, tt.activeId)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newSecretKeyring() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newSecretKeyring() error = %v", err)
+			}
+			if keyring.activeId != tt.wantActive {
+				t.Errorf("activeId = %s, want %s", keyring.activeId, tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestSecretKeyringRotation(t *testing.T) {
+	k1, k2 := "k1:"+testSecretKey(1), "k2:"+testSecretKey(2)
+	before := mustSecretKeyring(t, testSecretKey(9), k1, "k1")
+	after := mustSecretKeyring(t, testSecretKey(9), k1+","+k2, "k2")
+	retired := mustSecretKeyring(t, "", k2, "k2")
+
+	fromK1, err := before.encrypt("s3cret")
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+	if !strings.HasPrefix(fromK1, secretEnvelopePrefix+"k1:") {
+		t.Fatalf("encrypt() = %s, want an envelope of k1", fromK1)
+	}
+
+	fromLegacy := legacySecret(t, testSecretKey(9), "legacy-s3cret")
+
+	tests := []struct {
+		name        string
+		ciphertext  string
+		plaintext   string
+		wantChanged bool
+	}{
+		{"envelope of the old key is rewrapped", fromK1, "s3cret", true},
+		{"legacy value is encrypted again", fromLegacy, "legacy-s3cret", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// both keys are still configured, the old value reads with either keyring
+			plaintext, err := after.decrypt(tt.ciphertext)
+			if err != nil || plaintext != tt.plaintext {
+				t.Fatalf("decrypt() before rotation = %q, %v, want %q", plaintext, err, tt.plaintext)
+			}
+
+			rotated, changed, err := after.rotate(tt.ciphertext)
+			if err != nil {
+				t.Fatalf("rotate() error = %v", err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("rotate() changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if !strings.HasPrefix(rotated, secretEnvelopePrefix+"k2:") {
+				t.Fatalf("rotate() = %s, want an envelope of k2", rotated)
+			}
+
+			// once rotated, the old keys can be removed
+			plaintext, err = retired.decrypt(rotated)
+			if err != nil || plaintext != tt.plaintext {
+				t.Fatalf("decrypt() after rotation = %q, %v, want %q", plaintext, err, tt.plaintext)
+			}
+
+			again, changed, err := after.rotate(rotated)
+			if err != nil || changed || again != rotated {
+				t.Errorf("rotate() of a current envelope = %s, %v, %v, want it unchanged", again, changed, err)
+			}
+		})
+	}
+}
+
+func TestSecretKeyringDecryptErrors(t *testing.T) {
+	keyring := mustSecretKeyring(t, "", "k1:"+testSecretKey(1), "k1")
+	other := mustSecretKeyring(t, "", "k2:"+testSecretKey(2), "k2")
+
+	fromK2, err := other.encrypt("s3cret")
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+
+	// an envelope claiming another key id must not open, the key id is bound to the wrapped key
+	forged := secretEnvelopePrefix + "k1:" + strings.TrimPrefix(fromK2, secretEnvelopePrefix+"k2:")
+
+	tests := []struct {
+		name       string
+		ciphertext string
+		wantErr    string
+	}{
+		{"unknown key id", fromK2, "unknown key k2"},
+		{"key id swapped", forged, "could not decrypt secret"},
+		{"malformed envelope", secretEnvelopePrefix + "k1:only-two", "malformed envelope"},
+		{"legacy value without legacy key", legacySecret(t, testSecretKey(9), "s3cret"), "SECRET_ENCRYPTION_KEY is not configured"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := keyring.decrypt(tt.ciphertext); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("decrypt() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecryptAuthMethodSecretsPlaintext(t *testing.T) {
+	// values stored before encryption was introduced are read as they are, without any key
+	configs := json.RawMessage(`{"client_id":"app","client_secret":"plain-secret"}`)
+
+	decrypted, err := DecryptAuthMethodSecrets(Oauth2Auth, configs)
+	if err != nil {
+		t.Fatalf("DecryptAuthMethodSecrets() error = %v", err)
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(decrypted, &values); err != nil {
+		t.Fatalf("could not parse configs: %v", err)
+	}
+	if values["client_secret"] != "plain-secret" || values["client_id"] != "app" {
+		t.Errorf("DecryptAuthMethodSecrets() = %s", decrypted)
+	}
+}
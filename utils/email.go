@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"crypto/tls"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"text/template"
@@ -48,7 +47,7 @@ func SendEmail(user *models.UserDBResponse, data *EmailData, templateName string
 	config, err := config.LoadConfig(".")
 
 	if err != nil {
-		log.Fatal("could not load config", err)
+		return fmt.Errorf("could not load config: %w", err)
 	}
 
 	// Sender data.
@@ -63,12 +62,17 @@ func SendEmail(user *models.UserDBResponse, data *EmailData, templateName string
 
 	template, err := ParseTemplateDir("templates")
 	if err != nil {
-		log.Fatal("Could not parse template", err)
+		return fmt.Errorf("could not parse template: %w", err)
 	}
 
 	template = template.Lookup(templateName)
-	template.Execute(&body, &data)
-	fmt.Println(template.Name())
+	if template == nil {
+		return fmt.Errorf("could not find template %s", templateName)
+	}
+
+	if err := template.Execute(&body, &data); err != nil {
+		return fmt.Errorf("could not execute template %s: %w", templateName, err)
+	}
 
 	m := gomail.NewMessage()
 
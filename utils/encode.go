@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -21,6 +24,35 @@ func Decode(s string) (string, error) {
 	return string(data), nil
 }
 
+// GenerateRandomToken Generate a hex encoded token from n random bytes
+func GenerateRandomToken(n int) (string, error) {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("could not generate random token: %w", err)
+	}
+
+	return hex.EncodeToString(data), nil
+}
+
+// HashToken Hash a token before it is stored, so a database leak doesn't leak usable tokens
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// GeneratePKCE Generate a PKCE code verifier and its S256 code challenge
+func GeneratePKCE() (string, string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", "", fmt.Errorf("could not generate code verifier: %w", err)
+	}
+
+	verifier := base64.RawURLEncoding.EncodeToString(data)
+	challenge := sha256.Sum256([]byte(verifier))
+
+	return verifier, base64.RawURLEncoding.EncodeToString(challenge[:]), nil
+}
+
 func ArrToString(input []int) string {
 	return strings.Trim(strings.Join(strings.Fields(fmt.Sprint(input)), ", "), "[]")
 }
@@ -101,15 +101,151 @@ func PortParser(rawPort string) (*models.Port, error) {
 	return nil, fmt.Errorf("The port number doesn't match any protocol pattern! ")
 }
 
-func IsAdmin(userGroups, adminGroups []string) bool {
-	groupSet := make(map[string]struct{})
+// ServiceRefParser Parse a destination service written as namespace/name[:port]
+func ServiceRefParser(rawService string) (*models.ServiceRef, error) {
+	re := regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?)/([a-z0-9]([-a-z0-9]*[a-z0-9])?)(:([a-z0-9-]+))?$`)
 
-	for _, group := range adminGroups {
-		groupSet[group] = struct{}{}
+	subMatches := re.FindStringSubmatch(strings.ToLower(strings.TrimSpace(rawService)))
+	if subMatches == nil {
+		return nil, fmt.Errorf("The service %s doesn't match the namespace/name[:port] pattern! ", rawService)
 	}
 
-	for _, group := range userGroups {
-		if _, exists := groupSet[group]; exists {
+	return &models.ServiceRef{
+		Namespace: subMatches[1],
+		Name:      subMatches[3],
+		Port:      subMatches[6],
+	}, nil
+}
+
+// OAuth2GroupMappings Mappings of an OAuth2 auth method, the legacy admin groups map to the admin role
+func OAuth2GroupMappings(oauth2Info models.OAuth2Config) []models.GroupMapping {
+	var mappings []models.GroupMapping
+	for _, group := range oauth2Info.AdminGroups {
+		mappings = append(mappings, models.GroupMapping{Value: group, Role: AdminRole})
+	}
+
+	return append(mappings, oauth2Info.GroupMappings...)
+}
+
+// ResolveGroupMappings Evaluate the mappings against the user claims and return the granted role and projects
+func ResolveGroupMappings(claims map[string]interface{}, mappings []models.GroupMapping) (string, []string) {
+	role := UserRole
+	var projects []string
+
+	for _, mapping := range mappings {
+		claim := mapping.Claim
+		if claim == "" {
+			claim = "groups"
+		}
+
+		if !claimContains(claims[claim], mapping.Value) {
+			continue
+		}
+
+		if mapping.Role == AdminRole {
+			role = AdminRole
+		}
+
+		for _, project := range mapping.Projects {
+			if !Contains(projects, project) {
+				projects = append(projects, project)
+			}
+		}
+	}
+
+	return role, projects
+}
+
+func claimContains(claim interface{}, value string) bool {
+	switch v := claim.(type) {
+	case nil:
+		return false
+	case []interface{}:
+		for _, item := range v {
+			if fmt.Sprint(item) == value {
+				return true
+			}
+		}
+		return false
+	case []string:
+		return Contains(v, value)
+	default:
+		return fmt.Sprint(v) == value
+	}
+}
+
+// ValidateGroupMappings Check the mappings of an OAuth2 auth method
+func ValidateGroupMappings(mappings []models.GroupMapping) error {
+	for i, mapping := range mappings {
+		if strings.TrimSpace(mapping.Value) == "" {
+			return fmt.Errorf("invalid group mapping %d: value is required", i)
+		}
+
+		if mapping.Role != "" && mapping.Role != AdminRole && mapping.Role != UserRole {
+			return fmt.Errorf("invalid group mapping %d: role must be %s or %s", i, AdminRole, UserRole)
+		}
+
+		if mapping.Role == "" && len(mapping.Projects) == 0 {
+			return fmt.Errorf("invalid group mapping %d: a role or projects are required", i)
+		}
+	}
+
+	return nil
+}
+
+func Contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// projectRoleRanks orders the project roles, every role includes the ones below it
+var projectRoleRanks = map[string]int{
+	ProjectViewerRole: 1,
+	ProjectEditorRole: 2,
+	ProjectOwnerRole:  3,
+}
+
+// ProjectRoleAtLeast Report whether the project role grants at least the permissions of minRole
+func ProjectRoleAtLeast(role string, minRole string) bool {
+	return projectRoleRanks[role] > 0 && projectRoleRanks[role] >= projectRoleRanks[minRole]
+}
+
+// ValidateBasicAuthConfig Check the registration policy of the basic auth method
+func ValidateBasicAuthConfig(basicAuthConfig models.BasicAuthConfig) error {
+	switch basicAuthConfig.RegistrationPolicy {
+	case "", RegistrationOpen, RegistrationDisabled:
+	case RegistrationRestricted:
+		if len(basicAuthConfig.AllowedEmailDomains) == 0 {
+			return fmt.Errorf("invalid basic auth config: allowed_email_domains is required for the restricted registration policy")
+		}
+	default:
+		return fmt.Errorf("invalid basic auth config: registration_policy must be %s, %s or %s", RegistrationOpen, RegistrationDisabled, RegistrationRestricted)
+	}
+
+	for _, domain := range basicAuthConfig.AllowedEmailDomains {
+		if strings.Trim(strings.TrimSpace(domain), "@") == "" {
+			return fmt.Errorf("invalid basic auth config: empty email domain")
+		}
+	}
+
+	return nil
+}
+
+// EmailDomainAllowed Report whether the domain of the email is one of the allowed domains
+func EmailDomainAllowed(email string, allowedDomains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	domain := strings.ToLower(email[at+1:])
+	for _, allowedDomain := range allowedDomains {
+		if domain == strings.ToLower(strings.Trim(strings.TrimSpace(allowedDomain), "@")) {
 			return true
 		}
 	}
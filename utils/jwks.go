@@ -0,0 +1,261 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/thuongnn/clst-mgt-api/models"
+)
+
+const (
+	jwksCacheTTL         = time.Hour
+	jwksMinRefreshPeriod = time.Minute
+)
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwksCacheEntry struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+var (
+	jwksCacheMu sync.Mutex
+	jwksCache   = map[string]*jwksCacheEntry{}
+)
+
+func fetchJWKS(jwksURI string) (map[string]crypto.PublicKey, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(jwksURI)
+	if err != nil {
+		return nil, fmt.Errorf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("parse JSON error: %v", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		// keys of unsupported types are skipped, they may be meant for other clients
+		key, err := parseJSONWebKey(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func parseJSONWebKey(jwk jsonWebKey) (crypto.PublicKey, error) {
+	decode := func(value string) (*big.Int, error) {
+		raw, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(raw), nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+// JWKSKey Get a signing key of the provider, the key set is cached and re-fetched
+// when it is stale or when the provider signs with a key we haven't seen yet (rotation)
+func JWKSKey(jwksURI string, kid string) (crypto.PublicKey, error) {
+	jwksCacheMu.Lock()
+	defer jwksCacheMu.Unlock()
+
+	entry, cached := jwksCache[jwksURI]
+	if cached && time.Since(entry.fetchedAt) < jwksCacheTTL {
+		if key, ok := entry.keys[kid]; ok {
+			return key, nil
+		}
+	}
+
+	// don't hammer the provider with tokens signed by unknown keys
+	if cached && time.Since(entry.fetchedAt) < jwksMinRefreshPeriod {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := fetchJWKS(jwksURI)
+	if err != nil {
+		if cached {
+			if key, ok := entry.keys[kid]; ok {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("could not fetch JWKS: %w", err)
+	}
+	jwksCache[jwksURI] = &jwksCacheEntry{keys: keys, fetchedAt: time.Now()}
+
+	key, ok := keys[kid]
+	if !ok {
+		// providers with a single key don't always set a kid
+		if kid == "" && len(keys) == 1 {
+			for _, onlyKey := range keys {
+				return onlyKey, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+// VerifyIDToken Verify the signature, issuer, audience and lifetime of an OIDC ID token and decode its claims
+func VerifyIDToken(rawIDToken string, jwksURI string, issuer string, clientID string, nonce string, out *models.UserClaims) error {
+	if jwksURI == "" {
+		return fmt.Errorf("provider does not publish a jwks_uri")
+	}
+
+	parsedToken, err := jwt.Parse(rawIDToken, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected method: %s", t.Header["alg"])
+		}
+
+		kid, _ := t.Header["kid"].(string)
+		return JWKSKey(jwksURI, kid)
+	})
+	if err != nil {
+		return fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok || !parsedToken.Valid {
+		return fmt.Errorf("invalid id_token")
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return fmt.Errorf("invalid id_token: missing exp")
+	}
+
+	if !claims.VerifyIssuer(issuer, true) {
+		return fmt.Errorf("invalid id_token: unexpected issuer %v", claims["iss"])
+	}
+
+	if !claims.VerifyAudience(clientID, true) {
+		return fmt.Errorf("invalid id_token: unexpected audience %v", claims["aud"])
+	}
+
+	// with several audiences the token must have been issued to us
+	if audiences, ok := claims["aud"].([]interface{}); ok && len(audiences) > 1 {
+		if azp, _ := claims["azp"].(string); azp != clientID {
+			return fmt.Errorf("invalid id_token: unexpected authorized party %v", claims["azp"])
+		}
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); nonce != "" && subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return fmt.Errorf("invalid id_token: nonce mismatch")
+	}
+
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(raw, out); err != nil {
+		return err
+	}
+	out.Raw = claims
+
+	return nil
+}
+
+// FetchUserInfo Get the claims of the user from the userinfo endpoint of the provider
+func FetchUserInfo(client *http.Client, userInfoURL string, out *models.UserClaims) error {
+	resp, err := client.Get(userInfoURL)
+	if err != nil {
+		return fmt.Errorf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP error %d", resp.StatusCode)
+	}
+
+	var claims map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return fmt.Errorf("parse JSON error: %v", err)
+	}
+
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("parse JSON error: %v", err)
+	}
+	out.Raw = claims
+
+	return nil
+}
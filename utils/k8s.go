@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"github.com/thuongnn/clst-mgt-api/config"
+	"github.com/thuongnn/clst-mgt-api/models"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -11,6 +13,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 func GetKubeConfig(isInCluster bool) (kubeConfig *rest.Config, err error) {
@@ -65,3 +69,75 @@ func GetCurrentNodeId(k8sClient *kubernetes.Clientset, ctx context.Context) (str
 
 	return string(node.UID), nil
 }
+
+// ResolveServiceTargets Resolve a destination service to its ClusterIP and/or ready endpoint addresses
+func ResolveServiceTargets(k8sClient *kubernetes.Clientset, ctx context.Context, ref *models.ServiceRef, mode string) ([]*models.ServiceTarget, error) {
+	service, err := k8sClient.CoreV1().Services(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var servicePorts []corev1.ServicePort
+	for _, servicePort := range service.Spec.Ports {
+		if ref.Port == "" || ref.Port == servicePort.Name || ref.Port == strconv.Itoa(int(servicePort.Port)) {
+			servicePorts = append(servicePorts, servicePort)
+		}
+	}
+
+	if len(servicePorts) == 0 {
+		return nil, fmt.Errorf("service %s/%s doesn't expose port %s", ref.Namespace, ref.Name, ref.Port)
+	}
+
+	var targets []*models.ServiceTarget
+
+	isHeadless := service.Spec.ClusterIP == "" || service.Spec.ClusterIP == corev1.ClusterIPNone
+	if mode != ServiceTargetEndpoints && !isHeadless {
+		for _, servicePort := range servicePorts {
+			targets = append(targets, &models.ServiceTarget{
+				Address:  service.Spec.ClusterIP,
+				Port:     strconv.Itoa(int(servicePort.Port)),
+				Protocol: strings.ToLower(string(servicePort.Protocol)),
+				Kind:     TargetKindClusterIP,
+			})
+		}
+	}
+
+	if mode == ServiceTargetClusterIP {
+		return targets, nil
+	}
+
+	endpoints, err := k8sClient.CoreV1().Endpoints(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, subset := range endpoints.Subsets {
+		for _, servicePort := range servicePorts {
+			// Endpoint ports carry the name of the service port they back
+			for _, endpointPort := range subset.Ports {
+				if endpointPort.Name != servicePort.Name {
+					continue
+				}
+
+				for _, address := range subset.Addresses {
+					target := &models.ServiceTarget{
+						Address:  address.IP,
+						Port:     strconv.Itoa(int(endpointPort.Port)),
+						Protocol: strings.ToLower(string(endpointPort.Protocol)),
+						Kind:     TargetKindEndpoint,
+					}
+					if address.TargetRef != nil {
+						target.TargetRef = address.TargetRef.Namespace + "/" + address.TargetRef.Name
+					}
+					if address.NodeName != nil {
+						target.NodeName = *address.NodeName
+					}
+
+					targets = append(targets, target)
+				}
+			}
+		}
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,458 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thuongnn/clst-mgt-api/models"
+)
+
+const (
+	ldapBindRequest         = berClassApplication | berConstructed | 0
+	ldapBindResponse        = berClassApplication | berConstructed | 1
+	ldapUnbindRequest       = berClassApplication | 2
+	ldapSearchRequest       = berClassApplication | berConstructed | 3
+	ldapSearchResultEntry   = berClassApplication | berConstructed | 4
+	ldapSearchResultDone    = berClassApplication | berConstructed | 5
+	ldapSearchResultRef     = berClassApplication | berConstructed | 19
+	ldapExtendedRequest     = berClassApplication | berConstructed | 23
+	ldapExtendedResponse    = berClassApplication | berConstructed | 24
+	ldapStartTLSOID         = "1.3.6.1.4.1.1466.20037"
+	ldapScopeWholeSubtree   = 2
+	ldapDerefNever          = 0
+	ldapResultSuccess       = 0
+	ldapResultInvalidCreds  = 49
+	ldapDefaultPort         = "389"
+	ldapDefaultSecurePort   = "636"
+	ldapDefaultSearchLimits = 0
+	ldapTimeout             = 10 * time.Second
+)
+
+// LDAPEntry is a search result, attribute names are lower-cased
+type LDAPEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// GetAttribute Get the first value of an attribute
+func (e *LDAPEntry) GetAttribute(name string) string {
+	values := e.Attributes[strings.ToLower(name)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// LDAPError is a non-success result returned by the server
+type LDAPError struct {
+	ResultCode int64
+	Message    string
+}
+
+func (e *LDAPError) Error() string {
+	return fmt.Sprintf("LDAP result code %d: %s", e.ResultCode, e.Message)
+}
+
+// IsLDAPInvalidCredentials Report whether the error is a failed bind because of wrong credentials
+func IsLDAPInvalidCredentials(err error) bool {
+	ldapErr, ok := err.(*LDAPError)
+	return ok && ldapErr.ResultCode == ldapResultInvalidCreds
+}
+
+// LDAPConn is a minimal synchronous LDAPv3 client: bind, search and StartTLS
+type LDAPConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int64
+	timeout   time.Duration
+}
+
+// DialLDAP Connect to an ldap:// or ldaps:// URL
+func DialLDAP(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*LDAPConn, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %w", err)
+	}
+
+	host, port := parsedURL.Hostname(), parsedURL.Port()
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	switch parsedURL.Scheme {
+	case "ldap":
+		if port == "" {
+			port = ldapDefaultPort
+		}
+		conn, err = dialer.Dial("tcp", net.JoinHostPort(host, port))
+	case "ldaps":
+		if port == "" {
+			port = ldapDefaultSecurePort
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), ldapTLSConfig(tlsConfig, host))
+	default:
+		return nil, fmt.Errorf("invalid LDAP URL: unsupported scheme %q", parsedURL.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &LDAPConn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+func ldapTLSConfig(tlsConfig *tls.Config, host string) *tls.Config {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+	return tlsConfig
+}
+
+func (c *LDAPConn) send(protocolOp []byte) (int64, error) {
+	c.messageID++
+	message := berConstruct(berTagSequence, berInteger(berTagInteger, c.messageID), protocolOp)
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	if _, err := c.conn.Write(message); err != nil {
+		return 0, err
+	}
+
+	return c.messageID, nil
+}
+
+// receive Read the next response of the message and return its protocol op
+func (c *LDAPConn) receive(messageID int64) (*berPacket, error) {
+	for {
+		packet, err := berRead(c.reader)
+		if err != nil {
+			return nil, err
+		}
+
+		if packet.Tag != berTagSequence || len(packet.Children) < 2 {
+			return nil, fmt.Errorf("ldap: malformed response")
+		}
+
+		// unsolicited notifications use message id 0, e.g. notice of disconnection
+		if id := packet.Children[0].integer(); id != messageID {
+			if id == 0 {
+				return nil, fmt.Errorf("ldap: server closed the connection")
+			}
+			continue
+		}
+
+		return packet.Children[1], nil
+	}
+}
+
+func ldapResult(op *berPacket) error {
+	if len(op.Children) < 3 {
+		return fmt.Errorf("ldap: malformed result")
+	}
+
+	if code := op.Children[0].integer(); code != ldapResultSuccess {
+		return &LDAPError{ResultCode: code, Message: string(op.Children[2].Value)}
+	}
+
+	return nil
+}
+
+// StartTLS Upgrade the connection to TLS
+func (c *LDAPConn) StartTLS(tlsConfig *tls.Config, host string) error {
+	messageID, err := c.send(berConstruct(ldapExtendedRequest, berString(berClassContext|0, ldapStartTLSOID)))
+	if err != nil {
+		return err
+	}
+
+	op, err := c.receive(messageID)
+	if err != nil {
+		return err
+	}
+	if op.Tag != ldapExtendedResponse {
+		return fmt.Errorf("ldap: unexpected response to StartTLS")
+	}
+	if err := ldapResult(op); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.conn, ldapTLSConfig(tlsConfig, host))
+	if err := tlsConn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind Authenticate with a simple bind, an empty password is refused because
+// servers treat it as an unauthenticated bind that always succeeds
+func (c *LDAPConn) Bind(dn string, password string) error {
+	if password == "" {
+		return &LDAPError{ResultCode: ldapResultInvalidCreds, Message: "empty password"}
+	}
+
+	messageID, err := c.send(berConstruct(ldapBindRequest,
+		berInteger(berTagInteger, 3),
+		berString(berTagOctetString, dn),
+		berString(berClassContext|0, password),
+	))
+	if err != nil {
+		return err
+	}
+
+	op, err := c.receive(messageID)
+	if err != nil {
+		return err
+	}
+	if op.Tag != ldapBindResponse {
+		return fmt.Errorf("ldap: unexpected response to bind")
+	}
+
+	return ldapResult(op)
+}
+
+// Search Run a subtree search below the base DN
+func (c *LDAPConn) Search(baseDN string, filter string, attributes []string, sizeLimit int64) ([]*LDAPEntry, error) {
+	compiledFilter, err := CompileLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributeList [][]byte
+	for _, attribute := range attributes {
+		attributeList = append(attributeList, berString(berTagOctetString, attribute))
+	}
+
+	messageID, err := c.send(berConstruct(ldapSearchRequest,
+		berString(berTagOctetString, baseDN),
+		berInteger(berTagEnumerated, ldapScopeWholeSubtree),
+		berInteger(berTagEnumerated, ldapDerefNever),
+		berInteger(berTagInteger, sizeLimit),
+		berInteger(berTagInteger, int64(c.timeout.Seconds())),
+		berBoolean(berTagBoolean, false),
+		compiledFilter,
+		berConstruct(berTagSequence, attributeList...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*LDAPEntry
+	for {
+		op, err := c.receive(messageID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Tag {
+		case ldapSearchResultEntry:
+			entry, err := parseLDAPEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case ldapSearchResultRef:
+			// referrals to other servers are not followed
+		case ldapSearchResultDone:
+			if err := ldapResult(op); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response to search")
+		}
+	}
+}
+
+func parseLDAPEntry(op *berPacket) (*LDAPEntry, error) {
+	if len(op.Children) < 2 {
+		return nil, fmt.Errorf("ldap: malformed search entry")
+	}
+
+	entry := &LDAPEntry{DN: string(op.Children[0].Value), Attributes: map[string][]string{}}
+	for _, attribute := range op.Children[1].Children {
+		if len(attribute.Children) < 2 {
+			return nil, fmt.Errorf("ldap: malformed search entry attribute")
+		}
+
+		name := strings.ToLower(string(attribute.Children[0].Value))
+		for _, value := range attribute.Children[1].Children {
+			entry.Attributes[name] = append(entry.Attributes[name], string(value.Value))
+		}
+	}
+
+	return entry, nil
+}
+
+// Close Send an unbind request and close the connection
+func (c *LDAPConn) Close() error {
+	_, _ = c.send(berEncode(ldapUnbindRequest, nil))
+	return c.conn.Close()
+}
+
+// ErrLDAPInvalidCredentials is returned for unknown users and wrong passwords alike
+var ErrLDAPInvalidCredentials = errors.New("invalid username or password")
+
+// AuthenticateLDAP Find the user with the service account, bind as the user to check the password
+// and collect the groups of the user as claims for the group mappings
+func AuthenticateLDAP(ldapInfo models.LDAPConfig, username string, password string) (*models.LDAPUser, error) {
+	username = strings.TrimSpace(username)
+	if username == "" || password == "" {
+		return nil, ErrLDAPInvalidCredentials
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: ldapInfo.InsecureSkipVerify, MinVersion: tls.VersionTLS12}
+	if ldapInfo.CACertificate != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(ldapInfo.CACertificate)) {
+			return nil, fmt.Errorf("invalid LDAP CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	conn, err := DialLDAP(ldapInfo.URL, tlsConfig, ldapTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to LDAP server: %w", err)
+	}
+	defer conn.Close()
+
+	if ldapInfo.StartTLS {
+		parsedURL, _ := url.Parse(ldapInfo.URL)
+		if err := conn.StartTLS(tlsConfig, parsedURL.Hostname()); err != nil {
+			return nil, fmt.Errorf("could not start TLS with LDAP server: %w", err)
+		}
+	}
+
+	if ldapInfo.BindDN != "" {
+		if err := conn.Bind(ldapInfo.BindDN, ldapInfo.BindPassword); err != nil {
+			return nil, fmt.Errorf("could not bind with the LDAP service account: %w", err)
+		}
+	}
+
+	usernameAttribute := ldapAttributeOrDefault(ldapInfo.UsernameAttribute, "uid")
+	emailAttribute := ldapAttributeOrDefault(ldapInfo.EmailAttribute, "mail")
+	nameAttribute := ldapAttributeOrDefault(ldapInfo.NameAttribute, "cn")
+
+	userFilter := ldapInfo.UserSearchFilter
+	if userFilter == "" {
+		userFilter = "(" + usernameAttribute + "={username})"
+	}
+	userFilter = strings.ReplaceAll(userFilter, "{username}", EscapeLDAPFilter(username))
+
+	// a size limit of 2 is enough to tell an ambiguous filter apart
+	entries, err := conn.Search(ldapInfo.UserSearchBase, userFilter, []string{usernameAttribute, emailAttribute, nameAttribute}, 2)
+	if err != nil {
+		return nil, fmt.Errorf("could not search LDAP users: %w", err)
+	}
+	if len(entries) != 1 {
+		return nil, ErrLDAPInvalidCredentials
+	}
+	entry := entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if IsLDAPInvalidCredentials(err) {
+			return nil, ErrLDAPInvalidCredentials
+		}
+		return nil, err
+	}
+
+	user := &models.LDAPUser{
+		DN:       entry.DN,
+		Username: entry.GetAttribute(usernameAttribute),
+		Email:    entry.GetAttribute(emailAttribute),
+		Name:     entry.GetAttribute(nameAttribute),
+		Claims:   map[string]interface{}{},
+	}
+	if user.Username == "" {
+		user.Username = username
+	}
+	for name, values := range entry.Attributes {
+		user.Claims[name] = values
+	}
+
+	groups := []string{}
+	if ldapInfo.GroupSearchBase != "" {
+		groupNameAttribute := ldapAttributeOrDefault(ldapInfo.GroupNameAttribute, "cn")
+		groupFilter := ldapInfo.GroupSearchFilter
+		if groupFilter == "" {
+			groupFilter = "(member={dn})"
+		}
+		groupFilter = strings.NewReplacer(
+			"{dn}", EscapeLDAPFilter(entry.DN),
+			"{username}", EscapeLDAPFilter(user.Username),
+		).Replace(groupFilter)
+
+		groupEntries, err := conn.Search(ldapInfo.GroupSearchBase, groupFilter, []string{groupNameAttribute}, ldapDefaultSearchLimits)
+		if err != nil {
+			return nil, fmt.Errorf("could not search LDAP groups: %w", err)
+		}
+
+		for _, groupEntry := range groupEntries {
+			if name := groupEntry.GetAttribute(groupNameAttribute); name != "" {
+				groups = append(groups, name)
+			}
+		}
+	}
+	user.Claims["groups"] = groups
+
+	return user, nil
+}
+
+func ldapAttributeOrDefault(attribute string, defaultAttribute string) string {
+	if attribute == "" {
+		return defaultAttribute
+	}
+	return attribute
+}
+
+// ValidateLDAPConfig Check the configs of an LDAP auth method
+func ValidateLDAPConfig(ldapInfo models.LDAPConfig) error {
+	parsedURL, err := url.Parse(ldapInfo.URL)
+	if err != nil || (parsedURL.Scheme != "ldap" && parsedURL.Scheme != "ldaps") || parsedURL.Hostname() == "" {
+		return fmt.Errorf("invalid LDAP config: url must be ldap://host[:port] or ldaps://host[:port]")
+	}
+
+	if ldapInfo.StartTLS && parsedURL.Scheme == "ldaps" {
+		return fmt.Errorf("invalid LDAP config: start_tls can't be used with ldaps")
+	}
+
+	if ldapInfo.BindDN != "" && ldapInfo.BindPassword == "" {
+		return fmt.Errorf("invalid LDAP config: bind_password is required with bind_dn")
+	}
+
+	if strings.TrimSpace(ldapInfo.UserSearchBase) == "" {
+		return fmt.Errorf("invalid LDAP config: user_search_base is required")
+	}
+
+	if ldapInfo.UserSearchFilter != "" {
+		if !strings.Contains(ldapInfo.UserSearchFilter, "{username}") {
+			return fmt.Errorf("invalid LDAP config: user_search_filter must contain {username}")
+		}
+		if _, err := CompileLDAPFilter(strings.ReplaceAll(ldapInfo.UserSearchFilter, "{username}", "x")); err != nil {
+			return fmt.Errorf("invalid LDAP config: user_search_filter: %w", err)
+		}
+	}
+
+	if ldapInfo.GroupSearchFilter != "" {
+		filter := strings.NewReplacer("{dn}", "x", "{username}", "x").Replace(ldapInfo.GroupSearchFilter)
+		if _, err := CompileLDAPFilter(filter); err != nil {
+			return fmt.Errorf("invalid LDAP config: group_search_filter: %w", err)
+		}
+	}
+
+	return ValidateGroupMappings(ldapInfo.GroupMappings)
+}
@@ -0,0 +1,249 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	ldapFilterAnd            = berClassContext | berConstructed | 0
+	ldapFilterOr             = berClassContext | berConstructed | 1
+	ldapFilterNot            = berClassContext | berConstructed | 2
+	ldapFilterEquality       = berClassContext | berConstructed | 3
+	ldapFilterSubstrings     = berClassContext | berConstructed | 4
+	ldapFilterGreaterOrEqual = berClassContext | berConstructed | 5
+	ldapFilterLessOrEqual    = berClassContext | berConstructed | 6
+	ldapFilterPresent        = berClassContext | 7
+	ldapFilterApprox         = berClassContext | berConstructed | 8
+	ldapFilterExtensible     = berClassContext | berConstructed | 9
+)
+
+// EscapeLDAPFilter Escape a value before it is placed into a search filter (RFC 4515)
+func EscapeLDAPFilter(value string) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '*' || c == '(' || c == ')' || c == '\\' || c == 0 || c >= 0x80:
+			builder.WriteString(fmt.Sprintf("\\%02x", c))
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+// CompileLDAPFilter Parse a string search filter into its BER encoding
+func CompileLDAPFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+
+	encoded, next, err := compileLDAPFilter(filter, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if next != len(filter) {
+		return nil, fmt.Errorf("invalid LDAP filter: unexpected data at position %d", next)
+	}
+
+	return encoded, nil
+}
+
+func compileLDAPFilter(filter string, pos int) ([]byte, int, error) {
+	if pos >= len(filter) || filter[pos] != '(' {
+		return nil, pos, fmt.Errorf("invalid LDAP filter: expected '(' at position %d", pos)
+	}
+	pos++
+
+	if pos >= len(filter) {
+		return nil, pos, fmt.Errorf("invalid LDAP filter: unexpected end")
+	}
+
+	switch filter[pos] {
+	case '&', '|':
+		tag := byte(ldapFilterAnd)
+		if filter[pos] == '|' {
+			tag = ldapFilterOr
+		}
+		pos++
+
+		var children [][]byte
+		for pos < len(filter) && filter[pos] == '(' {
+			child, next, err := compileLDAPFilter(filter, pos)
+			if err != nil {
+				return nil, next, err
+			}
+			children = append(children, child)
+			pos = next
+		}
+
+		if len(children) == 0 {
+			return nil, pos, fmt.Errorf("invalid LDAP filter: empty filter list at position %d", pos)
+		}
+		if pos >= len(filter) || filter[pos] != ')' {
+			return nil, pos, fmt.Errorf("invalid LDAP filter: expected ')' at position %d", pos)
+		}
+
+		return berConstruct(tag, children...), pos + 1, nil
+	case '!':
+		child, next, err := compileLDAPFilter(filter, pos+1)
+		if err != nil {
+			return nil, next, err
+		}
+		if next >= len(filter) || filter[next] != ')' {
+			return nil, next, fmt.Errorf("invalid LDAP filter: expected ')' at position %d", next)
+		}
+
+		return berConstruct(ldapFilterNot, child), next + 1, nil
+	}
+
+	end := strings.IndexByte(filter[pos:], ')')
+	if end < 0 {
+		return nil, pos, fmt.Errorf("invalid LDAP filter: missing ')'")
+	}
+
+	item, err := compileLDAPFilterItem(filter[pos : pos+end])
+	if err != nil {
+		return nil, pos, err
+	}
+
+	return item, pos + end + 1, nil
+}
+
+func compileLDAPFilterItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("invalid LDAP filter item %q", item)
+	}
+
+	attribute, rawValue := item[:eq], item[eq+1:]
+	tag := byte(ldapFilterEquality)
+
+	switch attribute[len(attribute)-1] {
+	case '~':
+		tag, attribute = ldapFilterApprox, attribute[:len(attribute)-1]
+	case '>':
+		tag, attribute = ldapFilterGreaterOrEqual, attribute[:len(attribute)-1]
+	case '<':
+		tag, attribute = ldapFilterLessOrEqual, attribute[:len(attribute)-1]
+	case ':':
+		return compileLDAPExtensibleItem(attribute[:len(attribute)-1], rawValue)
+	}
+
+	if attribute == "" {
+		return nil, fmt.Errorf("invalid LDAP filter item %q", item)
+	}
+
+	if tag == ldapFilterEquality && rawValue == "*" {
+		return berString(ldapFilterPresent, attribute), nil
+	}
+
+	if tag == ldapFilterEquality && strings.Contains(rawValue, "*") {
+		return compileLDAPSubstrings(attribute, rawValue)
+	}
+
+	value, err := unescapeLDAPFilterValue(rawValue)
+	if err != nil {
+		return nil, err
+	}
+
+	return berConstruct(tag, berString(berTagOctetString, attribute), berString(berTagOctetString, value)), nil
+}
+
+func compileLDAPSubstrings(attribute string, rawValue string) ([]byte, error) {
+	parts := strings.Split(rawValue, "*")
+
+	var substrings [][]byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+
+		value, err := unescapeLDAPFilterValue(part)
+		if err != nil {
+			return nil, err
+		}
+
+		tag := byte(berClassContext | 1) // any
+		if i == 0 {
+			tag = berClassContext | 0 // initial
+		} else if i == len(parts)-1 {
+			tag = berClassContext | 2 // final
+		}
+		substrings = append(substrings, berString(tag, value))
+	}
+
+	return berConstruct(ldapFilterSubstrings,
+		berString(berTagOctetString, attribute),
+		berConstruct(berTagSequence, substrings...),
+	), nil
+}
+
+// compileLDAPExtensibleItem Encode attr[:dn][:rule]:=value, e.g. AD's memberOf:1.2.840.113556.1.4.1941:=
+func compileLDAPExtensibleItem(left string, rawValue string) ([]byte, error) {
+	parts := strings.Split(left, ":")
+	attribute := parts[0]
+
+	var matchingRule string
+	dnAttributes := false
+	for _, part := range parts[1:] {
+		if strings.EqualFold(part, "dn") {
+			dnAttributes = true
+		} else if part != "" {
+			matchingRule = part
+		}
+	}
+
+	if attribute == "" && matchingRule == "" {
+		return nil, fmt.Errorf("invalid LDAP extensible filter %q", left)
+	}
+
+	value, err := unescapeLDAPFilterValue(rawValue)
+	if err != nil {
+		return nil, err
+	}
+
+	var children [][]byte
+	if matchingRule != "" {
+		children = append(children, berString(berClassContext|1, matchingRule))
+	}
+	if attribute != "" {
+		children = append(children, berString(berClassContext|2, attribute))
+	}
+	children = append(children, berString(berClassContext|3, value))
+	if dnAttributes {
+		children = append(children, berBoolean(berClassContext|4, true))
+	}
+
+	return berConstruct(ldapFilterExtensible, children...), nil
+}
+
+func unescapeLDAPFilterValue(value string) (string, error) {
+	if !strings.Contains(value, "\\") {
+		return value, nil
+	}
+
+	var out []byte
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			out = append(out, value[i])
+			continue
+		}
+
+		if i+3 > len(value) {
+			return "", fmt.Errorf("invalid LDAP filter value %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid LDAP filter value %q", value)
+		}
+		out = append(out, decoded...)
+		i += 2
+	}
+
+	return string(out), nil
+}
@@ -0,0 +1,422 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thuongnn/clst-mgt-api/models"
+)
+
+type testLDAPEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// testLDAPServer is an in-process LDAPv3 server answering bind, search and StartTLS from a fixed directory
+type testLDAPServer struct {
+	t         *testing.T
+	listener  net.Listener
+	entries   []*testLDAPEntry
+	tlsConfig *tls.Config
+
+	mu          sync.Mutex
+	binds       []string
+	filters     []*berPacket
+	plainSearch bool
+}
+
+func newTestLDAPServer(t *testing.T, tlsConfig *tls.Config) *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+
+	server := &testLDAPServer{t: t, listener: listener, tlsConfig: tlsConfig, entries: []*testLDAPEntry{
+		{dn: "cn=svc,dc=example,dc=org", password: "svc-secret", attributes: map[string][]string{"cn": {"svc"}}},
+		{dn: "uid=alice,ou=people,dc=example,dc=org", password: "alice-secret", attributes: map[string][]string{
+			"uid": {"alice"}, "mail": {"alice@example.org"}, "cn": {"Alice Liddell"},
+		}},
+		{dn: "uid=bob,ou=people,dc=example,dc=org", password: "bob-secret", attributes: map[string][]string{
+			"uid": {"bob"}, "mail": {"team@example.org"}, "cn": {"Bob"},
+		}},
+		{dn: "uid=carol,ou=people,dc=example,dc=org", password: "carol-secret", attributes: map[string][]string{
+			"uid": {"carol"}, "mail": {"team@example.org"}, "cn": {"Carol"},
+		}},
+		{dn: "cn=admins,ou=groups,dc=example,dc=org", attributes: map[string][]string{
+			"cn": {"admins"}, "member": {"uid=alice,ou=people,dc=example,dc=org"},
+		}},
+		{dn: "cn=devs,ou=groups,dc=example,dc=org", attributes: map[string][]string{
+			"cn": {"devs"}, "member": {"uid=alice,ou=people,dc=example,dc=org", "uid=bob,ou=people,dc=example,dc=org"},
+		}},
+		{dn: "cn=ops,ou=groups,dc=example,dc=org", attributes: map[string][]string{
+			"cn": {"ops"}, "member": {"uid=bob,ou=people,dc=example,dc=org"},
+		}},
+	}}
+
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return server
+}
+
+func (s *testLDAPServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testLDAPServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	reader := bufio.NewReader(conn)
+	secure := false
+	reply := func(messageID int64, op []byte) {
+		conn.Write(berConstruct(berTagSequence, berInteger(berTagInteger, messageID), op))
+	}
+
+	for {
+		packet, err := berRead(reader)
+		if err != nil {
+			return
+		}
+		messageID, op := packet.Children[0].integer(), packet.Children[1]
+
+		switch op.Tag {
+		case ldapBindRequest:
+			dn, password := string(op.Children[1].Value), string(op.Children[2].Value)
+			s.mu.Lock()
+			s.binds = append(s.binds, dn)
+			s.mu.Unlock()
+
+			code := int64(ldapResultInvalidCreds)
+			for _, entry := range s.entries {
+				if entry.dn == dn && entry.password != "" && entry.password == password {
+					code = ldapResultSuccess
+				}
+			}
+			reply(messageID, ldapTestResult(ldapBindResponse, code))
+		case ldapSearchRequest:
+			base, sizeLimit, filter := strings.ToLower(string(op.Children[0].Value)), op.Children[3].integer(), op.Children[6]
+			s.mu.Lock()
+			s.filters = append(s.filters, filter)
+			s.plainSearch = s.plainSearch || !secure
+			s.mu.Unlock()
+
+			sent := int64(0)
+			for _, entry := range s.entries {
+				if !strings.HasSuffix(strings.ToLower(entry.dn), base) || !s.match(filter, entry) {
+					continue
+				}
+				if sizeLimit > 0 && sent == sizeLimit {
+					reply(messageID, ldapTestResult(ldapSearchResultDone, 4))
+					return
+				}
+				reply(messageID, ldapTestEntry(entry))
+				sent++
+			}
+			reply(messageID, ldapTestResult(ldapSearchResultDone, ldapResultSuccess))
+		case ldapExtendedRequest:
+			if s.tlsConfig == nil || string(op.Children[0].Value) != ldapStartTLSOID {
+				reply(messageID, ldapTestResult(ldapExtendedResponse, 2))
+				continue
+			}
+			reply(messageID, ldapTestResult(ldapExtendedResponse, ldapResultSuccess))
+
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, reader, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case ldapUnbindRequest:
+			return
+		default:
+			s.t.Errorf("unexpected LDAP operation 0x%x", op.Tag)
+			return
+		}
+	}
+}
+
+// match Evaluate the subset of filters the client sends: and, or, not, equality and presence
+func (s *testLDAPServer) match(filter *berPacket, entry *testLDAPEntry) bool {
+	switch filter.Tag {
+	case ldapFilterAnd:
+		for _, child := range filter.Children {
+			if !s.match(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldapFilterOr:
+		for _, child := range filter.Children {
+			if s.match(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldapFilterNot:
+		return !s.match(filter.Children[0], entry)
+	case ldapFilterEquality:
+		attribute, value := strings.ToLower(string(filter.Children[0].Value)), string(filter.Children[1].Value)
+		for _, candidate := range entry.attributes[attribute] {
+			if strings.EqualFold(candidate, value) {
+				return true
+			}
+		}
+		return false
+	case ldapFilterPresent:
+		return len(entry.attributes[strings.ToLower(string(filter.Value))]) > 0
+	default:
+		s.t.Errorf("unexpected LDAP filter 0x%x", filter.Tag)
+		return false
+	}
+}
+
+func (s *testLDAPServer) lastFilter() *berPacket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filters[len(s.filters)-1]
+}
+
+func (s *testLDAPServer) boundDNs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.binds...)
+}
+
+func ldapTestResult(tag byte, code int64) []byte {
+	return berConstruct(tag, berInteger(berTagEnumerated, code), berString(berTagOctetString, ""), berString(berTagOctetString, ""))
+}
+
+func ldapTestEntry(entry *testLDAPEntry) []byte {
+	var attributes [][]byte
+	for name, values := range entry.attributes {
+		var encodedValues [][]byte
+		for _, value := range values {
+			encodedValues = append(encodedValues, berString(berTagOctetString, value))
+		}
+		attributes = append(attributes, berConstruct(berTagSequence, berString(berTagOctetString, name), berConstruct(berTagSet, encodedValues...)))
+	}
+
+	return berConstruct(ldapSearchResultEntry, berString(berTagOctetString, entry.dn), berConstruct(berTagSequence, attributes...))
+}
+
+// testLDAPCertificate Generate a self-signed certificate for 127.0.0.1, returned with its PEM for the client
+func testLDAPCertificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldap test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func testLDAPConfig(server *testLDAPServer) models.LDAPConfig {
+	return models.LDAPConfig{
+		URL:             server.url(),
+		BindDN:          "cn=svc,dc=example,dc=org",
+		BindPassword:    "svc-secret",
+		UserSearchBase:  "ou=people,dc=example,dc=org",
+		GroupSearchBase: "ou=groups,dc=example,dc=org",
+	}
+}
+
+func TestAuthenticateLDAP(t *testing.T) {
+	server := newTestLDAPServer(t, nil)
+
+	user, err := AuthenticateLDAP(testLDAPConfig(server), "alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("AuthenticateLDAP() error = %v", err)
+	}
+
+	if user.DN != "uid=alice,ou=people,dc=example,dc=org" || user.Username != "alice" || user.Email != "alice@example.org" || user.Name != "Alice Liddell" {
+		t.Errorf("AuthenticateLDAP() user = %+v", user)
+	}
+
+	groups, _ := user.Claims["groups"].([]string)
+	sort.Strings(groups)
+	if !reflect.DeepEqual(groups, []string{"admins", "devs"}) {
+		t.Errorf("groups = %v, want [admins devs]", groups)
+	}
+
+	binds := server.boundDNs()
+	if !reflect.DeepEqual(binds, []string{"cn=svc,dc=example,dc=org", "uid=alice,ou=people,dc=example,dc=org"}) {
+		t.Errorf("binds = %v, want the service account then the user", binds)
+	}
+}
+
+func TestAuthenticateLDAPInvalidCredentials(t *testing.T) {
+	server := newTestLDAPServer(t, nil)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "alice", "wrong"},
+		{"unknown user", "mallory", "alice-secret"},
+		{"empty password", "alice", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := AuthenticateLDAP(testLDAPConfig(server), tt.username, tt.password); !errors.Is(err, ErrLDAPInvalidCredentials) {
+				t.Errorf("AuthenticateLDAP() error = %v, want ErrLDAPInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateLDAPServiceBindFailure(t *testing.T) {
+	server := newTestLDAPServer(t, nil)
+
+	config := testLDAPConfig(server)
+	config.BindPassword = "wrong"
+
+	_, err := AuthenticateLDAP(config, "alice", "alice-secret")
+	if err == nil || errors.Is(err, ErrLDAPInvalidCredentials) || !strings.Contains(err.Error(), "service account") {
+		t.Errorf("AuthenticateLDAP() error = %v, want a service account bind error", err)
+	}
+}
+
+func TestAuthenticateLDAPAmbiguousSearch(t *testing.T) {
+	server := newTestLDAPServer(t, nil)
+
+	config := testLDAPConfig(server)
+	config.UserSearchFilter = "(|(uid={username})(mail={username}))"
+
+	// bob and carol share the address, neither of them may be picked
+	if _, err := AuthenticateLDAP(config, "team@example.org", "bob-secret"); !errors.Is(err, ErrLDAPInvalidCredentials) {
+		t.Errorf("AuthenticateLDAP() error = %v, want ErrLDAPInvalidCredentials", err)
+	}
+
+	for _, dn := range server.boundDNs() {
+		if dn != config.BindDN {
+			t.Errorf("bound as %s, only the service account may bind when the search is ambiguous", dn)
+		}
+	}
+}
+
+func TestAuthenticateLDAPFilterEscaping(t *testing.T) {
+	server := newTestLDAPServer(t, nil)
+
+	usernames := []string{"*", "alice)(uid=*", "a*()\\\x00b", "x\\2a"}
+	for _, username := range usernames {
+		if _, err := AuthenticateLDAP(testLDAPConfig(server), username, "alice-secret"); !errors.Is(err, ErrLDAPInvalidCredentials) {
+			t.Errorf("AuthenticateLDAP(%q) error = %v, want ErrLDAPInvalidCredentials", username, err)
+		}
+
+		// the server must see a single equality match on the raw value, not a wildcard or an injected clause
+		filter := server.lastFilter()
+		if filter.Tag != ldapFilterEquality || string(filter.Children[0].Value) != "uid" || string(filter.Children[1].Value) != username {
+			t.Errorf("filter for %q = tag 0x%x %q, want an equality match on the raw value", username, filter.Tag, filter.Value)
+		}
+	}
+}
+
+func TestEscapeLDAPFilter(t *testing.T) {
+	tests := map[string]string{
+		"alice":       "alice",
+		"*":           "\\2a",
+		"a(b)c":       "a\\28b\\29c",
+		"back\\slash": "back\\5cslash",
+		"nul\x00":     "nul\\00",
+		"é":           "\\c3\\a9",
+	}
+
+	for value, want := range tests {
+		if got := EscapeLDAPFilter(value); got != want {
+			t.Errorf("EscapeLDAPFilter(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestCompileLDAPFilterInvalid(t *testing.T) {
+	for _, filter := range []string{"(uid=a", "(&)", "(=a)", "(uid=\\zz)", "(uid=a))"} {
+		if _, err := CompileLDAPFilter(filter); err == nil {
+			t.Errorf("CompileLDAPFilter(%q) error = nil, want an error", filter)
+		}
+	}
+}
+
+func TestAuthenticateLDAPStartTLS(t *testing.T) {
+	certificate, certificatePEM := testLDAPCertificate(t)
+	server := newTestLDAPServer(t, &tls.Config{Certificates: []tls.Certificate{certificate}})
+
+	config := testLDAPConfig(server)
+	config.StartTLS = true
+	config.CACertificate = certificatePEM
+
+	user, err := AuthenticateLDAP(config, "alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("AuthenticateLDAP() error = %v", err)
+	}
+	if user.Username != "alice" {
+		t.Errorf("Username = %q, want alice", user.Username)
+	}
+
+	server.mu.Lock()
+	plainSearch := server.plainSearch
+	server.mu.Unlock()
+	if plainSearch {
+		t.Error("searched before TLS was started")
+	}
+}
+
+func TestAuthenticateLDAPStartTLSUntrusted(t *testing.T) {
+	certificate, _ := testLDAPCertificate(t)
+	_, otherPEM := testLDAPCertificate(t)
+	server := newTestLDAPServer(t, &tls.Config{Certificates: []tls.Certificate{certificate}})
+
+	config := testLDAPConfig(server)
+	config.StartTLS = true
+	config.CACertificate = otherPEM
+
+	if _, err := AuthenticateLDAP(config, "alice", "alice-secret"); err == nil || !strings.Contains(err.Error(), "TLS") {
+		t.Errorf("AuthenticateLDAP() error = %v, want a TLS error", err)
+	}
+
+	if binds := server.boundDNs(); len(binds) != 0 {
+		t.Errorf("binds = %v, no credentials may be sent over an untrusted connection", binds)
+	}
+}
@@ -1,13 +1,46 @@
 package utils
 
 import (
+	"bufio"
+	"crypto/rand"
+	_ "embed"
+	"errors"
 	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"unicode"
 
+	"github.com/thuongnn/clst-mgt-api/config"
 	"golang.org/x/crypto/bcrypt"
 )
 
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var (
+	commonPasswordsOnce sync.Once
+	commonPasswords     map[string]bool
+)
+
+const (
+	passwordLowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	passwordUpperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	passwordDigitChars  = "0123456789"
+	passwordSymbolChars = "!@#$%^&*()-_=+[]{}.,:;?"
+)
+
+// passwordHashCost Get the configured bcrypt cost, an out of range value falls back to the default
+func passwordHashCost() int {
+	appConfig, err := config.LoadConfig(".")
+	if err != nil || appConfig.PasswordHashCost < bcrypt.MinCost || appConfig.PasswordHashCost > bcrypt.MaxCost {
+		return bcrypt.DefaultCost
+	}
+	return appConfig.PasswordHashCost
+}
+
 func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost())
 
 	if err != nil {
 		return "", fmt.Errorf("could not hash password %w", err)
@@ -18,3 +51,160 @@ func HashPassword(password string) (string, error) {
 func VerifyPassword(hashedPassword string, candidatePassword string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(candidatePassword))
 }
+
+// PasswordNeedsRehash Report whether the hash was made with another cost than the configured one
+func PasswordNeedsRehash(hashedPassword string) bool {
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return err == nil && cost != passwordHashCost()
+}
+
+// PasswordHistorySize Get how many previous passwords can't be reused
+func PasswordHistorySize() int {
+	appConfig, err := config.LoadConfig(".")
+	if err != nil || appConfig.PasswordHistory < 0 {
+		return 0
+	}
+	return appConfig.PasswordHistory
+}
+
+// PasswordReused Report whether the password matches the current hash or one of the remembered ones
+func PasswordReused(password string, currentHash string, history []string) bool {
+	size := PasswordHistorySize()
+	if size == 0 {
+		return false
+	}
+
+	if currentHash != "" && VerifyPassword(currentHash, password) == nil {
+		return true
+	}
+
+	if len(history) > size {
+		history = history[len(history)-size:]
+	}
+	for _, hash := range history {
+		if VerifyPassword(hash, password) == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidatePassword Check the password against the configured policy, the identifiers
+// (username, email) of the account can't be used as the password
+func ValidatePassword(password string, identifiers ...string) error {
+	appConfig, err := config.LoadConfig(".")
+	if err != nil {
+		return err
+	}
+
+	minLength := appConfig.PasswordMinLength
+	if minLength <= 0 {
+		minLength = config.DefaultPasswordMinLength
+	}
+
+	if len([]rune(password)) < minLength {
+		return fmt.Errorf("invalid password: it must have at least %d characters", minLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("invalid password: it must have at most %d bytes", MaxPasswordLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	var missing []string
+	if appConfig.PasswordRequireUpper && !hasUpper {
+		missing = append(missing, "an uppercase letter")
+	}
+	if appConfig.PasswordRequireLower && !hasLower {
+		missing = append(missing, "a lowercase letter")
+	}
+	if appConfig.PasswordRequireDigit && !hasDigit {
+		missing = append(missing, "a digit")
+	}
+	if appConfig.PasswordRequireSymbol && !hasSymbol {
+		missing = append(missing, "a symbol")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("invalid password: it must contain %s", strings.Join(missing, ", "))
+	}
+
+	lowered := strings.ToLower(password)
+	for _, identifier := range identifiers {
+		identifier = strings.ToLower(identifier)
+		if local, _, found := strings.Cut(identifier, "@"); found {
+			identifier = local
+		}
+		if identifier != "" && lowered == identifier {
+			return errors.New("invalid password: it can't be your username or email")
+		}
+	}
+
+	if IsCommonPassword(password) {
+		return errors.New("invalid password: it is too common")
+	}
+
+	return nil
+}
+
+// IsCommonPassword Report whether the password is in the bundled list of commonly used passwords
+func IsCommonPassword(password string) bool {
+	commonPasswordsOnce.Do(func() {
+		commonPasswords = map[string]bool{}
+		scanner := bufio.NewScanner(strings.NewReader(commonPasswordList))
+		for scanner.Scan() {
+			if word := strings.TrimSpace(scanner.Text()); word != "" {
+				commonPasswords[word] = true
+			}
+		}
+	})
+
+	return commonPasswords[strings.ToLower(password)]
+}
+
+// GeneratePassword Generate a random password with every character class, so it passes any policy
+func GeneratePassword(length int) (string, error) {
+	classes := []string{passwordLowerChars, passwordUpperChars, passwordDigitChars, passwordSymbolChars}
+	if length < len(classes) {
+		length = len(classes)
+	}
+
+	all := strings.Join(classes, "")
+	password := make([]byte, length)
+	for i := range password {
+		chars := all
+		if i < len(classes) {
+			chars = classes[i]
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return "", fmt.Errorf("could not generate password: %w", err)
+		}
+		password[i] = chars[n.Int64()]
+	}
+
+	// shuffle so the class characters aren't always at the start
+	for i := len(password) - 1; i > 0; i-- {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", fmt.Errorf("could not generate password: %w", err)
+		}
+		j := n.Int64()
+		password[i], password[j] = password[j], password[i]
+	}
+
+	return string(password), nil
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/thuongnn/clst-mgt-api/models"
+)
+
+// ProbeTCP Open a TCP connection to hostPort and close it right away
+func ProbeTCP(hostPort string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", hostPort, timeout)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// ProbeUDP Send the mesh probe payload and wait for the responder to acknowledge it.
+// A plain UDP dial never fails, so without an answer we can't tell open from dropped.
+func ProbeUDP(hostPort string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("udp", hostPort, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	if _, err := conn.Write([]byte(MeshProbePayload)); err != nil {
+		return err
+	}
+
+	buffer := make([]byte, len(MeshAckPayload))
+	n, err := conn.Read(buffer)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return fmt.Errorf("no response from mesh responder on %s/udp", hostPort)
+		}
+		return err
+	}
+
+	if string(buffer[:n]) != MeshAckPayload {
+		return fmt.Errorf("unexpected response from %s/udp", hostPort)
+	}
+
+	return nil
+}
+
+// ProbeUDPService Send an empty datagram to a real UDP service, which won't answer the mesh payload.
+// A reply or silence both mean open|filtered since most services drop what they can't parse, only
+// an ICMP port unreachable (refused on the connected socket) proves the port is closed. Any other
+// error leaves the target unverifiable rather than failed.
+func ProbeUDPService(hostPort string, timeout time.Duration) (string, error) {
+	conn, err := net.DialTimeout("udp", hostPort, timeout)
+	if err != nil {
+		return StatusUnverifiableScan, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return StatusUnverifiableScan, err
+	}
+
+	if _, err := conn.Write([]byte{}); err != nil {
+		return udpServiceError(hostPort, err)
+	}
+
+	if _, err := conn.Read(make([]byte, 512)); err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return StatusOpenFilteredScan, nil
+		}
+		return udpServiceError(hostPort, err)
+	}
+
+	return StatusOpenFilteredScan, nil
+}
+
+func udpServiceError(hostPort string, err error) (string, error) {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return StatusErrorScan, fmt.Errorf("%s/udp is closed, the host answered port unreachable", hostPort)
+	}
+
+	return StatusUnverifiableScan, err
+}
+
+// NodeProbeAddress Pick the address used to reach a node, preferring the internal IP
+func NodeProbeAddress(address models.DBNodeAddress) string {
+	switch {
+	case address.InternalIP != "":
+		return address.InternalIP
+	case address.ExternalIP != "":
+		return address.ExternalIP
+	default:
+		return address.Hostname
+	}
+}
+
+// ResolveHost Resolve hostname through the given DNS servers in order, or through the node's
+// resolver when none is set. It returns the answer and the server that gave it.
+func ResolveHost(hostname string, dnsServers []string, timeout time.Duration) ([]string, string, error) {
+	if len(dnsServers) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		addresses, err := net.DefaultResolver.LookupHost(ctx, hostname)
+		return addresses, SystemDNSServer, err
+	}
+
+	var lastErr error
+	var server string
+	for _, dnsServer := range dnsServers {
+		server = dnsServer
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, DefaultDNSPort)
+		}
+
+		serverHostPort := server
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				dialer := net.Dialer{Timeout: timeout}
+				return dialer.DialContext(ctx, network, serverHostPort)
+			},
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		addresses, err := resolver.LookupHost(ctx, hostname)
+		cancel()
+
+		if err == nil {
+			return addresses, server, nil
+		}
+		lastErr = err
+	}
+
+	return nil, server, lastErr
+}
+
+// DialResolved Dial the resolved addresses in order and return the first connection that succeeds
+func DialResolved(protocol string, addresses []string, port string, timeout time.Duration) (net.Conn, error) {
+	var lastErr error
+	for _, address := range addresses {
+		conn, err := net.DialTimeout(protocol, net.JoinHostPort(address, port), timeout)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// BuildClientTLSConfig Build the TLS config presenting the client certificate of a decrypted credential
+func BuildClientTLSConfig(credential *models.Credential) (*tls.Config, error) {
+	certificate, err := tls.X509KeyPair([]byte(credential.Certificate), []byte(credential.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate or private key: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates:       []tls.Certificate{certificate},
+		InsecureSkipVerify: credential.InsecureSkipVerify,
+	}
+
+	if strings.TrimSpace(credential.CACertificate) != "" {
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM([]byte(credential.CACertificate)) {
+			return nil, fmt.Errorf("invalid CA certificate")
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	return tlsConfig, nil
+}
+
+// ProbeTLS Perform the TLS handshake over an open connection and return the alert sent by the
+// server, if any. TLS 1.3 servers only reject a client certificate after the handshake, so we
+// also wait briefly for an alert on the first read.
+func ProbeTLS(conn net.Conn, tlsConfig *tls.Config) (string, error) {
+	tlsConn := tls.Client(conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(TimeoutTLS))
+
+	if err := tlsConn.Handshake(); err != nil {
+		return TLSAlert(err), err
+	}
+
+	tlsConn.SetReadDeadline(time.Now().Add(TimeoutTLSAlert))
+	if _, err := tlsConn.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return "", nil
+		}
+		return TLSAlert(err), err
+	}
+
+	return "", nil
+}
+
+// TLSAlert Extract the alert description from a TLS error returned by the server
+func TLSAlert(err error) string {
+	const remoteErrorPrefix = "remote error: tls: "
+
+	message := err.Error()
+	if index := strings.Index(message, remoteErrorPrefix); index >= 0 {
+		return message[index+len(remoteErrorPrefix):]
+	}
+
+	return ""
+}
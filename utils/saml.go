@@ -0,0 +1,516 @@
+package utils
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thuongnn/clst-mgt-api/models"
+)
+
+const (
+	samlProtocolNS      = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlAssertionNS     = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlMetadataNS      = "urn:oasis:names:tc:SAML:2.0:metadata"
+	samlStatusSuccess   = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearer          = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlHTTPPostBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlDefaultNameID   = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	samlClockSkew       = 2 * time.Minute
+	maxSAMLResponseSize = 512 * 1024
+
+	xmldsigNS              = "http://www.w3.org/2000/09/xmldsig#"
+	xmldsigEnveloped       = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	xmldsigRSASHA256       = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	xmldsigRSASHA512       = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	xmldsigDigestSHA256    = "http://www.w3.org/2001/04/xmlenc#sha256"
+	xmldsigDigestSHA512    = "http://www.w3.org/2001/04/xmlenc#sha512"
+	xmldsigInclusivePrefix = "InclusiveNamespaces"
+)
+
+// ParseSAMLCertificate Parse the IdP signing certificate, PEM or the bare base64 found in IdP metadata
+func ParseSAMLCertificate(certificate string) (*x509.Certificate, error) {
+	certificate = strings.TrimSpace(certificate)
+
+	var der []byte
+	if block, _ := pem.Decode([]byte(certificate)); block != nil {
+		der = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(removeWhitespace(certificate))
+		if err != nil {
+			return nil, fmt.Errorf("invalid IdP certificate: %w", err)
+		}
+		der = decoded
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IdP certificate: %w", err)
+	}
+
+	if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("invalid IdP certificate: only RSA keys are supported")
+	}
+
+	return cert, nil
+}
+
+// ValidateSAMLConfig Check the configs of a SAML auth method
+func ValidateSAMLConfig(samlInfo models.SAMLConfig) error {
+	if strings.TrimSpace(samlInfo.IdPEntityID) == "" {
+		return fmt.Errorf("invalid SAML config: idp_entity_id is required")
+	}
+
+	for name, value := range map[string]string{"idp_sso_url": samlInfo.IdPSSOURL, "acs_url": samlInfo.ACSURL} {
+		parsedURL, err := url.Parse(value)
+		if err != nil || (parsedURL.Scheme != "https" && parsedURL.Scheme != "http") || parsedURL.Host == "" {
+			return fmt.Errorf("invalid SAML config: %s must be an absolute http(s) URL", name)
+		}
+	}
+
+	if strings.TrimSpace(samlInfo.SPEntityID) == "" {
+		return fmt.Errorf("invalid SAML config: sp_entity_id is required")
+	}
+
+	if _, err := ParseSAMLCertificate(samlInfo.IdPCertificate); err != nil {
+		return fmt.Errorf("invalid SAML config: %w", err)
+	}
+
+	return ValidateGroupMappings(samlInfo.GroupMappings)
+}
+
+// NewSAMLRequestId Generate the id of an AuthnRequest, it has to be kept until the response comes back
+func NewSAMLRequestId() (string, error) {
+	randomId, err := GenerateRandomToken(20)
+	if err != nil {
+		return "", err
+	}
+	// xs:ID must not start with a digit
+	return "_" + randomId, nil
+}
+
+// SAMLAuthnRequestURL Build the IdP URL of an AuthnRequest with the HTTP-Redirect binding
+func SAMLAuthnRequestURL(samlInfo models.SAMLConfig, requestId string, relayState string) (string, error) {
+	nameIDFormat := samlInfo.NameIDFormat
+	if nameIDFormat == "" {
+		nameIDFormat = samlDefaultNameID
+	}
+
+	var request bytes.Buffer
+	request.WriteString(`<samlp:AuthnRequest xmlns:samlp="` + samlProtocolNS + `" xmlns:saml="` + samlAssertionNS + `"`)
+	request.WriteString(` ID="` + requestId + `" Version="2.0"`)
+	request.WriteString(` IssueInstant="` + time.Now().UTC().Format(time.RFC3339) + `"`)
+	request.WriteString(` Destination="` + escapeC14NAttr(samlInfo.IdPSSOURL) + `"`)
+	request.WriteString(` AssertionConsumerServiceURL="` + escapeC14NAttr(samlInfo.ACSURL) + `"`)
+	request.WriteString(` ProtocolBinding="` + samlHTTPPostBinding + `">`)
+	request.WriteString(`<saml:Issuer>` + escapeC14NText(samlInfo.SPEntityID) + `</saml:Issuer>`)
+	request.WriteString(`<samlp:NameIDPolicy Format="` + escapeC14NAttr(nameIDFormat) + `" AllowCreate="true"/>`)
+	request.WriteString(`</samlp:AuthnRequest>`)
+
+	var deflated bytes.Buffer
+	writer, _ := flate.NewWriter(&deflated, flate.BestCompression)
+	if _, err := writer.Write(request.Bytes()); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	redirectURL, err := url.Parse(samlInfo.IdPSSOURL)
+	if err != nil {
+		return "", err
+	}
+	query := redirectURL.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	query.Set("RelayState", relayState)
+	redirectURL.RawQuery = query.Encode()
+
+	return redirectURL.String(), nil
+}
+
+type samlEntityDescriptor struct {
+	XMLName         xml.Name            `xml:"md:EntityDescriptor"`
+	Namespace       string              `xml:"xmlns:md,attr"`
+	EntityID        string              `xml:"entityID,attr"`
+	SPSSODescriptor samlSPSSODescriptor `xml:"md:SPSSODescriptor"`
+}
+
+type samlSPSSODescriptor struct {
+	AuthnRequestsSigned        bool                         `xml:"AuthnRequestsSigned,attr"`
+	WantAssertionsSigned       bool                         `xml:"WantAssertionsSigned,attr"`
+	ProtocolSupportEnumeration string                       `xml:"protocolSupportEnumeration,attr"`
+	NameIDFormat               string                       `xml:"md:NameIDFormat"`
+	AssertionConsumerService   samlAssertionConsumerService `xml:"md:AssertionConsumerService"`
+}
+
+type samlAssertionConsumerService struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+	Index    int    `xml:"index,attr"`
+}
+
+// SAMLMetadata Build the SP metadata document to register the application at the IdP
+func SAMLMetadata(samlInfo models.SAMLConfig) ([]byte, error) {
+	nameIDFormat := samlInfo.NameIDFormat
+	if nameIDFormat == "" {
+		nameIDFormat = samlDefaultNameID
+	}
+
+	metadata, err := xml.MarshalIndent(samlEntityDescriptor{
+		Namespace: samlMetadataNS,
+		EntityID:  samlInfo.SPEntityID,
+		SPSSODescriptor: samlSPSSODescriptor{
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: samlProtocolNS,
+			NameIDFormat:               nameIDFormat,
+			AssertionConsumerService: samlAssertionConsumerService{
+				Binding:  samlHTTPPostBinding,
+				Location: samlInfo.ACSURL,
+				Index:    0,
+			},
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), metadata...), nil
+}
+
+// ParseSAMLResponse Verify a base64 encoded Response posted to the ACS and extract the user.
+// The assertion must be signed by the IdP certificate, directly or through the response,
+// and answer the AuthnRequest with the request id.
+func ParseSAMLResponse(samlInfo models.SAMLConfig, encodedResponse string, requestId string, now time.Time) (*models.SAMLUser, error) {
+	if len(encodedResponse) > maxSAMLResponseSize {
+		return nil, fmt.Errorf("SAML response is too large")
+	}
+
+	rawResponse, err := base64.StdEncoding.DecodeString(removeWhitespace(encodedResponse))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAML response encoding: %w", err)
+	}
+
+	cert, err := ParseSAMLCertificate(samlInfo.IdPCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := parseXMLDocument(rawResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.Is(samlProtocolNS, "Response") {
+		return nil, fmt.Errorf("SAML response has no Response element")
+	}
+
+	// duplicated ids are the basis of signature wrapping attacks
+	ids := map[string]bool{}
+	duplicateId := false
+	response.walk(func(element *xmlElement) {
+		if id := element.Attr("ID"); id != "" {
+			duplicateId = duplicateId || ids[id]
+			ids[id] = true
+		}
+	})
+	if duplicateId {
+		return nil, fmt.Errorf("SAML response contains duplicated ids")
+	}
+
+	if destination := response.Attr("Destination"); destination != "" && destination != samlInfo.ACSURL {
+		return nil, fmt.Errorf("SAML response destination does not match the ACS URL")
+	}
+
+	if response.Attr("InResponseTo") != requestId {
+		return nil, fmt.Errorf("SAML response does not answer the login request")
+	}
+
+	if issuer := response.ChildElement(samlAssertionNS, "Issuer"); issuer != nil && strings.TrimSpace(issuer.Text()) != samlInfo.IdPEntityID {
+		return nil, fmt.Errorf("SAML response issuer does not match the IdP entity id")
+	}
+
+	status := response.ChildElement(samlProtocolNS, "Status")
+	if status == nil {
+		return nil, fmt.Errorf("SAML response has no status")
+	}
+	statusCode := status.ChildElement(samlProtocolNS, "StatusCode")
+	if statusCode == nil || statusCode.Attr("Value") != samlStatusSuccess {
+		return nil, fmt.Errorf("SAML login failed at the IdP")
+	}
+
+	if len(response.ChildElements(samlAssertionNS, "EncryptedAssertion")) > 0 {
+		return nil, fmt.Errorf("encrypted SAML assertions are not supported")
+	}
+
+	assertion := response.ChildElement(samlAssertionNS, "Assertion")
+	if assertion == nil {
+		return nil, fmt.Errorf("SAML response must contain exactly one assertion")
+	}
+
+	// the data is read from the very nodes that were verified, never looked up again by id
+	responseSigned := len(response.ChildElements(xmldsigNS, "Signature")) > 0
+	if responseSigned {
+		if err := verifyXMLSignature(response, cert); err != nil {
+			return nil, err
+		}
+	}
+	if len(assertion.ChildElements(xmldsigNS, "Signature")) > 0 {
+		if err := verifyXMLSignature(assertion, cert); err != nil {
+			return nil, err
+		}
+	} else if !responseSigned {
+		return nil, fmt.Errorf("SAML assertion is not signed")
+	}
+
+	return parseSAMLAssertion(samlInfo, assertion, requestId, now)
+}
+
+func parseSAMLAssertion(samlInfo models.SAMLConfig, assertion *xmlElement, requestId string, now time.Time) (*models.SAMLUser, error) {
+	issuer := assertion.ChildElement(samlAssertionNS, "Issuer")
+	if issuer == nil || strings.TrimSpace(issuer.Text()) != samlInfo.IdPEntityID {
+		return nil, fmt.Errorf("SAML assertion issuer does not match the IdP entity id")
+	}
+
+	conditions := assertion.ChildElement(samlAssertionNS, "Conditions")
+	if conditions == nil {
+		return nil, fmt.Errorf("SAML assertion has no conditions")
+	}
+	if err := checkSAMLTimeWindow(conditions, now); err != nil {
+		return nil, err
+	}
+	for _, restriction := range conditions.ChildElements(samlAssertionNS, "AudienceRestriction") {
+		allowed := false
+		for _, audience := range restriction.ChildElements(samlAssertionNS, "Audience") {
+			allowed = allowed || strings.TrimSpace(audience.Text()) == samlInfo.SPEntityID
+		}
+		if !allowed {
+			return nil, fmt.Errorf("SAML assertion is not meant for this service provider")
+		}
+	}
+
+	subject := assertion.ChildElement(samlAssertionNS, "Subject")
+	if subject == nil {
+		return nil, fmt.Errorf("SAML assertion has no subject")
+	}
+	nameID := subject.ChildElement(samlAssertionNS, "NameID")
+	if nameID == nil || strings.TrimSpace(nameID.Text()) == "" {
+		return nil, fmt.Errorf("SAML assertion has no NameID")
+	}
+
+	confirmed := false
+	for _, confirmation := range subject.ChildElements(samlAssertionNS, "SubjectConfirmation") {
+		if confirmation.Attr("Method") != samlBearer {
+			continue
+		}
+		data := confirmation.ChildElement(samlAssertionNS, "SubjectConfirmationData")
+		if data == nil || data.Attr("Recipient") != samlInfo.ACSURL || data.Attr("NotOnOrAfter") == "" {
+			continue
+		}
+		if inResponseTo := data.Attr("InResponseTo"); inResponseTo != "" && inResponseTo != requestId {
+			continue
+		}
+		if checkSAMLTimeWindow(data, now) != nil {
+			continue
+		}
+		confirmed = true
+	}
+	if !confirmed {
+		return nil, fmt.Errorf("SAML assertion has no valid bearer subject confirmation")
+	}
+
+	claims := map[string]interface{}{}
+	for _, statement := range assertion.ChildElements(samlAssertionNS, "AttributeStatement") {
+		for _, attribute := range statement.ChildElements(samlAssertionNS, "Attribute") {
+			var values []string
+			for _, value := range attribute.ChildElements(samlAssertionNS, "AttributeValue") {
+				values = append(values, strings.TrimSpace(value.Text()))
+			}
+
+			if name := attribute.Attr("Name"); name != "" {
+				claims[name] = values
+			}
+			if friendlyName := attribute.Attr("FriendlyName"); friendlyName != "" {
+				if _, exists := claims[friendlyName]; !exists {
+					claims[friendlyName] = values
+				}
+			}
+		}
+	}
+
+	user := &models.SAMLUser{
+		NameID:   strings.TrimSpace(nameID.Text()),
+		Username: samlClaim(claims, samlInfo.UsernameAttribute),
+		Email:    samlClaim(claims, ldapAttributeOrDefault(samlInfo.EmailAttribute, "email")),
+		Name:     samlClaim(claims, ldapAttributeOrDefault(samlInfo.NameAttribute, "name")),
+		Claims:   claims,
+	}
+	if user.Username == "" {
+		user.Username = user.NameID
+	}
+
+	groups := []string{}
+	if values, ok := claims[ldapAttributeOrDefault(samlInfo.GroupsAttribute, "groups")].([]string); ok {
+		groups = values
+	}
+	claims["groups"] = groups
+
+	return user, nil
+}
+
+func samlClaim(claims map[string]interface{}, name string) string {
+	if values, ok := claims[name].([]string); ok && len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// checkSAMLTimeWindow Check the NotBefore and NotOnOrAfter attributes with some clock skew
+func checkSAMLTimeWindow(element *xmlElement, now time.Time) error {
+	if notBefore := element.Attr("NotBefore"); notBefore != "" {
+		t, err := time.Parse(time.RFC3339, notBefore)
+		if err != nil {
+			return fmt.Errorf("invalid SAML NotBefore: %w", err)
+		}
+		if now.Add(samlClockSkew).Before(t) {
+			return fmt.Errorf("SAML assertion is not valid yet")
+		}
+	}
+
+	if notOnOrAfter := element.Attr("NotOnOrAfter"); notOnOrAfter != "" {
+		t, err := time.Parse(time.RFC3339, notOnOrAfter)
+		if err != nil {
+			return fmt.Errorf("invalid SAML NotOnOrAfter: %w", err)
+		}
+		if !now.Add(-samlClockSkew).Before(t) {
+			return fmt.Errorf("SAML assertion has expired")
+		}
+	}
+
+	return nil
+}
+
+// verifyXMLSignature Verify the enveloped XML-DSig signature of the element with the certificate.
+// Only what SAML IdPs produce is accepted: one reference to the element itself, exclusive
+// canonicalization and RSA with SHA-256 or SHA-512.
+func verifyXMLSignature(element *xmlElement, cert *x509.Certificate) error {
+	signature := element.ChildElement(xmldsigNS, "Signature")
+	if signature == nil {
+		return fmt.Errorf("SAML element must contain exactly one signature")
+	}
+
+	signedInfo := signature.ChildElement(xmldsigNS, "SignedInfo")
+	if signedInfo == nil {
+		return fmt.Errorf("SAML signature has no SignedInfo")
+	}
+
+	c14nMethod := signedInfo.ChildElement(xmldsigNS, "CanonicalizationMethod")
+	if c14nMethod == nil || c14nMethod.Attr("Algorithm") != excC14NAlgorithm {
+		return fmt.Errorf("unsupported SAML canonicalization method")
+	}
+
+	signatureMethod := signedInfo.ChildElement(xmldsigNS, "SignatureMethod")
+	if signatureMethod == nil {
+		return fmt.Errorf("SAML signature has no SignatureMethod")
+	}
+	signatureHash, err := xmldsigHash(signatureMethod.Attr("Algorithm"), xmldsigRSASHA256, xmldsigRSASHA512)
+	if err != nil {
+		return err
+	}
+
+	reference := signedInfo.ChildElement(xmldsigNS, "Reference")
+	id := element.Attr("ID")
+	if reference == nil || id == "" || reference.Attr("URI") != "#"+id {
+		return fmt.Errorf("SAML signature does not reference the signed element")
+	}
+
+	var referencePrefixes []string
+	enveloped := false
+	if transforms := reference.ChildElement(xmldsigNS, "Transforms"); transforms != nil {
+		for _, transform := range transforms.ChildElements(xmldsigNS, "Transform") {
+			switch transform.Attr("Algorithm") {
+			case xmldsigEnveloped:
+				enveloped = true
+			case excC14NAlgorithm:
+				referencePrefixes = inclusiveNamespacePrefixes(transform)
+			default:
+				return fmt.Errorf("unsupported SAML signature transform")
+			}
+		}
+	}
+	if !enveloped {
+		return fmt.Errorf("SAML signature must be enveloped")
+	}
+
+	digestMethod := reference.ChildElement(xmldsigNS, "DigestMethod")
+	digestValue := reference.ChildElement(xmldsigNS, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return fmt.Errorf("SAML signature reference has no digest")
+	}
+	digestHash, err := xmldsigHash(digestMethod.Attr("Algorithm"), xmldsigDigestSHA256, xmldsigDigestSHA512)
+	if err != nil {
+		return err
+	}
+
+	expectedDigest, err := base64.StdEncoding.DecodeString(removeWhitespace(digestValue.Text()))
+	if err != nil {
+		return fmt.Errorf("invalid SAML digest value: %w", err)
+	}
+
+	hasher := digestHash.New()
+	hasher.Write(canonicalizeExclusive(element, referencePrefixes, signature))
+	if subtle.ConstantTimeCompare(hasher.Sum(nil), expectedDigest) != 1 {
+		return fmt.Errorf("SAML digest does not match, the content was modified")
+	}
+
+	signatureValue := signature.ChildElement(xmldsigNS, "SignatureValue")
+	if signatureValue == nil {
+		return fmt.Errorf("SAML signature has no SignatureValue")
+	}
+	rawSignature, err := base64.StdEncoding.DecodeString(removeWhitespace(signatureValue.Text()))
+	if err != nil {
+		return fmt.Errorf("invalid SAML signature value: %w", err)
+	}
+
+	hasher = signatureHash.New()
+	hasher.Write(canonicalizeExclusive(signedInfo, inclusiveNamespacePrefixes(c14nMethod), nil))
+	if err := rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), signatureHash, hasher.Sum(nil), rawSignature); err != nil {
+		return fmt.Errorf("SAML signature is invalid")
+	}
+
+	return nil
+}
+
+func xmldsigHash(algorithm string, sha256Algorithm string, sha512Algorithm string) (crypto.Hash, error) {
+	switch algorithm {
+	case sha256Algorithm:
+		return crypto.SHA256, nil
+	case sha512Algorithm:
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported SAML signature algorithm %q", algorithm)
+	}
+}
+
+func inclusiveNamespacePrefixes(method *xmlElement) []string {
+	inclusive := method.ChildElement(excC14NAlgorithm, xmldsigInclusivePrefix)
+	if inclusive == nil {
+		return nil
+	}
+	return strings.Fields(inclusive.Attr("PrefixList"))
+}
+
+func removeWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
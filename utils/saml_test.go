@@ -0,0 +1,374 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thuongnn/clst-mgt-api/models"
+)
+
+const (
+	testSAMLRequestId = "_request-1"
+	testSAMLACSURL    = "https://app.example.org/api/auth/saml/acs"
+	testSAMLIdP       = "https://idp.example.org/metadata"
+	testSAMLSP        = "https://app.example.org/api/auth/saml/metadata"
+)
+
+var testSAMLNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+type testSAMLSigner struct {
+	key            *rsa.PrivateKey
+	certificatePEM string
+}
+
+func newTestSAMLSigner(t *testing.T) *testSAMLSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.org"},
+		NotBefore:    testSAMLNow.Add(-24 * time.Hour),
+		NotAfter:     testSAMLNow.Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testSAMLSigner{key: key, certificatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))}
+}
+
+// sign Replace the {{sig:ID}} marker with an enveloped signature over the element with that ID.
+// Inner markers must be signed before the outer ones, like an IdP does.
+func (s *testSAMLSigner) sign(t *testing.T, document string, id string) string {
+	marker := "{{sig:" + id + "}}"
+
+	unsigned := document
+	for _, other := range []string{"{{sig:_response}}", "{{sig:_assertion}}"} {
+		unsigned = strings.ReplaceAll(unsigned, other, "")
+	}
+	root, err := parseXMLDocument([]byte(unsigned))
+	if err != nil {
+		t.Fatalf("could not parse the document to sign: %v", err)
+	}
+
+	var element *xmlElement
+	root.walk(func(e *xmlElement) {
+		if e.Attr("ID") == id && element == nil {
+			element = e
+		}
+	})
+	if element == nil {
+		t.Fatalf("no element with the ID %s", id)
+	}
+
+	digest := sha256.Sum256(canonicalizeExclusive(element, nil, nil))
+	signedInfo := fmt.Sprintf(`<ds:SignedInfo>`+
+		`<ds:CanonicalizationMethod Algorithm="%s"/>`+
+		`<ds:SignatureMethod Algorithm="%s"/>`+
+		`<ds:Reference URI="#%s"><ds:Transforms>`+
+		`<ds:Transform Algorithm="%s"/><ds:Transform Algorithm="%s"/>`+
+		`</ds:Transforms><ds:DigestMethod Algorithm="%s"/><ds:DigestValue>%s</ds:DigestValue></ds:Reference>`+
+		`</ds:SignedInfo>`,
+		excC14NAlgorithm, xmldsigRSASHA256, id, xmldsigEnveloped, excC14NAlgorithm, xmldsigDigestSHA256, base64.StdEncoding.EncodeToString(digest[:]))
+
+	signatureRoot, err := parseXMLDocument([]byte(`<ds:Signature xmlns:ds="` + xmldsigNS + `">` + signedInfo + `</ds:Signature>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha256.Sum256(canonicalizeExclusive(signatureRoot.ChildElement(xmldsigNS, "SignedInfo"), nil, nil))
+	signatureValue, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := `<ds:Signature xmlns:ds="` + xmldsigNS + `">` + signedInfo +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(signatureValue) + `</ds:SignatureValue></ds:Signature>`
+
+	return strings.Replace(document, marker, signature, 1)
+}
+
+type testSAMLResponse struct {
+	destination  string
+	inResponseTo string
+	audience     string
+	nameID       string
+	notOnOrAfter time.Time
+	extra        string // appended after the assertion
+}
+
+func defaultTestSAMLResponse() testSAMLResponse {
+	return testSAMLResponse{
+		destination:  testSAMLACSURL,
+		inResponseTo: testSAMLRequestId,
+		audience:     testSAMLSP,
+		nameID:       "alice@example.org",
+		notOnOrAfter: testSAMLNow.Add(5 * time.Minute),
+	}
+}
+
+func (r testSAMLResponse) assertion(id string, signed bool) string {
+	marker := ""
+	if signed {
+		marker = "{{sig:" + id + "}}"
+	}
+	notBefore, notOnOrAfter := testSAMLNow.Add(-time.Minute).Format(time.RFC3339), r.notOnOrAfter.Format(time.RFC3339)
+
+	return `<saml:Assertion xmlns:saml="` + samlAssertionNS + `" ID="` + id + `" Version="2.0" IssueInstant="` + notBefore + `">` +
+		`<saml:Issuer>` + testSAMLIdP + `</saml:Issuer>` + marker +
+		`<saml:Subject><saml:NameID>` + r.nameID + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="` + samlBearer + `">` +
+		`<saml:SubjectConfirmationData InResponseTo="` + r.inResponseTo + `" NotOnOrAfter="` + notOnOrAfter + `" Recipient="` + testSAMLACSURL + `"/>` +
+		`</saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotBefore="` + notBefore + `" NotOnOrAfter="` + notOnOrAfter + `">` +
+		`<saml:AudienceRestriction><saml:Audience>` + r.audience + `</saml:Audience></saml:AudienceRestriction>` +
+		`</saml:Conditions>` +
+		`<saml:AttributeStatement>` +
+		`<saml:Attribute Name="email"><saml:AttributeValue>alice@example.org</saml:AttributeValue></saml:Attribute>` +
+		`<saml:Attribute Name="name"><saml:AttributeValue>Alice Liddell</saml:AttributeValue></saml:Attribute>` +
+		`<saml:Attribute Name="groups"><saml:AttributeValue>admins</saml:AttributeValue><saml:AttributeValue>devs</saml:AttributeValue></saml:Attribute>` +
+		`</saml:AttributeStatement>` +
+		`</saml:Assertion>`
+}
+
+// document Render the Response, signResponse and signAssertion only place the markers for sign
+func (r testSAMLResponse) document(signResponse bool, signAssertion bool) string {
+	marker := ""
+	if signResponse {
+		marker = "{{sig:_response}}"
+	}
+
+	return `<samlp:Response xmlns:samlp="` + samlProtocolNS + `" xmlns:saml="` + samlAssertionNS + `" ID="_response" Version="2.0"` +
+		` IssueInstant="` + testSAMLNow.Format(time.RFC3339) + `" Destination="` + r.destination + `" InResponseTo="` + r.inResponseTo + `">` +
+		`<saml:Issuer>` + testSAMLIdP + `</saml:Issuer>` + marker +
+		`<samlp:Status><samlp:StatusCode Value="` + samlStatusSuccess + `"/></samlp:Status>` +
+		r.assertion("_assertion", signAssertion) + r.extra +
+		`</samlp:Response>`
+}
+
+func testSAMLConfig(signer *testSAMLSigner) models.SAMLConfig {
+	return models.SAMLConfig{
+		IdPEntityID:    testSAMLIdP,
+		IdPSSOURL:      "https://idp.example.org/sso",
+		IdPCertificate: signer.certificatePEM,
+		SPEntityID:     testSAMLSP,
+		ACSURL:         testSAMLACSURL,
+	}
+}
+
+func encodeTestSAMLResponse(document string) string {
+	return base64.StdEncoding.EncodeToString([]byte(document))
+}
+
+func TestParseSAMLResponse(t *testing.T) {
+	signer := newTestSAMLSigner(t)
+	response := defaultTestSAMLResponse()
+
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"response signature", signer.sign(t, response.document(true, false), "_response")},
+		{"assertion signature", signer.sign(t, response.document(false, true), "_assertion")},
+		{"both signatures", signer.sign(t, signer.sign(t, response.document(true, true), "_assertion"), "_response")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := ParseSAMLResponse(testSAMLConfig(signer), encodeTestSAMLResponse(tt.document), testSAMLRequestId, testSAMLNow)
+			if err != nil {
+				t.Fatalf("ParseSAMLResponse() error = %v", err)
+			}
+
+			if user.NameID != "alice@example.org" || user.Username != "alice@example.org" || user.Email != "alice@example.org" || user.Name != "Alice Liddell" {
+				t.Errorf("ParseSAMLResponse() user = %+v", user)
+			}
+			if groups := user.Claims["groups"]; !reflect.DeepEqual(groups, []string{"admins", "devs"}) {
+				t.Errorf("groups = %v, want [admins devs]", groups)
+			}
+		})
+	}
+}
+
+func TestParseSAMLResponseRejected(t *testing.T) {
+	signer := newTestSAMLSigner(t)
+	otherSigner := newTestSAMLSigner(t)
+	response := defaultTestSAMLResponse()
+	signedAssertion := signer.sign(t, response.document(false, true), "_assertion")
+
+	withResponse := func(change func(*testSAMLResponse)) testSAMLResponse {
+		changed := defaultTestSAMLResponse()
+		change(&changed)
+		return changed
+	}
+
+	// an attacker-made assertion next to the genuine one, or the genuine one hidden where it isn't read
+	evil := withResponse(func(r *testSAMLResponse) { r.nameID = "admin@example.org" })
+	extraAssertion := withResponse(func(r *testSAMLResponse) { r.extra = evil.assertion("_evil", false) })
+	duplicateId := withResponse(func(r *testSAMLResponse) {
+		r.extra = `<samlp:Extensions>` + evil.assertion("_assertion", false) + `</samlp:Extensions>`
+	})
+	wrapped := `<samlp:Response xmlns:samlp="` + samlProtocolNS + `" xmlns:saml="` + samlAssertionNS + `" ID="_response" Version="2.0"` +
+		` Destination="` + testSAMLACSURL + `" InResponseTo="` + testSAMLRequestId + `">` +
+		`<saml:Issuer>` + testSAMLIdP + `</saml:Issuer>` +
+		`<samlp:Status><samlp:StatusCode Value="` + samlStatusSuccess + `"/></samlp:Status>` +
+		`<samlp:Extensions>` + signer.sign(t, response.assertion("_assertion", true), "_assertion") + `</samlp:Extensions>` +
+		evil.assertion("_evil", false) +
+		`</samlp:Response>`
+
+	tests := []struct {
+		name      string
+		document  string
+		requestId string
+		now       time.Time
+		want      string
+	}{
+		{
+			name:     "unsigned",
+			document: response.document(false, false),
+			want:     "not signed",
+		},
+		{
+			name:     "signed by another key",
+			document: otherSigner.sign(t, response.document(false, true), "_assertion"),
+			want:     "signature is invalid",
+		},
+		{
+			name:     "tampered NameID",
+			document: strings.Replace(signedAssertion, "<saml:NameID>alice@example.org<", "<saml:NameID>admin@example.org<", 1),
+			want:     "digest does not match",
+		},
+		{
+			name:     "tampered response",
+			document: strings.Replace(signer.sign(t, response.document(true, false), "_response"), "alice@example.org</saml:NameID>", "admin@example.org</saml:NameID>", 1),
+			want:     "digest does not match",
+		},
+		{
+			name:     "extra unsigned assertion",
+			document: signer.sign(t, extraAssertion.document(false, true), "_assertion"),
+			want:     "exactly one assertion",
+		},
+		{
+			name:     "duplicated assertion id",
+			document: signer.sign(t, duplicateId.document(false, true), "_assertion"),
+			want:     "duplicated ids",
+		},
+		{
+			name:     "signed assertion moved aside",
+			document: wrapped,
+			want:     "not signed",
+		},
+		{
+			name:     "wrong audience",
+			document: signer.sign(t, withResponse(func(r *testSAMLResponse) { r.audience = "https://other.example.org" }).document(false, true), "_assertion"),
+			want:     "not meant for this service provider",
+		},
+		{
+			name:      "wrong InResponseTo",
+			document:  signedAssertion,
+			requestId: "_request-2",
+			want:      "does not answer the login request",
+		},
+		{
+			name:     "wrong Destination",
+			document: signer.sign(t, withResponse(func(r *testSAMLResponse) { r.destination = "https://evil.example.org/acs" }).document(false, true), "_assertion"),
+			want:     "destination does not match",
+		},
+		{
+			name:     "expired conditions",
+			document: signedAssertion,
+			now:      testSAMLNow.Add(time.Hour),
+			want:     "expired",
+		},
+		{
+			name:     "not valid yet",
+			document: signedAssertion,
+			now:      testSAMLNow.Add(-time.Hour),
+			want:     "not valid yet",
+		},
+		{
+			name:     "DTD",
+			document: `<?xml version="1.0"?><!DOCTYPE Response [<!ENTITY name "alice@example.org">]>` + signedAssertion,
+			want:     "DTDs are not allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requestId, now := tt.requestId, tt.now
+			if requestId == "" {
+				requestId = testSAMLRequestId
+			}
+			if now.IsZero() {
+				now = testSAMLNow
+			}
+
+			user, err := ParseSAMLResponse(testSAMLConfig(signer), encodeTestSAMLResponse(tt.document), requestId, now)
+			if err == nil {
+				t.Fatalf("ParseSAMLResponse() user = %+v, want an error containing %q", user, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseSAMLResponse() error = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeExclusive(t *testing.T) {
+	document := `<a:root xmlns:a="urn:a" xmlns:b="urn:b" xmlns:unused="urn:unused" z="1" b:y="2" a="3&amp;&quot;">` +
+		`<child xmlns:c="urn:c" c:x="tab&#x9;">t&lt;x &gt; "q"</child><b:empty/></a:root>`
+
+	root, err := parseXMLDocument([]byte(document))
+	if err != nil {
+		t.Fatalf("parseXMLDocument() error = %v", err)
+	}
+
+	want := `<a:root xmlns:a="urn:a" xmlns:b="urn:b" a="3&amp;&quot;" z="1" b:y="2">` +
+		`<child xmlns:c="urn:c" c:x="tab&#x9;">t&lt;x &gt; "q"</child><b:empty></b:empty></a:root>`
+	if got := string(canonicalizeExclusive(root, nil, nil)); got != want {
+		t.Errorf("canonicalizeExclusive() =\n%s\nwant\n%s", got, want)
+	}
+
+	// a subtree renders the namespaces it uses itself, inclusive prefixes are added when in scope
+	child := root.ChildElements("", "child")[0]
+	if got, want := string(canonicalizeExclusive(child, []string{"b"}, nil)), `<child xmlns:b="urn:b" xmlns:c="urn:c" c:x="tab&#x9;">t&lt;x &gt; "q"</child>`; got != want {
+		t.Errorf("canonicalizeExclusive(child) = %s, want %s", got, want)
+	}
+
+	// the skipped element leaves no trace
+	if got, want := string(canonicalizeExclusive(root, nil, root.ChildElement("urn:b", "empty"))), `<a:root xmlns:a="urn:a" xmlns:b="urn:b" a="3&amp;&quot;" z="1" b:y="2"><child xmlns:c="urn:c" c:x="tab&#x9;">t&lt;x &gt; "q"</child></a:root>`; got != want {
+		t.Errorf("canonicalizeExclusive(skip) = %s, want %s", got, want)
+	}
+}
+
+func TestParseXMLDocumentInvalid(t *testing.T) {
+	tests := map[string]string{
+		"DTD":            `<!DOCTYPE a [<!ENTITY x "y">]><a>&x;</a>`,
+		"multiple roots": `<a></a><b></b>`,
+		"text outside":   `<a></a>text`,
+		"incomplete":     `<a><b></b>`,
+		"mismatched":     `<a></b>`,
+		"too deep":       strings.Repeat("<a>", maxXMLDepth+2) + strings.Repeat("</a>", maxXMLDepth+2),
+	}
+
+	for name, document := range tests {
+		if _, err := parseXMLDocument([]byte(document)); err == nil {
+			t.Errorf("parseXMLDocument(%s) error = nil, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/thuongnn/clst-mgt-api/models"
+)
+
+// GenerateSigningKey Generate a new RSA key for signing tokens
+func GenerateSigningKey() (*rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, SigningKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate signing key: %w", err)
+	}
+	return key, nil
+}
+
+// ParseBase64SigningKey Parse a base64 encoded PEM private key, the format of the token keys in env
+func ParseBase64SigningKey(encoded string) (*rsa.PrivateKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode key: %w", err)
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("parse key: %w", err)
+	}
+
+	return key, nil
+}
+
+// EncodeSigningKey Encode the private and the public key as PEM
+func EncodeSigningKey(key *rsa.PrivateKey) (string, string, error) {
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", fmt.Errorf("could not encode public key: %w", err)
+	}
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	return string(privateKey), string(publicKey), nil
+}
+
+// SigningKeyId Get the RFC 7638 thumbprint of the public key, it is used as kid
+func SigningKeyId(key *rsa.PublicKey) string {
+	// members in lexicographic order without whitespace, as the RFC requires
+	thumbprint, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+	})
+
+	hash := sha256.Sum256(thumbprint)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// PublicJSONWebKey Get the JWKS entry of a public key
+func PublicJSONWebKey(kid string, key *rsa.PublicKey) models.JSONWebKey {
+	return models.JSONWebKey{
+		Kid: kid,
+		Kty: "RSA",
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
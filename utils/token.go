@@ -1,63 +1,95 @@
 package utils
 
 import (
-	"encoding/base64"
+	"crypto/rsa"
 	"encoding/json"
 	"fmt"
+	"github.com/thuongnn/clst-mgt-api/config"
 	"github.com/thuongnn/clst-mgt-api/models"
 	"golang.org/x/oauth2"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
-func CreateToken(ttl time.Duration, payload interface{}, privateKey string) (string, error) {
-	decodedPrivateKey, err := base64.StdEncoding.DecodeString(privateKey)
+// TokenSigner is the key tokens are currently signed with, its kid is put in the header
+type TokenSigner struct {
+	Kid string
+	Key *rsa.PrivateKey
+}
+
+// TokenKeyFunc Get the public key of the kid a token was signed with
+type TokenKeyFunc func(kid string) (*rsa.PublicKey, error)
+
+func tokenIssuerAndAudience() (string, string) {
+	appConfig, err := config.LoadConfig(".")
 	if err != nil {
-		return "", fmt.Errorf("could not decode key: %w", err)
+		return config.DefaultTokenIssuer, config.DefaultTokenAudience
 	}
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(decodedPrivateKey)
+	return appConfig.TokenIssuer, appConfig.TokenAudience
+}
 
+func CreateToken(ttl time.Duration, payload interface{}, sessionId string, signer *TokenSigner) (*TokenDetails, error) {
+	tokenId, err := GenerateRandomToken(16)
 	if err != nil {
-		return "", fmt.Errorf("create: parse key: %w", err)
+		return nil, fmt.Errorf("create: generate token id: %w", err)
 	}
 
 	now := time.Now().UTC()
+	issuer, audience := tokenIssuerAndAudience()
 
 	claims := make(jwt.MapClaims)
 	claims["sub"] = payload
+	claims["jti"] = tokenId
+	claims["sid"] = sessionId
+	claims["iss"] = issuer
+	claims["aud"] = audience
 	claims["exp"] = now.Add(ttl).Unix()
 	claims["iat"] = now.Unix()
 	claims["nbf"] = now.Unix()
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	jwtToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	jwtToken.Header["kid"] = signer.Kid
+	token, err := jwtToken.SignedString(signer.Key)
 
 	if err != nil {
-		return "", fmt.Errorf("create: sign token: %w", err)
+		return nil, fmt.Errorf("create: sign token: %w", err)
 	}
 
-	return token, nil
+	return &TokenDetails{
+		Token:     token,
+		TokenId:   tokenId,
+		Subject:   fmt.Sprint(payload),
+		SessionId: sessionId,
+		IssuedAt:  time.Unix(now.Unix(), 0),
+		ExpiresAt: time.Unix(now.Add(ttl).Unix(), 0),
+	}, nil
 }
 
-func ValidateToken(token string, publicKey string) (interface{}, error) {
-	decodedPublicKey, err := base64.StdEncoding.DecodeString(publicKey)
-	if err != nil {
-		return nil, fmt.Errorf("could not decode: %w", err)
-	}
-
-	key, err := jwt.ParseRSAPublicKeyFromPEM(decodedPublicKey)
+// TokenDetails Claims of a validated token
+type TokenDetails struct {
+	Token     string
+	TokenId   string
+	Subject   string
+	SessionId string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
 
-	if err != nil {
-		return "", fmt.Errorf("validate: parse key: %w", err)
-	}
+// IssuedBefore Report whether the token was issued before the given time, e.g. a password change
+func (td *TokenDetails) IssuedBefore(t time.Time) bool {
+	return !t.IsZero() && td.IssuedAt.Before(t.Truncate(time.Second))
+}
 
+func ValidateToken(token string, keyFunc TokenKeyFunc) (*TokenDetails, error) {
 	parsedToken, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, fmt.Errorf("unexpected method: %s", t.Header["alg"])
 		}
-		return key, nil
+
+		kid, _ := t.Header["kid"].(string)
+		return keyFunc(kid)
 	})
 
 	if err != nil {
@@ -69,25 +101,26 @@ func ValidateToken(token string, publicKey string) (interface{}, error) {
 		return nil, fmt.Errorf("validate: invalid token")
 	}
 
-	return claims["sub"], nil
-}
-
-func DecodeOauth2Token[T any](tokenString string, out *T) error {
-	parts := strings.Split(tokenString, ".")
-	if len(parts) != 3 {
-		return fmt.Errorf("invalid JWT token")
+	// every token must name us, the ones issued before key rotation had no iss and aud and are refused
+	issuer, audience := tokenIssuerAndAudience()
+	if !claims.VerifyIssuer(issuer, true) {
+		return nil, fmt.Errorf("validate: unexpected issuer %v", claims["iss"])
 	}
-
-	payload, err := jwt.DecodeSegment(parts[1])
-	if err != nil {
-		return fmt.Errorf("failed to decode JWT payload: %v", err)
+	if !claims.VerifyAudience(audience, true) {
+		return nil, fmt.Errorf("validate: unexpected audience %v", claims["aud"])
 	}
 
-	if err := json.Unmarshal(payload, out); err != nil {
-		return fmt.Errorf("failed to parse JWT claims: %v", err)
+	details := &TokenDetails{Token: token, Subject: fmt.Sprint(claims["sub"])}
+	details.TokenId, _ = claims["jti"].(string)
+	details.SessionId, _ = claims["sid"].(string)
+	if iat, ok := claims["iat"].(float64); ok {
+		details.IssuedAt = time.Unix(int64(iat), 0)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		details.ExpiresAt = time.Unix(int64(exp), 0)
 	}
 
-	return nil
+	return details, nil
 }
 
 func FetchWellKnownConfig(wellKnownConfigUrl string) (*models.WellKnownConfig, error) {
@@ -109,10 +142,10 @@ func FetchWellKnownConfig(wellKnownConfigUrl string) (*models.WellKnownConfig, e
 	return &config, nil
 }
 
-func ParseOAuth2Config(oauth2Info models.OAuth2Config) (*oauth2.Config, error) {
+func ParseOAuth2Config(oauth2Info models.OAuth2Config) (*oauth2.Config, *models.WellKnownConfig, error) {
 	wellKnownConfig, err := FetchWellKnownConfig(oauth2Info.WellKnownConfigURL)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	return &oauth2.Config{
@@ -124,5 +157,5 @@ func ParseOAuth2Config(oauth2Info models.OAuth2Config) (*oauth2.Config, error) {
 			TokenURL: wellKnownConfig.TokenURL,
 		},
 		Scopes: oauth2Info.Scopes,
-	}, nil
+	}, wellKnownConfig, nil
 }
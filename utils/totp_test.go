@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 seed "12345678901234567890" of the RFC 6238 test vectors, base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// the RFC lists 8 digit codes, the last 6 digits are the 6 digit codes
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, vector := range vectors {
+		step, ok := ValidateTOTP(rfc6238Secret, vector.code, 0, time.Unix(vector.unix, 0))
+		if !ok {
+			t.Errorf("code %s at %d was rejected", vector.code, vector.unix)
+			continue
+		}
+		if step != vector.unix/totpPeriod {
+			t.Errorf("code %s at %d matched step %d, want %d", vector.code, vector.unix, step, vector.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	// the code of step 37037037 is 050471 (unix 1111111111)
+	const code = "050471"
+	const codeStep = int64(1111111111 / totpPeriod)
+	stepStart := time.Unix(codeStep*totpPeriod, 0)
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		wantOk bool
+	}{
+		{"same step", stepStart.Add(10 * time.Second), true},
+		{"one step later", stepStart.Add(totpPeriod * time.Second), true},
+		{"one step earlier", stepStart.Add(-totpPeriod * time.Second), true},
+		{"two steps later", stepStart.Add(2 * totpPeriod * time.Second), false},
+		{"two steps earlier", stepStart.Add(-2 * totpPeriod * time.Second), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(rfc6238Secret, code, 0, tt.now)
+			if ok != tt.wantOk {
+				t.Fatalf("ValidateTOTP() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if ok && step != codeStep {
+				t.Errorf("ValidateTOTP() step = %d, want %d", step, codeStep)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	codeStep := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name        string
+		code        string
+		lastCounter int64
+		wantOk      bool
+	}{
+		{"never used", "050471", 0, true},
+		{"older code used", "050471", codeStep - 1, true},
+		{"same code used", "050471", codeStep, false},
+		{"newer code used", "050471", codeStep + 1, false},
+		{"previous step code after the current one", hotp(mustDecodeTOTPSecret(t), codeStep-1), codeStep, false},
+		{"next step code after the current one", hotp(mustDecodeTOTPSecret(t), codeStep+1), codeStep, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(rfc6238Secret, tt.code, tt.lastCounter, now); ok != tt.wantOk {
+				t.Errorf("ValidateTOTP() ok = %v, want %v", ok, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPInvalidInput(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"short code", rfc6238Secret, "05047"},
+		{"long code", rfc6238Secret, "0504711"},
+		{"wrong code", rfc6238Secret, "123456"},
+		{"invalid secret", "not base32!", "050471"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, 0, now); ok {
+				t.Errorf("ValidateTOTP() accepted %q", tt.code)
+			}
+		})
+	}
+}
+
+func mustDecodeTOTPSecret(t *testing.T) []byte {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("could not decode secret: %v", err)
+	}
+	return key
+}
@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	xmlnsPrefix      = "xmlns"
+	xmlNamespaceURI  = "http://www.w3.org/XML/1998/namespace"
+	maxXMLDepth      = 64
+	maxXMLElements   = 10000
+	excC14NAlgorithm = "http://www.w3.org/2001/10/xml-exc-c14n#"
+)
+
+// xmlElement is a small DOM that keeps the namespace prefixes as written,
+// which encoding/xml drops but exclusive canonicalization needs
+type xmlElement struct {
+	Prefix     string
+	Local      string
+	Attrs      []xml.Attr
+	Children   []interface{} // *xmlElement or xmlText
+	Parent     *xmlElement
+	namespaces map[string]string // in-scope prefix → URI, including inherited ones
+}
+
+type xmlText string
+
+// parseXMLDocument Parse the document, DTDs are refused so entity expansion can't be abused
+func parseXMLDocument(data []byte) (*xmlElement, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+
+	var root, current *xmlElement
+	elements := 0
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XML: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			elements++
+			if elements > maxXMLElements {
+				return nil, fmt.Errorf("invalid XML: too many elements")
+			}
+			if current == nil && root != nil {
+				return nil, fmt.Errorf("invalid XML: multiple root elements")
+			}
+
+			element := &xmlElement{Prefix: t.Name.Space, Local: t.Name.Local, Parent: current, namespaces: map[string]string{"xml": xmlNamespaceURI}}
+			depth := 0
+			if current != nil {
+				for prefix, uri := range current.namespaces {
+					element.namespaces[prefix] = uri
+				}
+				for p := current; p != nil; p = p.Parent {
+					depth++
+				}
+			}
+			if depth > maxXMLDepth {
+				return nil, fmt.Errorf("invalid XML: nesting too deep")
+			}
+
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "" && attr.Name.Local == xmlnsPrefix:
+					element.namespaces[""] = attr.Value
+				case attr.Name.Space == xmlnsPrefix:
+					element.namespaces[attr.Name.Local] = attr.Value
+				default:
+					element.Attrs = append(element.Attrs, xml.Attr{Name: attr.Name, Value: attr.Value})
+				}
+			}
+
+			if current == nil {
+				root = element
+			} else {
+				current.Children = append(current.Children, element)
+			}
+			current = element
+		case xml.EndElement:
+			if current == nil || t.Name.Space != current.Prefix || t.Name.Local != current.Local {
+				return nil, fmt.Errorf("invalid XML: unexpected end element")
+			}
+			current = current.Parent
+		case xml.CharData:
+			if current != nil {
+				current.Children = append(current.Children, xmlText(t))
+			} else if len(bytes.TrimSpace(t)) > 0 {
+				return nil, fmt.Errorf("invalid XML: text outside of the root element")
+			}
+		case xml.Directive:
+			return nil, fmt.Errorf("invalid XML: DTDs are not allowed")
+		}
+	}
+
+	if root == nil || current != nil {
+		return nil, fmt.Errorf("invalid XML: incomplete document")
+	}
+
+	return root, nil
+}
+
+// Namespace Get the namespace URI of the element
+func (e *xmlElement) Namespace() string {
+	return e.namespaces[e.Prefix]
+}
+
+// Is Report whether the element has the namespace URI and local name
+func (e *xmlElement) Is(namespace string, local string) bool {
+	return e.Local == local && e.Namespace() == namespace
+}
+
+// Attr Get an unqualified attribute
+func (e *xmlElement) Attr(name string) string {
+	for _, attr := range e.Attrs {
+		if attr.Name.Space == "" && attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// ChildElements Get the direct children with the namespace URI and local name
+func (e *xmlElement) ChildElements(namespace string, local string) []*xmlElement {
+	var children []*xmlElement
+	for _, child := range e.Children {
+		if element, ok := child.(*xmlElement); ok && element.Is(namespace, local) {
+			children = append(children, element)
+		}
+	}
+	return children
+}
+
+// ChildElement Get the only direct child with the namespace URI and local name
+func (e *xmlElement) ChildElement(namespace string, local string) *xmlElement {
+	children := e.ChildElements(namespace, local)
+	if len(children) != 1 {
+		return nil
+	}
+	return children[0]
+}
+
+// Text Get the concatenated text content of the element
+func (e *xmlElement) Text() string {
+	var buf strings.Builder
+	for _, child := range e.Children {
+		switch c := child.(type) {
+		case xmlText:
+			buf.WriteString(string(c))
+		case *xmlElement:
+			buf.WriteString(c.Text())
+		}
+	}
+	return buf.String()
+}
+
+// walk Visit the element and all of its descendants
+func (e *xmlElement) walk(visit func(*xmlElement)) {
+	visit(e)
+	for _, child := range e.Children {
+		if element, ok := child.(*xmlElement); ok {
+			element.walk(visit)
+		}
+	}
+}
+
+// canonicalizeExclusive Serialize the subtree with Exclusive XML Canonicalization without comments,
+// the skip element (an enveloped signature) is left out
+func canonicalizeExclusive(e *xmlElement, inclusivePrefixes []string, skip *xmlElement) []byte {
+	var buf bytes.Buffer
+	writeExcC14N(&buf, e, map[string]string{}, inclusivePrefixes, skip)
+	return buf.Bytes()
+}
+
+func writeExcC14N(buf *bytes.Buffer, e *xmlElement, rendered map[string]string, inclusivePrefixes []string, skip *xmlElement) {
+	// namespaces visibly utilized by the element and its attributes, plus the inclusive ones
+	utilized := map[string]bool{e.Prefix: true}
+	for _, attr := range e.Attrs {
+		if attr.Name.Space != "" {
+			utilized[attr.Name.Space] = true
+		}
+	}
+	for _, prefix := range inclusivePrefixes {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		if _, inScope := e.namespaces[prefix]; inScope {
+			utilized[prefix] = true
+		}
+	}
+
+	var prefixes []string
+	for prefix := range utilized {
+		if prefix == "xml" {
+			continue
+		}
+		uri := e.namespaces[prefix]
+		previous, wasRendered := rendered[prefix]
+		if prefix == "" && uri == "" && (!wasRendered || previous == "") {
+			continue
+		}
+		if wasRendered && previous == uri {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	childRendered := rendered
+	if len(prefixes) > 0 {
+		childRendered = make(map[string]string, len(rendered)+len(prefixes))
+		for prefix, uri := range rendered {
+			childRendered[prefix] = uri
+		}
+	}
+
+	buf.WriteByte('<')
+	buf.WriteString(qualifiedXMLName(e.Prefix, e.Local))
+	for _, prefix := range prefixes {
+		uri := e.namespaces[prefix]
+		childRendered[prefix] = uri
+		if prefix == "" {
+			buf.WriteString(` xmlns="`)
+		} else {
+			buf.WriteString(` xmlns:` + prefix + `="`)
+		}
+		buf.WriteString(escapeC14NAttr(uri))
+		buf.WriteByte('"')
+	}
+
+	attrs := make([]xml.Attr, len(e.Attrs))
+	copy(attrs, e.Attrs)
+	sort.SliceStable(attrs, func(i, j int) bool {
+		iSpace, jSpace := "", ""
+		if attrs[i].Name.Space != "" {
+			iSpace = e.namespaces[attrs[i].Name.Space]
+		}
+		if attrs[j].Name.Space != "" {
+			jSpace = e.namespaces[attrs[j].Name.Space]
+		}
+		if iSpace != jSpace {
+			return iSpace < jSpace
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+	for _, attr := range attrs {
+		buf.WriteByte(' ')
+		buf.WriteString(qualifiedXMLName(attr.Name.Space, attr.Name.Local))
+		buf.WriteString(`="`)
+		buf.WriteString(escapeC14NAttr(attr.Value))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+
+	for _, child := range e.Children {
+		switch c := child.(type) {
+		case xmlText:
+			buf.WriteString(escapeC14NText(string(c)))
+		case *xmlElement:
+			if c != skip {
+				writeExcC14N(buf, c, childRendered, inclusivePrefixes, skip)
+			}
+		}
+	}
+
+	buf.WriteString("</")
+	buf.WriteString(qualifiedXMLName(e.Prefix, e.Local))
+	buf.WriteByte('>')
+}
+
+func qualifiedXMLName(prefix string, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+var (
+	c14nTextReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	c14nAttrReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeC14NText(s string) string {
+	return c14nTextReplacer.Replace(s)
+}
+
+func escapeC14NAttr(s string) string {
+	return c14nAttrReplacer.Replace(s)
+}